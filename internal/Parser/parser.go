@@ -10,6 +10,8 @@ package parser
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	tok "github.com/razzat008/letsgodb/internal/Tokenizer"
 )
@@ -28,10 +30,21 @@ type SelectStatement struct {
 	Columns []string
 	Table   string
 	Where   Expr
+	OrderBy []OrderItem // empty: rows come in the order they are read
+	Limit   *int64      // most rows returned; nil means no LIMIT
+	Offset  int64       // rows skipped before the first one returned
 }
 
 func (s *SelectStatement) StatementNode() {}
 
+// OrderItem is one column of an ORDER BY clause.
+// NULLs come last ascending and first descending unless NULLS FIRST or NULLS LAST says otherwise.
+type OrderItem struct {
+	Column     string
+	Desc       bool
+	NullsFirst bool
+}
+
 // AST for SHOW DATABASES
 type ShowDatabasesStatement struct{}
 
@@ -54,6 +67,7 @@ func (i *InsertStatement) StatementNode() {}
 type CreateTableStatement struct {
 	TableName string
 	Columns   []string
+	Types     []string // declared type per column, "" when omitted
 }
 
 type DropStatement struct {
@@ -71,8 +85,58 @@ type DeleteStatement struct {
 
 func (d *DeleteStatement) StatementNode() {}
 
+// AST for UPDATE table SET col = value, ... [WHERE ...]
+type UpdateStatement struct {
+	Table       string
+	Assignments []Assignment
+	Where       Expr
+}
+
+// Assignment is one `column = value` pair of a SET clause
+type Assignment struct {
+	Column string
+	Value  string
+	Ref    bool // Value is the unquoted name of a column whose old value is assigned, not a literal
+}
+
+func (u *UpdateStatement) StatementNode() {}
+
 func (c *CreateTableStatement) StatementNode() {}
 
+// AST for CREATE [UNIQUE] INDEX name ON table (col, ...)
+type CreateIndexStatement struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+}
+
+func (c *CreateIndexStatement) StatementNode() {}
+
+// AST for DROP INDEX name
+type DropIndexStatement struct {
+	Name string
+}
+
+func (d *DropIndexStatement) StatementNode() {}
+
+// AST for EXPLAIN [ANALYZE] SELECT ...
+type ExplainStatement struct {
+	Analyze bool // run the query and report actual row counts and times
+	Query   *SelectStatement
+}
+
+func (e *ExplainStatement) StatementNode() {}
+
+// AST for BEGIN [TRANSACTION], COMMIT and ROLLBACK
+type BeginStatement struct{}
+type CommitStatement struct{}
+type RollbackStatement struct{}
+
+func (b *BeginStatement) StatementNode()    {}
+func (c *CommitStatement) StatementNode()   {}
+func (r *RollbackStatement) StatementNode() {}
+
 type Condition struct {
 	Column   string
 	Operator string
@@ -89,6 +153,50 @@ type BinaryExpr struct {
 
 func (b *BinaryExpr) exprNode() {}
 
+// IsNullExpr is `column IS NULL` (or `column IS NOT NULL` when Not is set)
+type IsNullExpr struct {
+	Column string
+	Not    bool
+}
+
+func (i *IsNullExpr) exprNode() {}
+
+// NotExpr negates the expression it wraps: NOT expr
+type NotExpr struct {
+	Expr Expr
+}
+
+func (n *NotExpr) exprNode() {}
+
+// FormatExpr renders a WHERE expression back as SQL, e.g. "age >= 18 AND (name = 'a' OR name IS NULL)".
+func FormatExpr(expr Expr) string {
+	switch e := expr.(type) {
+	case *Condition:
+		return e.Column + " " + e.Operator + " " + e.Value
+	case *IsNullExpr:
+		if e.Not {
+			return e.Column + " IS NOT NULL"
+		}
+		return e.Column + " IS NULL"
+	case *NotExpr:
+		if _, ok := e.Expr.(*BinaryExpr); ok {
+			return "NOT (" + FormatExpr(e.Expr) + ")"
+		}
+		return "NOT " + FormatExpr(e.Expr)
+	case *BinaryExpr:
+		op := strings.ToUpper(e.Operator)
+		side := func(x Expr) string {
+			// AND binds tighter than OR, so only a mix of the two needs parentheses
+			if b, ok := x.(*BinaryExpr); ok && strings.ToUpper(b.Operator) != op {
+				return "(" + FormatExpr(x) + ")"
+			}
+			return FormatExpr(x)
+		}
+		return side(e.Left) + " " + op + " " + side(e.Right)
+	}
+	return ""
+}
+
 // AST for CREATE DATABASE
 type CreateDatabaseStatement struct {
 	DatabaseName string
@@ -109,6 +217,14 @@ type Parser struct {
 	position     int         // current position in the token stream
 	currentToken tok.Token   // currently processed token
 	peekToken    tok.Token   // lookahead token (next token)
+	err          error       // first syntax error found
+}
+
+// errorf records a syntax error; only the first one is kept since later ones are usually caused by it.
+func (p *Parser) errorf(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
 }
 
 /* Initializing Parser  */
@@ -154,18 +270,18 @@ func (p *Parser) parseCreateDatabase() *CreateDatabaseStatement {
 	// Expect: CREATE DATABASE dbname;
 	p.nextToken() // move to DATABASE
 	if p.currentToken.Type != tok.TokenDatabase {
-		fmt.Printf("Syntax error: expected DATABASE after CREATE, got %v\n", p.currentToken.Type)
+		p.errorf("expected DATABASE after CREATE, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken() // move to dbname
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Printf("Syntax error: expected database name, got %v\n", p.currentToken.Type)
+	if !p.currentToken.IsName() {
+		p.errorf("expected database name, got %v", p.currentToken.Type)
 		return nil
 	}
 	dbname := p.currentToken.CurrentToken
 	p.nextToken()
 	if p.currentToken.Type != tok.TokenSemiColon {
-		fmt.Printf("Syntax error: expected ';' at end of statement, got %v\n", p.currentToken.Type)
+		p.errorf("expected ';' at end of statement, got %v", p.currentToken.Type)
 		return nil
 	}
 	return &CreateDatabaseStatement{DatabaseName: dbname}
@@ -174,14 +290,14 @@ func (p *Parser) parseCreateDatabase() *CreateDatabaseStatement {
 func (p *Parser) parseUseDatabase() *UseDatabaseStatement {
 	// Expect: USE dbname;
 	p.nextToken() // move to dbname
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Printf("Syntax error: expected database name after USE, got %v\n", p.currentToken.Type)
+	if !p.currentToken.IsName() {
+		p.errorf("expected database name after USE, got %v", p.currentToken.Type)
 		return nil
 	}
 	dbname := p.currentToken.CurrentToken
 	p.nextToken()
 	if p.currentToken.Type != tok.TokenSemiColon {
-		fmt.Printf("Syntax error: expected ';' at end of statement, got %v\n", p.currentToken.Type)
+		p.errorf("expected ';' at end of statement, got %v", p.currentToken.Type)
 		return nil
 	}
 	return &UseDatabaseStatement{DatabaseName: dbname}
@@ -192,28 +308,52 @@ func (p *Parser) parsePrimaryExpr() Expr {
 		p.nextToken()
 		expr := p.parseExpr()
 		if p.currentToken.Type != tok.TokenRightParen {
-			fmt.Println("Syntax error: expected ')' after expression")
+			p.errorf("expected ')' after expression")
 			return nil
 		}
 		p.nextToken()
 		return expr
 	}
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Println("Syntax error: expected column name")
+	if p.currentToken.Type == tok.TokenNot {
+		p.nextToken()
+		expr := p.parsePrimaryExpr()
+		if expr == nil {
+			return nil
+		}
+		return &NotExpr{Expr: expr}
+	}
+	if !p.currentToken.IsName() {
+		p.errorf("expected column name")
 		return nil
 	}
 	column := p.currentToken.CurrentToken
 	p.nextToken()
 
+	// column IS [NOT] NULL
+	if p.currentToken.Type == tok.TokenIs {
+		p.nextToken()
+		not := false
+		if p.currentToken.Type == tok.TokenNot {
+			not = true
+			p.nextToken()
+		}
+		if p.currentToken.Type != tok.TokenNull {
+			p.errorf("expected NULL after IS")
+			return nil
+		}
+		p.nextToken()
+		return &IsNullExpr{Column: column, Not: not}
+	}
+
 	if p.currentToken.Type != tok.TokenOperator {
-		fmt.Println("Syntax error: expected operator")
+		p.errorf("expected operator")
 		return nil
 	}
 	operator := p.currentToken.CurrentToken
 	p.nextToken()
 
-	if p.currentToken.Type != tok.TokenIdentifier && p.currentToken.Type != tok.TokenValue {
-		fmt.Println("Syntax error: expected value")
+	if !p.currentToken.IsName() && p.currentToken.Type != tok.TokenValue && p.currentToken.Type != tok.TokenNull {
+		p.errorf("expected value")
 		return nil
 	}
 	value := p.currentToken.CurrentToken
@@ -228,134 +368,300 @@ func (p *Parser) parsePrimaryExpr() Expr {
 
 // Parse CREATE TABLE statement
 func (p *Parser) parseCreateTable() *CreateTableStatement {
-	// Expect: CREATE TABLE table_name (primary_key col1, col2, ...)
+	// Expect: CREATE TABLE table_name (primary_key col1 [type], col2 [type], ...)
 	p.nextToken() // move to TABLE
 	if p.currentToken.Type != tok.TokenTable {
-		fmt.Printf("Syntax error: expected TABLE after CREATE, got %v (did you forget the TABLE keyword?)\n", p.currentToken.Type)
+		p.errorf("expected TABLE after CREATE, got %v (did you forget the TABLE keyword?)", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken() // move to table name
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Printf("Syntax error: expected table name, got %v\n", p.currentToken.Type)
+	if !p.currentToken.IsName() {
+		p.errorf("expected table name, got %v", p.currentToken.Type)
 		return nil
 	}
 	tableName := p.currentToken.CurrentToken
 	p.nextToken()
 	if p.currentToken.Type != tok.TokenLeftParen {
-		fmt.Printf("Syntax error: expected '(' after table name, got %v\n", p.currentToken.Type)
+		p.errorf("expected '(' after table name, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken()
 	if p.currentToken.Type != tok.TokenPrimaryKey {
-		fmt.Printf("Syntax error: expected PRIMARY KEY after '(', got %v\n", p.currentToken.Type)
+		p.errorf("expected PRIMARY KEY after '(', got %v", p.currentToken.Type)
 		return nil
 	}
 
 	p.nextToken()
 	columns := []string{}
-	for p.currentToken.Type == tok.TokenIdentifier {
+	types := []string{}
+	for p.currentToken.IsName() {
 		columns = append(columns, p.currentToken.CurrentToken)
 		p.nextToken()
+		// optional column type, e.g. `age INTEGER`
+		colType := ""
+		if p.currentToken.Type == tok.TokenDataType {
+			colType = p.currentToken.CurrentToken
+			p.nextToken()
+		}
+		types = append(types, colType)
 		if p.currentToken.Type == tok.TokenComma {
 			p.nextToken()
 		}
 	}
 	if p.currentToken.Type != tok.TokenRightParen {
-		fmt.Printf("Syntax error: expected ')' after column list, got %v\n", p.currentToken.Type)
+		p.errorf("expected ')' after column list, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken()
 	if p.currentToken.Type != tok.TokenSemiColon {
-		fmt.Printf("Syntax error: expected ';' at end of statement, got %v\n", p.currentToken.Type)
+		p.errorf("expected ';' at end of statement, got %v", p.currentToken.Type)
 		return nil
 	}
-	return &CreateTableStatement{TableName: tableName, Columns: columns}
+	return &CreateTableStatement{TableName: tableName, Columns: columns, Types: types}
 }
 
-/* Entry point of the parser */
-func ParseProgram(Tokens []tok.Token) Statement {
-	if len(Tokens) == 0 {
-		fmt.Println("Empty input: no tokens to parse")
+// Parse CREATE [UNIQUE] INDEX statement
+func (p *Parser) parseCreateIndex() *CreateIndexStatement {
+	// Expect: CREATE [UNIQUE] INDEX index_name ON table_name (col1, col2, ...)
+	p.nextToken() // move to UNIQUE or INDEX
+	unique := false
+	if p.currentToken.Type == tok.TokenUnique {
+		unique = true
+		p.nextToken()
+	}
+	if p.currentToken.Type != tok.TokenIndex {
+		p.errorf("expected INDEX after UNIQUE, got %v", p.currentToken.Type)
+		return nil
+	}
+	p.nextToken() // move to index name
+	if !p.currentToken.IsName() {
+		p.errorf("expected index name, got %v", p.currentToken.Type)
+		return nil
+	}
+	name := p.currentToken.CurrentToken
+	p.nextToken()
+	if p.currentToken.Type != tok.TokenOn {
+		p.errorf("expected ON after index name, got %v", p.currentToken.Type)
+		return nil
+	}
+	p.nextToken() // move to table name
+	if !p.currentToken.IsName() {
+		p.errorf("expected table name after ON, got %v", p.currentToken.Type)
+		return nil
+	}
+	table := p.currentToken.CurrentToken
+	p.nextToken()
+	if p.currentToken.Type != tok.TokenLeftParen {
+		p.errorf("expected '(' after table name, got %v", p.currentToken.Type)
 		return nil
 	}
+	p.nextToken()
+	columns := p.parseColumns()
+	if columns == nil {
+		return nil
+	}
+	if len(columns) == 0 {
+		p.errorf("expected at least one column to index")
+		return nil
+	}
+	if p.currentToken.Type != tok.TokenSemiColon {
+		p.errorf("expected ';' at end of statement, got %v", p.currentToken.Type)
+		return nil
+	}
+	return &CreateIndexStatement{Name: name, Table: table, Columns: columns, Unique: unique}
+}
+
+// Parse DROP INDEX statement
+func (p *Parser) parseDropIndex() *DropIndexStatement {
+	// Expect: DROP INDEX index_name
+	p.nextToken() // move to INDEX
+	p.nextToken() // move to index name
+	if !p.currentToken.IsName() {
+		p.errorf("expected index name after DROP INDEX, got %v", p.currentToken.Type)
+		return nil
+	}
+	name := p.currentToken.CurrentToken
+	p.nextToken()
+	if p.currentToken.Type != tok.TokenSemiColon {
+		p.errorf("expected ';' at end of statement, got %v", p.currentToken.Type)
+		return nil
+	}
+	return &DropIndexStatement{Name: name}
+}
+
+// Parse EXPLAIN [ANALYZE] statement
+func (p *Parser) parseExplain() *ExplainStatement {
+	p.nextToken() // move past EXPLAIN
+	analyze := p.currentToken.Type == tok.TokenAnalyze
+	if analyze {
+		p.nextToken()
+	}
+	if p.currentToken.Type != tok.TokenSelect {
+		p.errorf("EXPLAIN only supports SELECT, got %v", p.currentToken.Type)
+		return nil
+	}
+	query := p.parseSelect()
+	if query == nil {
+		return nil
+	}
+	return &ExplainStatement{Analyze: analyze, Query: query}
+}
+
+/*
+Parse builds the AST of one statement from its tokens.
+Syntax errors are returned instead of printed, so callers other than the REPL
+(the embedded API, the servers) can report them their own way.
+*/
+func Parse(Tokens []tok.Token) (Statement, error) {
+	if len(Tokens) == 0 {
+		return nil, fmt.Errorf("empty input: no tokens to parse")
+	}
 
 	p := &Parser{}
 	p.initParser(Tokens)
 
+	var stmt Statement
 	switch p.currentToken.Type {
 	case tok.TokenSelect:
-		stmt := p.parseSelect()
-		b, _ := json.MarshalIndent(stmt, "", "  ")
-		fmt.Println("Parsed SELECT statement:", string(b))
-		return stmt
+		stmt = p.parseSelect()
 	case tok.TokenInsert:
-		stmt := p.parseInsert()
-		b, _ := json.MarshalIndent(stmt, "", "  ")
-		fmt.Println("Parsed INSERT statement:", string(b))
-		return stmt
+		stmt = p.parseInsert()
+	case tok.TokenExplain:
+		stmt = p.parseExplain()
 	case tok.TokenCreate:
-		// Check for CREATE DATABASE
-		if p.peekToken.Type == tok.TokenDatabase {
-			stmt := p.parseCreateDatabase()
-			b, _ := json.MarshalIndent(stmt, "", "  ")
-			fmt.Println("Parsed CREATE DATABASE statement:", string(b))
-			return stmt
-		}
-		stmt := p.parseCreateTable()
-		b, _ := json.MarshalIndent(stmt, "", "  ")
-		fmt.Println("Parsed CREATE TABLE statement:", string(b))
-		return stmt
+		// Check for CREATE DATABASE and CREATE [UNIQUE] INDEX
+		switch p.peekToken.Type {
+		case tok.TokenDatabase:
+			stmt = p.parseCreateDatabase()
+		case tok.TokenIndex, tok.TokenUnique:
+			stmt = p.parseCreateIndex()
+		default:
+			stmt = p.parseCreateTable()
+		}
 	case tok.TokenDrop:
-		stmt := p.parseDrop()
-		b, _ := json.MarshalIndent(stmt, "", "  ")
-		fmt.Println("Parsed Drop TABLE statement:", string(b))
-		return stmt
+		if p.peekToken.Type == tok.TokenIndex {
+			stmt = p.parseDropIndex()
+		} else {
+			stmt = p.parseDrop()
+		}
 	case tok.TokenDelete:
-		stmt := p.parseDelete()
-		b, _ := json.MarshalIndent(stmt, "", "  ")
-		fmt.Println("Parsed Delete TABLE statement:", string(b))
-		return stmt
+		stmt = p.parseDelete()
+	case tok.TokenUpdate:
+		stmt = p.parseUpdate()
+	case tok.TokenBegin, tok.TokenCommit, tok.TokenRollback:
+		stmt = p.parseTransaction()
 	case tok.TokenUse:
-		stmt := p.parseUseDatabase()
-		b, _ := json.MarshalIndent(stmt, "", "  ")
-		fmt.Println("Parsed USE DATABASE statement:", string(b))
-		return stmt
-
+		stmt = p.parseUseDatabase()
 	case tok.TokenShow:
-		if p.peekToken.Type == tok.TokenIdentifier && (p.peekToken.CurrentToken == "DATABASES" || p.peekToken.CurrentToken == "databases") {
+		if p.peekToken.Type == tok.TokenIdentifier && strings.EqualFold(p.peekToken.CurrentToken, "DATABASES") {
 			p.nextToken() // move to DATABASES
 			p.nextToken() // move to ;
 			if p.currentToken.Type != tok.TokenSemiColon {
-				fmt.Printf("Syntax error: expected ';' after SHOW DATABASES\n")
-				return nil
+				p.errorf("expected ';' after SHOW DATABASES")
 			}
-			stmt := &ShowDatabasesStatement{}
-			b, _ := json.MarshalIndent(stmt, "", "  ")
-			fmt.Println("Parsed SHOW DATABASES statement:", string(b))
-			return stmt
+			stmt = &ShowDatabasesStatement{}
+		} else {
+			p.errorf("expected DATABASES after SHOW")
 		}
-		fmt.Printf("Syntax error: expected DATABASES after SHOW\n")
-		return nil
 	case tok.TokenList:
 		// Support: LIST TABLE;
 		if p.peekToken.Type == tok.TokenTable {
 			p.nextToken() // move to TABLE
 			p.nextToken() // move to ;
 			if p.currentToken.Type != tok.TokenSemiColon {
-				fmt.Printf("Syntax error: expected ';' after LIST TABLE\n")
-				return nil
+				p.errorf("expected ';' after LIST TABLE")
 			}
-			stmt := &ListTablesStatement{}
-			b, _ := json.MarshalIndent(stmt, "", "  ")
-			fmt.Println("Parsed LIST TABLE statement:", string(b))
-			return stmt
+			stmt = &ListTablesStatement{}
+		} else {
+			p.errorf("expected TABLE after LIST")
 		}
-		fmt.Printf("Syntax error: expected TABLE after LIST\n")
-		return nil
 	default:
-		fmt.Printf("Unknown or unsupported operation: %v\n", p.currentToken.Type)
+		return nil, fmt.Errorf("unknown or unsupported operation: %v", p.currentToken.Type)
+	}
+	// a statement ends at ';': anything left over, such as a misspelt WHERE, must not be dropped silently
+	if p.err == nil && p.currentToken.Type != tok.TokenSemiColon && p.currentToken.Type != tok.TokenEOF {
+		p.errorf("unexpected %q after end of statement", p.currentToken.CurrentToken)
+	}
+	// the parse functions return a nil pointer on error, which is not a nil Statement
+	if p.err != nil {
+		return nil, p.err
+	}
+	return stmt, nil
+}
+
+/* Entry point of the parser used by the REPL: prints the AST, or the syntax error and returns nil */
+func ParseProgram(Tokens []tok.Token) Statement {
+	stmt, err := Parse(Tokens)
+	if err != nil {
+		fmt.Println("Syntax error:", err)
 		return nil
 	}
+	b, _ := json.MarshalIndent(stmt, "", "  ")
+	fmt.Printf("Parsed %s statement: %s\n", StatementName(stmt), b)
+	return stmt
+}
+
+// StatementName names the kind of a statement, e.g. "SELECT" or "CREATE TABLE".
+func StatementName(stmt Statement) string {
+	switch s := stmt.(type) {
+	case *SelectStatement:
+		return "SELECT"
+	case *InsertStatement:
+		return "INSERT"
+	case *ExplainStatement:
+		return "EXPLAIN"
+	case *UpdateStatement:
+		return "UPDATE"
+	case *DeleteStatement:
+		return "DELETE"
+	case *CreateDatabaseStatement:
+		return "CREATE DATABASE"
+	case *CreateTableStatement:
+		return "CREATE TABLE"
+	case *CreateIndexStatement:
+		return "CREATE INDEX"
+	case *DropIndexStatement:
+		return "DROP INDEX"
+	case *DropStatement:
+		if s.Table != "" {
+			return "DROP TABLE"
+		}
+		return "DROP DATABASE"
+	case *UseDatabaseStatement:
+		return "USE DATABASE"
+	case *ShowDatabasesStatement:
+		return "SHOW DATABASES"
+	case *ListTablesStatement:
+		return "LIST TABLE"
+	case *BeginStatement:
+		return "BEGIN"
+	case *CommitStatement:
+		return "COMMIT"
+	case *RollbackStatement:
+		return "ROLLBACK"
+	}
+	return "UNKNOWN"
+}
+
+/* Parses BEGIN [TRANSACTION]; COMMIT [TRANSACTION]; and ROLLBACK [TRANSACTION]; */
+func (p *Parser) parseTransaction() Statement {
+	keyword := p.currentToken
+	p.nextToken()
+	if p.currentToken.Type == tok.TokenTransaction {
+		p.nextToken()
+	}
+	if p.currentToken.Type != tok.TokenSemiColon {
+		p.errorf("expected ';' after %s, got %v", keyword.CurrentToken, p.currentToken.Type)
+		return nil
+	}
+	switch keyword.Type {
+	case tok.TokenBegin:
+		return &BeginStatement{}
+	case tok.TokenCommit:
+		return &CommitStatement{}
+	default:
+		return &RollbackStatement{}
+	}
 }
 
 /* Advance to the next token */
@@ -379,8 +685,8 @@ func (p *Parser) nextToken() {
 
 func (p *Parser) parseSelect() *SelectStatement {
 	// If token after SELECT is not an identifier or an asterisk
-	if p.peekToken.Type != tok.TokenIdentifier && p.peekToken.Type != tok.TokenAsterisk {
-		fmt.Printf("Syntax error: expected column name or '*' after SELECT, got %v\n", p.peekToken.Type)
+	if !p.peekToken.IsName() && p.peekToken.Type != tok.TokenAsterisk {
+		p.errorf("expected column name or '*' after SELECT, got %v", p.peekToken.Type)
 		return nil
 	}
 
@@ -392,7 +698,7 @@ func (p *Parser) parseSelect() *SelectStatement {
 
 	// Loop through all valid column tokens (identifiers or asterisk)
 	for {
-		if p.currentToken.Type != tok.TokenIdentifier && p.currentToken.Type != tok.TokenAsterisk {
+		if !p.currentToken.IsName() && p.currentToken.Type != tok.TokenAsterisk {
 			break
 		}
 
@@ -409,15 +715,15 @@ func (p *Parser) parseSelect() *SelectStatement {
 
 	// Expecting FROM keyword after columns
 	if p.currentToken.Type != tok.TokenFrom {
-		fmt.Printf("Syntax error: expected FROM clause, got %v\n", p.currentToken.Type)
+		p.errorf("expected FROM clause, got %v", p.currentToken.Type)
 		return nil
 	}
 
 	p.nextToken()
 
 	// Expecting a valid table name (identifier) after FROM
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Printf("Syntax error: expected table name after FROM, got %v\n", p.currentToken.Type)
+	if !p.currentToken.IsName() {
+		p.errorf("expected table name after FROM, got %v", p.currentToken.Type)
 		return nil
 	}
 
@@ -435,30 +741,128 @@ func (p *Parser) parseSelect() *SelectStatement {
 		}
 	}
 
-	return &SelectStatement{
+	var orderBy []OrderItem
+	if p.currentToken.Type == tok.TokenOrder {
+		if orderBy = p.parseOrderBy(); orderBy == nil {
+			return nil
+		}
+	}
+
+	stmt := &SelectStatement{
 		Columns: columns,
 		Table:   table,
 		Where:   where,
+		OrderBy: orderBy,
+	}
+	if p.currentToken.Type == tok.TokenLimit {
+		p.nextToken()
+		limit, ok := p.parseCount("LIMIT")
+		if !ok {
+			return nil
+		}
+		stmt.Limit = &limit
+	}
+	// OFFSET may also stand alone, skipping rows without capping the rest
+	if p.currentToken.Type == tok.TokenOffset {
+		p.nextToken()
+		offset, ok := p.parseCount("OFFSET")
+		if !ok {
+			return nil
+		}
+		stmt.Offset = offset
+	}
+	return stmt
+}
+
+/*
+isColumnRef reports whether a SET value names a column. Quoted text, NULL,
+numbers, TRUE/FALSE and x'..' blobs are literals; any other unquoted word is
+a column, whether or not the table has it, so 'active' and active never mean
+the same thing.
+*/
+func isColumnRef(t tok.Token) bool {
+	if !t.IsName() {
+		return false
+	}
+	word := t.CurrentToken
+	if strings.EqualFold(word, "TRUE") || strings.EqualFold(word, "FALSE") || strings.HasPrefix(strings.ToLower(word), "x'") {
+		return false
+	}
+	return !strings.ContainsRune("0123456789+-.", rune(word[0]))
+}
+
+// parseCount parses the non-negative number of rows after LIMIT or OFFSET.
+func (p *Parser) parseCount(clause string) (int64, bool) {
+	n, err := strconv.ParseInt(p.currentToken.CurrentToken, 10, 64)
+	if p.currentToken.Type != tok.TokenIdentifier || err != nil || n < 0 {
+		p.errorf("expected a number of rows after %s, got %q", clause, p.currentToken.CurrentToken)
+		return 0, false
+	}
+	p.nextToken()
+	return n, true
+}
+
+// parseOrderBy parses ORDER BY col [ASC|DESC] [NULLS FIRST|LAST], ...
+func (p *Parser) parseOrderBy() []OrderItem {
+	p.nextToken() // move to BY
+	if p.currentToken.Type != tok.TokenBy {
+		p.errorf("expected BY after ORDER, got %v", p.currentToken.Type)
+		return nil
+	}
+	var items []OrderItem
+	for {
+		p.nextToken()
+		if !p.currentToken.IsName() {
+			p.errorf("expected column name in ORDER BY, got %v", p.currentToken.Type)
+			return nil
+		}
+		item := OrderItem{Column: p.currentToken.CurrentToken}
+		p.nextToken()
+		switch p.currentToken.Type {
+		case tok.TokenAsc:
+			p.nextToken()
+		case tok.TokenDesc:
+			item.Desc = true
+			p.nextToken()
+		}
+		item.NullsFirst = item.Desc
+		if p.currentToken.Type == tok.TokenNulls {
+			p.nextToken()
+			switch p.currentToken.Type {
+			case tok.TokenFirst:
+				item.NullsFirst = true
+			case tok.TokenLast:
+				item.NullsFirst = false
+			default:
+				p.errorf("expected FIRST or LAST after NULLS, got %v", p.currentToken.Type)
+				return nil
+			}
+			p.nextToken()
+		}
+		items = append(items, item)
+		if p.currentToken.Type != tok.TokenComma {
+			return items
+		}
 	}
 }
 
 func (p *Parser) parseInsert() *InsertStatement {
 	if p.peekToken.Type != tok.TokenInto {
-		fmt.Printf("Syntax error: expected INTO , got %v\n", p.peekToken.Type)
+		p.errorf("expected INTO , got %v", p.peekToken.Type)
 		return nil
 	}
 	p.nextToken() // move to INTO
 	p.nextToken() // move to table name
 
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Printf("Syntax error: expected table name after INTO, got %v\n", p.currentToken.Type)
+	if !p.currentToken.IsName() {
+		p.errorf("expected table name after INTO, got %v", p.currentToken.Type)
 		return nil
 	}
 	table := p.currentToken.CurrentToken
 	p.nextToken()
 
 	if p.currentToken.Type != tok.TokenLeftParen {
-		fmt.Printf("Syntax error: expected '(' after table name, got %v\n", p.currentToken.Type)
+		p.errorf("expected '(' after table name, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken()
@@ -470,14 +874,14 @@ func (p *Parser) parseInsert() *InsertStatement {
 
 	// Expect VALUES keyword
 	if p.currentToken.Type != tok.TokenValues {
-		fmt.Printf("Syntax error: expected 'VALUES' after column list, got %v\n", p.currentToken.Type)
+		p.errorf("expected 'VALUES' after column list, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken()
 
 	// Expect '(' before values
 	if p.currentToken.Type != tok.TokenLeftParen {
-		fmt.Printf("Syntax error: expected '(' before values, got %v\n", p.currentToken.Type)
+		p.errorf("expected '(' before values, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken() // advance past LEFT_PAREN for values
@@ -489,14 +893,14 @@ func (p *Parser) parseInsert() *InsertStatement {
 
 	// Expect ')' after value list
 	if p.currentToken.Type != tok.TokenRightParen {
-		fmt.Printf("Syntax error: expected ')' after value list, got %v\n", p.currentToken.Type)
+		p.errorf("expected ')' after value list, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken()
 
 	// Expect ';' at end
 	if p.currentToken.Type != tok.TokenSemiColon {
-		fmt.Printf("Syntax error: expected ';' at end of statement, got %v\n", p.currentToken.Type)
+		p.errorf("expected ';' at end of statement, got %v", p.currentToken.Type)
 		return nil
 	}
 
@@ -509,7 +913,7 @@ func (p *Parser) parseInsert() *InsertStatement {
 
 func (p *Parser) parseColumns() []string {
 	columns := []string{}
-	for p.currentToken.Type == tok.TokenIdentifier {
+	for p.currentToken.IsName() {
 		columns = append(columns, p.currentToken.CurrentToken)
 		p.nextToken()
 		if p.currentToken.Type == tok.TokenComma {
@@ -518,7 +922,7 @@ func (p *Parser) parseColumns() []string {
 	}
 
 	if p.currentToken.Type != tok.TokenRightParen {
-		fmt.Printf("Syntax error: expected ')' after column list, got %v\n", p.currentToken.Type)
+		p.errorf("expected ')' after column list, got %v", p.currentToken.Type)
 		return nil
 	}
 	p.nextToken()
@@ -530,7 +934,7 @@ func (p *Parser) parseValues() [][]string {
 	values := []string{}
 	// Accept values until we hit a RIGHT_PAREN
 	for {
-		if p.currentToken.Type == tok.TokenValue || p.currentToken.Type == tok.TokenStringLiteral || p.currentToken.Type == tok.TokenIdentifier {
+		if p.currentToken.Type == tok.TokenValue || p.currentToken.Type == tok.TokenStringLiteral || p.currentToken.IsName() || p.currentToken.Type == tok.TokenNull {
 			values = append(values, p.currentToken.CurrentToken)
 			p.nextToken()
 			if p.currentToken.Type == tok.TokenComma {
@@ -558,24 +962,25 @@ func (p *Parser) parseDrop() *DropStatement {
 	switch p.currentToken.Type {
 	case tok.TokenTable:
 		p.nextToken()
-		if p.currentToken.Type != tok.TokenIdentifier {
-			fmt.Printf("Syntax error: expected IDENTIFIER, got %v\n", p.currentToken.Type)
+		if !p.currentToken.IsName() {
+			p.errorf("expected IDENTIFIER, got %v", p.currentToken.Type)
 			return nil
 		}
 		table = p.currentToken.CurrentToken
 
 		if p.peekToken.Type == tok.TokenSemiColon {
+			p.nextToken()
 			break
 		}
 		if p.peekToken.Type != tok.TokenLeftParen {
-			fmt.Printf("Syntax error: expected ( , got %v\n", p.peekToken.Type)
+			p.errorf("expected ( , got %v", p.peekToken.Type)
 			return nil
 		}
 		p.nextToken() // at parenthesis
 		p.nextToken() // at identifier
 
 		//loops through identifier
-		for p.currentToken.Type == tok.TokenIdentifier {
+		for p.currentToken.IsName() {
 			columns = append(columns, p.currentToken.CurrentToken)
 			p.nextToken()
 			if p.currentToken.Type == tok.TokenComma {
@@ -583,23 +988,25 @@ func (p *Parser) parseDrop() *DropStatement {
 			}
 		}
 		if p.currentToken.Type != tok.TokenRightParen {
-			fmt.Printf("Syntax error: expected ) , got %v\n", p.peekToken.Type)
+			p.errorf("expected ) , got %v", p.peekToken.Type)
 			return nil
 		}
+		p.nextToken()
 
 	case tok.TokenDatabase:
 		p.nextToken()
-		if p.currentToken.Type != tok.TokenIdentifier {
-			fmt.Printf("Syntax error: expected IDENTIFIER, got %v\n", p.currentToken.Type)
+		if !p.currentToken.IsName() {
+			p.errorf("expected IDENTIFIER, got %v", p.currentToken.Type)
 			return nil
 		}
 		database = p.currentToken.CurrentToken
 		if p.peekToken.Type != tok.TokenSemiColon {
-			fmt.Printf("Syntax error: expected Semicolon, got %v\n", p.peekToken.Type)
+			p.errorf("expected Semicolon, got %v", p.peekToken.Type)
 			return nil
 		}
+		p.nextToken()
 	default:
-		fmt.Printf("Syntax error: expected Table or Database, got %v\n", p.currentToken.Type)
+		p.errorf("expected Table or Database, got %v", p.currentToken.Type)
 	}
 	return &DropStatement{
 		Database: database,
@@ -611,14 +1018,14 @@ func (p *Parser) parseDrop() *DropStatement {
 func (p *Parser) parseDelete() *DeleteStatement {
 	//  DELETE FROM table_name [WHERE condition]
 	if p.peekToken.Type != tok.TokenFrom {
-		fmt.Printf("Syntax error: expected FROM after DELETE, got %v\n", p.peekToken.Type)
+		p.errorf("expected FROM after DELETE, got %v", p.peekToken.Type)
 		return nil
 	}
 	p.nextToken()
 	p.nextToken()
 
-	if p.currentToken.Type != tok.TokenIdentifier {
-		fmt.Printf("Syntax error: expected IDENTIFIER , got %v\n", p.currentToken.Type)
+	if !p.currentToken.IsName() {
+		p.errorf("expected IDENTIFIER , got %v", p.currentToken.Type)
 		return nil
 	}
 
@@ -626,12 +1033,18 @@ func (p *Parser) parseDelete() *DeleteStatement {
 	p.nextToken()
 
 	var where Expr
-	if p.currentToken.Type == tok.TokenWhere {
+	switch p.currentToken.Type {
+	case tok.TokenWhere:
 		p.nextToken()
 		where = p.parseExpr()
 		if where == nil {
 			return nil
 		}
+	case tok.TokenSemiColon, tok.TokenEOF:
+	default:
+		// without this a typo would turn the DELETE into one of every row
+		p.errorf("expected WHERE or ';' after table name, got %q", p.currentToken.CurrentToken)
+		return nil
 	}
 
 	return &DeleteStatement{
@@ -639,3 +1052,60 @@ func (p *Parser) parseDelete() *DeleteStatement {
 		Where: where,
 	}
 }
+
+func (p *Parser) parseUpdate() *UpdateStatement {
+	//  UPDATE table_name SET column = value [, column = value ...] [WHERE condition]
+	p.nextToken()
+	if !p.currentToken.IsName() {
+		p.errorf("expected table name after UPDATE, got %v", p.currentToken.Type)
+		return nil
+	}
+	table := p.currentToken.CurrentToken
+	p.nextToken()
+
+	if p.currentToken.Type != tok.TokenSet {
+		p.errorf("expected SET after table name, got %v", p.currentToken.Type)
+		return nil
+	}
+	p.nextToken()
+
+	var assignments []Assignment
+	for {
+		if !p.currentToken.IsName() {
+			p.errorf("expected column name in SET, got %v", p.currentToken.Type)
+			return nil
+		}
+		column := p.currentToken.CurrentToken
+		p.nextToken()
+		if p.currentToken.Type != tok.TokenOperator || p.currentToken.CurrentToken != "=" {
+			p.errorf("expected '=' after %s, got %v", column, p.currentToken.Type)
+			return nil
+		}
+		p.nextToken()
+		if !p.currentToken.IsName() && p.currentToken.Type != tok.TokenValue && p.currentToken.Type != tok.TokenNull {
+			p.errorf("expected value for %s, got %v", column, p.currentToken.Type)
+			return nil
+		}
+		assignments = append(assignments, Assignment{Column: column, Value: p.currentToken.CurrentToken, Ref: isColumnRef(p.currentToken)})
+		p.nextToken()
+		if p.currentToken.Type != tok.TokenComma {
+			break
+		}
+		p.nextToken()
+	}
+
+	var where Expr
+	if p.currentToken.Type == tok.TokenWhere {
+		p.nextToken()
+		where = p.parseExpr()
+		if where == nil {
+			return nil
+		}
+	}
+
+	return &UpdateStatement{
+		Table:       table,
+		Assignments: assignments,
+		Where:       where,
+	}
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+
+	tok "github.com/razzat008/letsgodb/internal/Tokenizer"
+)
+
+func parse(sql string) (Statement, error) {
+	return Parse(tok.Tokenize(sql))
+}
+
+func TestStatementEnd(t *testing.T) {
+	valid := []string{
+		"SELECT * FROM t",
+		"SELECT a, b FROM t WHERE a = 1;",
+		"INSERT INTO t (a, b) VALUES (1, 'x');",
+		"UPDATE t SET a = 1, b = 'x' WHERE a = 2;",
+		"DELETE FROM t WHERE a = 1;",
+		"DELETE FROM t;",
+		"CREATE TABLE t (PRIMARY_KEY a INTEGER, b TEXT);",
+		"CREATE UNIQUE INDEX ix ON t (a, b);",
+		"DROP INDEX ix;",
+		"DROP TABLE t;",
+		"DROP TABLE t (a, b);",
+		"DROP DATABASE d;",
+		"EXPLAIN ANALYZE SELECT * FROM t;",
+		"BEGIN TRANSACTION;",
+		"USE d;",
+	}
+	for _, sql := range valid {
+		if _, err := parse(sql); err != nil {
+			t.Errorf("%s: %v", sql, err)
+		}
+	}
+
+	// tokens left after a whole statement are an error, never ignored
+	invalid := []string{
+		"UPDATE d SET body = 'z' id = 1;",
+		"UPDATE d SET body = 'z' WHER id = 1;",
+		"SELECT * FROM t garbage;",
+		"SELECT * FROM t WHERE a = 1 b = 2;",
+		"DROP TABLE t extra;",
+		"DROP DATABASE d extra;",
+		"DELETE FROM d garbage;",
+		"DELETE FROM d WHER id = 1;",
+	}
+	for _, sql := range invalid {
+		if stmt, err := parse(sql); err == nil {
+			t.Errorf("%s: parsed as %#v, want a syntax error", sql, stmt)
+		}
+	}
+
+	stmt, err := parse("UPDATE d SET body = 'z' WHERE id = 2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up := stmt.(*UpdateStatement); up.Where == nil || len(up.Assignments) != 1 {
+		t.Errorf("UPDATE parsed as %#v", up)
+	}
+}
+
+// keywords such as FIRST or ORDER only count where the grammar expects them;
+// elsewhere they are ordinary names and keep the case they were written in
+func TestKeywordsAsNames(t *testing.T) {
+	stmt, err := parse("CREATE TABLE t (PRIMARY_KEY id INTEGER, first TEXT, last TEXT);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := stmt.(*CreateTableStatement); len(ct.Columns) != 3 || ct.Columns[1] != "first" || ct.Columns[2] != "last" {
+		t.Errorf("CREATE TABLE parsed as %#v", ct)
+	}
+
+	stmt, err = parse("SELECT first, last FROM t WHERE first = 'a' ORDER BY last DESC NULLS FIRST LIMIT 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel := stmt.(*SelectStatement)
+	if len(sel.Columns) != 2 || sel.Columns[0] != "first" || sel.Where.(*Condition).Column != "first" {
+		t.Errorf("SELECT parsed as %#v", sel)
+	}
+	if len(sel.OrderBy) != 1 || sel.OrderBy[0] != (OrderItem{Column: "last", Desc: true, NullsFirst: true}) {
+		t.Errorf("ORDER BY parsed as %#v", sel.OrderBy)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO t (id, first, last) VALUES (1, 'a', 'b');",
+		"UPDATE t SET last = first WHERE first = 'a';",
+		"CREATE TABLE order (PRIMARY_KEY limit INTEGER, offset TEXT);",
+		"CREATE INDEX on ON order (offset);",
+		"DELETE FROM order WHERE by = 1;",
+	} {
+		if _, err := parse(sql); err != nil {
+			t.Errorf("%s: %v", sql, err)
+		}
+	}
+}
+
+func TestSetValues(t *testing.T) {
+	stmt, err := parse("UPDATE t SET a = b, a = 'b', a = 1, a = -1.5, a = NULL, a = TRUE, a = x'ff', a = first;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []bool
+	for _, a := range stmt.(*UpdateStatement).Assignments {
+		refs = append(refs, a.Ref)
+	}
+	if want := []bool{true, false, false, false, false, false, false, true}; !slices.Equal(refs, want) {
+		t.Errorf("column references %v, want %v", refs, want)
+	}
+}
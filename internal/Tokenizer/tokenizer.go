@@ -26,6 +26,8 @@ const (
 	TokenSelect        TokenType = "SELECT"
 	TokenInsert        TokenType = "INSERT"
 	TokenDelete        TokenType = "DELETE"
+	TokenUpdate        TokenType = "UPDATE"
+	TokenSet           TokenType = "SET"
 	TokenFrom          TokenType = "FROM"
 	TokenUse           TokenType = "USE"
 	TokenWhere         TokenType = "WHERE"
@@ -51,16 +53,66 @@ const (
 	TokenDrop          TokenType = "DROP"
 	TokenList          TokenType = "LIST"
 	TokenPrimaryKey    TokenType = "PRIMARY_KEY"
+	TokenDataType      TokenType = "DATA_TYPE"
+	TokenNull          TokenType = "NULL"
+	TokenIs            TokenType = "IS"
+	TokenNot           TokenType = "NOT"
+	TokenBegin         TokenType = "BEGIN"
+	TokenCommit        TokenType = "COMMIT"
+	TokenRollback      TokenType = "ROLLBACK"
+	TokenTransaction   TokenType = "TRANSACTION"
+	TokenIndex         TokenType = "INDEX"
+	TokenUnique        TokenType = "UNIQUE"
+	TokenOn            TokenType = "ON"
+	TokenExplain       TokenType = "EXPLAIN"
+	TokenAnalyze       TokenType = "ANALYZE"
+	TokenOrder         TokenType = "ORDER"
+	TokenBy            TokenType = "BY"
+	TokenAsc           TokenType = "ASC"
+	TokenDesc          TokenType = "DESC"
+	TokenNulls         TokenType = "NULLS"
+	TokenFirst         TokenType = "FIRST"
+	TokenLast          TokenType = "LAST"
+	TokenLimit         TokenType = "LIMIT"
+	TokenOffset        TokenType = "OFFSET"
 )
 
+/*
+contextual keywords only mean something at certain points of a statement, so
+they keep their spelling and the parser takes them as names anywhere else: a
+table may have columns called first and last, or be called order.
+*/
+var contextual = map[TokenType]bool{
+	TokenSet: true, TokenIndex: true, TokenUnique: true, TokenOn: true,
+	TokenExplain: true, TokenAnalyze: true, TokenOrder: true, TokenBy: true,
+	TokenAsc: true, TokenDesc: true, TokenNulls: true, TokenFirst: true,
+	TokenLast: true, TokenLimit: true, TokenOffset: true,
+}
+
+// IsName reports whether the token can be a table, column, index or database name.
+func (t Token) IsName() bool {
+	return t.Type == TokenIdentifier || contextual[t.Type]
+}
+
 // break input string into clean token parts
 func tokenizeInput(input string) []string {
 	var tokens []string
 	var current strings.Builder
+	quoted := false
 	i := 0
 	for i < len(input) {
 		ch := input[i]
 
+		// a quoted literal is one token, spaces and symbols included; '' inside it toggles twice
+		if ch == '\'' || quoted {
+			if ch == '\'' {
+				quoted = !quoted
+			}
+			current.WriteByte(ch)
+			i++
+			continue
+		}
+
 		// Handle whitespace
 		if ch == ' ' || ch == '\t' || ch == '\n' {
 			if current.Len() > 0 {
@@ -109,7 +161,12 @@ func tokenizeInput(input string) []string {
 // tokenizes the user input []byte into string
 // and returns the string
 func Tokenizer(lb *repl.LineBuffer) []Token {
-	rawTokens := tokenizeInput(string(lb.Buffer))
+	return Tokenize(string(lb.Buffer))
+}
+
+// Tokenize splits a SQL string into tokens; used where the input doesn't come from the REPL.
+func Tokenize(input string) []Token {
+	rawTokens := tokenizeInput(input)
 	var tokens []Token
 
 	for _, currentToken := range rawTokens {
@@ -135,6 +192,10 @@ func Tokenizer(lb *repl.LineBuffer) []Token {
 			tokens = append(tokens, Token{Type: TokenUse, CurrentToken: upperToken})
 		case "DELETE":
 			tokens = append(tokens, Token{Type: TokenDelete, CurrentToken: upperToken})
+		case "UPDATE":
+			tokens = append(tokens, Token{Type: TokenUpdate, CurrentToken: upperToken})
+		case "SET":
+			tokens = append(tokens, Token{Type: TokenSet, CurrentToken: upperToken})
 		case "VALUES":
 			tokens = append(tokens, Token{Type: TokenValues, CurrentToken: upperToken})
 		case "CREATE":
@@ -149,6 +210,50 @@ func Tokenizer(lb *repl.LineBuffer) []Token {
 			tokens = append(tokens, Token{Type: TokenDrop, CurrentToken: upperToken})
 		case "PRIMARY_KEY":
 			tokens = append(tokens, Token{Type: TokenPrimaryKey, CurrentToken: upperToken})
+		case "NULL":
+			tokens = append(tokens, Token{Type: TokenNull, CurrentToken: upperToken})
+		case "IS":
+			tokens = append(tokens, Token{Type: TokenIs, CurrentToken: upperToken})
+		case "NOT":
+			tokens = append(tokens, Token{Type: TokenNot, CurrentToken: upperToken})
+		case "BEGIN":
+			tokens = append(tokens, Token{Type: TokenBegin, CurrentToken: upperToken})
+		case "COMMIT":
+			tokens = append(tokens, Token{Type: TokenCommit, CurrentToken: upperToken})
+		case "ROLLBACK":
+			tokens = append(tokens, Token{Type: TokenRollback, CurrentToken: upperToken})
+		case "TRANSACTION":
+			tokens = append(tokens, Token{Type: TokenTransaction, CurrentToken: upperToken})
+		case "INDEX":
+			tokens = append(tokens, Token{Type: TokenIndex, CurrentToken: upperToken})
+		case "UNIQUE":
+			tokens = append(tokens, Token{Type: TokenUnique, CurrentToken: upperToken})
+		case "ON":
+			tokens = append(tokens, Token{Type: TokenOn, CurrentToken: upperToken})
+		case "EXPLAIN":
+			tokens = append(tokens, Token{Type: TokenExplain, CurrentToken: upperToken})
+		case "ANALYZE":
+			tokens = append(tokens, Token{Type: TokenAnalyze, CurrentToken: upperToken})
+		case "ORDER":
+			tokens = append(tokens, Token{Type: TokenOrder, CurrentToken: upperToken})
+		case "BY":
+			tokens = append(tokens, Token{Type: TokenBy, CurrentToken: upperToken})
+		case "ASC":
+			tokens = append(tokens, Token{Type: TokenAsc, CurrentToken: upperToken})
+		case "DESC":
+			tokens = append(tokens, Token{Type: TokenDesc, CurrentToken: upperToken})
+		case "NULLS":
+			tokens = append(tokens, Token{Type: TokenNulls, CurrentToken: upperToken})
+		case "FIRST":
+			tokens = append(tokens, Token{Type: TokenFirst, CurrentToken: upperToken})
+		case "LAST":
+			tokens = append(tokens, Token{Type: TokenLast, CurrentToken: upperToken})
+		case "LIMIT":
+			tokens = append(tokens, Token{Type: TokenLimit, CurrentToken: upperToken})
+		case "OFFSET":
+			tokens = append(tokens, Token{Type: TokenOffset, CurrentToken: upperToken})
+		case "INTEGER", "INT", "REAL", "FLOAT", "DOUBLE", "TEXT", "VARCHAR", "STRING", "BOOLEAN", "BOOL", "BLOB":
+			tokens = append(tokens, Token{Type: TokenDataType, CurrentToken: upperToken})
 		case "=", ">", "<", ">=", "<=", "!=":
 			tokens = append(tokens, Token{Type: TokenOperator, CurrentToken: upperToken})
 		case ";":
@@ -170,6 +275,9 @@ func Tokenizer(lb *repl.LineBuffer) []Token {
 				tokens = append(tokens, Token{Type: TokenIdentifier, CurrentToken: currentToken})
 			}
 		}
+		if last := &tokens[len(tokens)-1]; contextual[last.Type] {
+			last.CurrentToken = currentToken
+		}
 	}
 	return tokens
 }
@@ -0,0 +1,411 @@
+// BTree: an on-disk B+Tree stored in pages handed out by storage.Pager.
+// It maps variable-length byte keys to uint64 values (row locators) and is
+// used for primary-key lookups, duplicate checks and ordered range scans.
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+Page layout
+
+	page 0 (meta):  [magic uint32][root uint32]
+	node pages:     [kind uint8][nkeys uint16][link uint32][entries...]
+
+For a leaf, link is the page number of the next leaf (0 = none) and each entry
+is [klen uint16][key][value uint64].
+For an internal node, link is the leftmost child and each entry is
+[klen uint16][key][child uint32], where child holds keys >= key.
+Page 0 is always the meta page, so 0 is never a valid node or sibling.
+*/
+
+const (
+	metaMagic      = 0x4c474254 // "LGBT"
+	kindLeaf       = 1
+	kindInternal   = 2
+	nodeHeaderSize = 7
+	leafValueSize  = 8
+	childSize      = 4
+)
+
+// MaxKeySize is the largest key accepted, chosen so every node fits at least 4 keys.
+const MaxKeySize = (storage.UsablePageSize-nodeHeaderSize)/4 - 2 - leafValueSize
+
+var (
+	ErrDuplicateKey = errors.New("btree: duplicate key")
+	ErrKeyTooLarge  = errors.New("btree: key too large")
+)
+
+type BTree struct {
+	pager *storage.Pager
+	root  uint32 // 0 until the first key is inserted
+}
+
+type node struct {
+	leaf     bool
+	keys     [][]byte
+	vals     []uint64 // leaf only
+	children []uint32 // internal only, len(keys)+1
+	next     uint32   // leaf only
+}
+
+// Open loads the tree stored in pager, initialising the meta page for a new file.
+func Open(pager *storage.Pager) (*BTree, error) {
+	t := &BTree{pager: pager}
+	if pager.PageCount() == 0 {
+		if _, err := pager.AllocatePage(); err != nil {
+			return nil, err
+		}
+		return t, t.writeMeta()
+	}
+	meta, err := pager.GetPage(0)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(meta[0:4]) != metaMagic {
+		return nil, fmt.Errorf("btree: %s is not an index file", pager.File().Name())
+	}
+	t.root = binary.LittleEndian.Uint32(meta[4:8])
+	return t, nil
+}
+
+func (t *BTree) writeMeta() error {
+	meta, err := t.pager.GetPage(0)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(meta[0:4], metaMagic)
+	binary.LittleEndian.PutUint32(meta[4:8], t.root)
+	return t.pager.FlushPage(0, meta)
+}
+
+// Get returns the value stored for key.
+func (t *BTree) Get(key []byte) (uint64, bool, error) {
+	if t.root == 0 {
+		return 0, false, nil
+	}
+	pageNum, err := t.findLeaf(key)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := t.readNode(pageNum)
+	if err != nil {
+		return 0, false, err
+	}
+	i, found := n.search(key)
+	if !found {
+		return 0, false, nil
+	}
+	return n.vals[i], true, nil
+}
+
+// Insert adds key -> val. Keys are unique; inserting an existing key returns ErrDuplicateKey.
+func (t *BTree) Insert(key []byte, val uint64) error {
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	if t.root == 0 {
+		root, err := t.pager.AllocatePage()
+		if err != nil {
+			return err
+		}
+		t.root = root
+		leaf := &node{leaf: true, keys: [][]byte{key}, vals: []uint64{val}}
+		if err := t.writeNode(t.root, leaf); err != nil {
+			return err
+		}
+		return t.writeMeta()
+	}
+	sep, right, err := t.insert(t.root, key, val)
+	if err != nil || right == 0 {
+		return err
+	}
+	// The root split: grow the tree by one level.
+	newRoot := &node{keys: [][]byte{sep}, children: []uint32{t.root, right}}
+	root, err := t.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	t.root = root
+	if err := t.writeNode(t.root, newRoot); err != nil {
+		return err
+	}
+	return t.writeMeta()
+}
+
+// insert descends into pageNum and returns a separator and new right sibling if the node split.
+func (t *BTree) insert(pageNum uint32, key []byte, val uint64) ([]byte, uint32, error) {
+	n, err := t.readNode(pageNum)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n.leaf {
+		i, found := n.search(key)
+		if found {
+			return nil, 0, ErrDuplicateKey
+		}
+		n.keys = insertAt(n.keys, i, append([]byte(nil), key...))
+		n.vals = insertAt(n.vals, i, val)
+	} else {
+		i := n.childIndex(key)
+		sep, right, err := t.insert(n.children[i], key, val)
+		if err != nil || right == 0 {
+			return nil, 0, err
+		}
+		n.keys = insertAt(n.keys, i, sep)
+		n.children = insertAt(n.children, i+1, right)
+	}
+	if n.size() <= storage.UsablePageSize {
+		return nil, 0, t.writeNode(pageNum, n)
+	}
+	return t.split(pageNum, n)
+}
+
+// split moves the upper half (by bytes) of n into a new page and returns the separator key.
+func (t *BTree) split(pageNum uint32, n *node) ([]byte, uint32, error) {
+	mid := n.splitPoint()
+	rightPage, err := t.pager.AllocatePage()
+	if err != nil {
+		return nil, 0, err
+	}
+	var right *node
+	var sep []byte
+	if n.leaf {
+		right = &node{leaf: true, keys: n.keys[mid:], vals: n.vals[mid:], next: n.next}
+		sep = right.keys[0]
+		n.keys, n.vals, n.next = n.keys[:mid], n.vals[:mid], rightPage
+	} else {
+		// the middle key moves up; it is not kept in either half
+		sep = n.keys[mid]
+		right = &node{keys: n.keys[mid+1:], children: n.children[mid+1:]}
+		n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	}
+	if err := t.writeNode(rightPage, right); err != nil {
+		return nil, 0, err
+	}
+	return sep, rightPage, t.writeNode(pageNum, n)
+}
+
+/*
+Delete removes key from its leaf and reports whether it was present.
+Nodes are not merged on underflow: an emptied leaf simply stays in the sibling
+chain and is skipped by cursors. This keeps deletes to a single page write.
+*/
+func (t *BTree) Delete(key []byte) (bool, error) {
+	if t.root == 0 {
+		return false, nil
+	}
+	pageNum, err := t.findLeaf(key)
+	if err != nil {
+		return false, err
+	}
+	n, err := t.readNode(pageNum)
+	if err != nil {
+		return false, err
+	}
+	i, found := n.search(key)
+	if !found {
+		return false, nil
+	}
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.vals = append(n.vals[:i], n.vals[i+1:]...)
+	return true, t.writeNode(pageNum, n)
+}
+
+// findLeaf walks from the root to the leaf that would contain key.
+func (t *BTree) findLeaf(key []byte) (uint32, error) {
+	pageNum := t.root
+	for {
+		n, err := t.readNode(pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if n.leaf {
+			return pageNum, nil
+		}
+		pageNum = n.children[n.childIndex(key)]
+	}
+}
+
+// search returns the position of key in a leaf, or where it would be inserted.
+func (n *node) search(key []byte) (int, bool) {
+	lo, hi := 0, len(n.keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if bytes.Compare(n.keys[mid], key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(n.keys) && bytes.Equal(n.keys[lo], key)
+}
+
+// childIndex picks the child of an internal node to descend into for key.
+func (n *node) childIndex(key []byte) int {
+	lo, hi := 0, len(n.keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if bytes.Compare(n.keys[mid], key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// splitPoint picks the entry index that divides the node's bytes roughly in half.
+func (n *node) splitPoint() int {
+	half := (n.size() - nodeHeaderSize) / 2
+	used := 0
+	for i, k := range n.keys {
+		used += 2 + len(k)
+		if n.leaf {
+			used += leafValueSize
+		} else {
+			used += childSize
+		}
+		if used >= half {
+			return max(1, min(i, len(n.keys)-2))
+		}
+	}
+	return len(n.keys) / 2
+}
+
+// size returns the number of bytes the node needs when encoded.
+func (n *node) size() int {
+	sz := nodeHeaderSize
+	for _, k := range n.keys {
+		sz += 2 + len(k)
+		if n.leaf {
+			sz += leafValueSize
+		} else {
+			sz += childSize
+		}
+	}
+	return sz
+}
+
+func (t *BTree) readNode(pageNum uint32) (*node, error) {
+	page, unpin, err := t.pager.Pin(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	defer unpin()
+	n := &node{leaf: page[0] == kindLeaf}
+	count := int(binary.LittleEndian.Uint16(page[1:3]))
+	link := binary.LittleEndian.Uint32(page[3:7])
+	if n.leaf {
+		n.next = link
+	} else {
+		n.children = append(n.children, link)
+	}
+	off := nodeHeaderSize
+	for i := 0; i < count; i++ {
+		klen := int(binary.LittleEndian.Uint16(page[off:]))
+		off += 2
+		n.keys = append(n.keys, append([]byte(nil), page[off:off+klen]...))
+		off += klen
+		if n.leaf {
+			n.vals = append(n.vals, binary.LittleEndian.Uint64(page[off:]))
+			off += leafValueSize
+		} else {
+			n.children = append(n.children, binary.LittleEndian.Uint32(page[off:]))
+			off += childSize
+		}
+	}
+	return n, nil
+}
+
+func (t *BTree) writeNode(pageNum uint32, n *node) error {
+	page := make([]byte, storage.UsablePageSize)
+	if n.leaf {
+		page[0] = kindLeaf
+		binary.LittleEndian.PutUint32(page[3:7], n.next)
+	} else {
+		page[0] = kindInternal
+		binary.LittleEndian.PutUint32(page[3:7], n.children[0])
+	}
+	binary.LittleEndian.PutUint16(page[1:3], uint16(len(n.keys)))
+	off := nodeHeaderSize
+	for i, k := range n.keys {
+		binary.LittleEndian.PutUint16(page[off:], uint16(len(k)))
+		off += 2
+		off += copy(page[off:], k)
+		if n.leaf {
+			binary.LittleEndian.PutUint64(page[off:], n.vals[i])
+			off += leafValueSize
+		} else {
+			binary.LittleEndian.PutUint32(page[off:], n.children[i+1])
+			off += childSize
+		}
+	}
+	return t.pager.FlushPage(pageNum, page)
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// Cursor iterates leaf entries in key order.
+// A cursor that fails to read a page stops, and Err returns why.
+type Cursor struct {
+	tree *BTree
+	leaf *node
+	pos  int
+	err  error
+}
+
+// Seek returns a cursor positioned at the first key >= key (nil key = first key).
+func (t *BTree) Seek(key []byte) *Cursor {
+	c := &Cursor{tree: t}
+	if t.root == 0 {
+		return c
+	}
+	pageNum, err := t.findLeaf(key)
+	if err == nil {
+		c.leaf, err = t.readNode(pageNum)
+	}
+	if err != nil {
+		c.leaf, c.err = nil, err
+		return c
+	}
+	c.pos, _ = c.leaf.search(key)
+	c.skipEmpty()
+	return c
+}
+
+// skipEmpty follows the sibling chain until the cursor points at an entry or the end.
+func (c *Cursor) skipEmpty() {
+	for c.leaf != nil && c.pos >= len(c.leaf.keys) {
+		if c.leaf.next == 0 {
+			c.leaf = nil
+			return
+		}
+		c.leaf, c.err = c.tree.readNode(c.leaf.next)
+		c.pos = 0
+	}
+}
+
+func (c *Cursor) Valid() bool   { return c.leaf != nil }
+func (c *Cursor) Key() []byte   { return c.leaf.keys[c.pos] }
+func (c *Cursor) Value() uint64 { return c.leaf.vals[c.pos] }
+
+func (c *Cursor) Next() {
+	c.pos++
+	c.skipEmpty()
+}
+
+// Err returns the error that stopped the cursor, if any.
+func (c *Cursor) Err() error { return c.err }
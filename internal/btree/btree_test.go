@@ -0,0 +1,89 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%06d", i))
+}
+
+func TestBTreeInsertGetScan(t *testing.T) {
+	testFile := "test_btree.idx"
+	defer os.Remove(testFile)
+
+	pager, err := storage.NewPager(testFile, nil)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	tree, err := Open(pager)
+	if err != nil {
+		t.Fatalf("Failed to open tree: %v", err)
+	}
+
+	// Insert enough keys in random order to force several levels of splits
+	const n = 5000
+	for _, i := range rand.Perm(n) {
+		if err := tree.Insert(key(i), uint64(i)); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", i, err)
+		}
+	}
+	if err := tree.Insert(key(42), 0); err != ErrDuplicateKey {
+		t.Errorf("Expected ErrDuplicateKey, got %v", err)
+	}
+
+	// Re-open from disk and check every key is still reachable
+	if err := pager.Close(); err != nil {
+		t.Fatalf("Failed to close tree: %v", err)
+	}
+	pager, err = storage.NewPager(testFile, nil)
+	if err != nil {
+		t.Fatalf("Failed to re-open file: %v", err)
+	}
+	defer pager.Close()
+	tree, err = Open(pager)
+	if err != nil {
+		t.Fatalf("Failed to re-open tree: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		v, ok, err := tree.Get(key(i))
+		if !ok || err != nil || v != uint64(i) {
+			t.Fatalf("Get(%d) = %d, %v, %v", i, v, ok, err)
+		}
+	}
+
+	// Range scan from the middle must return keys in order
+	count := 0
+	c := tree.Seek(key(2500))
+	for ; c.Valid(); c.Next() {
+		if string(c.Key()) != string(key(2500+count)) {
+			t.Fatalf("scan out of order at %d: got %s", count, c.Key())
+		}
+		count++
+	}
+	if c.Err() != nil || count != n-2500 {
+		t.Errorf("Expected %d keys from scan, got %d", n-2500, count)
+	}
+
+	// Delete every even key
+	for i := 0; i < n; i += 2 {
+		if ok, err := tree.Delete(key(i)); !ok || err != nil {
+			t.Fatalf("Delete(%d) = %v, %v", i, ok, err)
+		}
+	}
+	count = 0
+	for c := tree.Seek(nil); c.Valid(); c.Next() {
+		count++
+	}
+	if count != n/2 {
+		t.Errorf("Expected %d keys after delete, got %d", n/2, count)
+	}
+	if _, ok, _ := tree.Get(key(10)); ok {
+		t.Errorf("Deleted key still present")
+	}
+}
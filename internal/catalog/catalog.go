@@ -2,27 +2,125 @@ package catalog
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+// ColumnType is the declared type of a table column.
+type ColumnType string
+
+const (
+	TypeInteger ColumnType = "INTEGER"
+	TypeReal    ColumnType = "REAL"
+	TypeText    ColumnType = "TEXT"
+	TypeBoolean ColumnType = "BOOLEAN"
+	TypeBlob    ColumnType = "BLOB"
+)
+
+// PrimaryKeyIndex is the name of every table's primary-key index, which no secondary index may take.
+const PrimaryKeyIndex = "pk"
+
+// Wrapped by errors about a table, column or index that does not exist, e.g. `table "t" does not exist`.
+var (
+	ErrNoSuchTable  = errors.New("does not exist")
+	ErrNoSuchColumn = errors.New("does not exist")
+	ErrNoSuchIndex  = errors.New("does not exist")
 )
 
-// TableSchema represents the schema of a table (name and columns).
+// ParseColumnType maps a type name from CREATE TABLE (including common aliases) to a ColumnType.
+// An empty name means the column was declared without a type and defaults to TEXT.
+func ParseColumnType(name string) (ColumnType, error) {
+	switch strings.ToUpper(name) {
+	case "INTEGER", "INT":
+		return TypeInteger, nil
+	case "REAL", "FLOAT", "DOUBLE":
+		return TypeReal, nil
+	case "", "TEXT", "VARCHAR", "STRING":
+		return TypeText, nil
+	case "BOOLEAN", "BOOL":
+		return TypeBoolean, nil
+	case "BLOB":
+		return TypeBlob, nil
+	}
+	return "", fmt.Errorf("unknown column type %q", name)
+}
+
+// TableSchema represents the schema of a table (name, columns and their types).
 type TableSchema struct {
-	Name       string   `json:"name"`
-	Columns    []string `json:"columns"`
-	PrimaryKey string   `json:"primary_key"` 
+	Name       string        `json:"name"`
+	Columns    []string      `json:"columns"`
+	Types      []ColumnType  `json:"types,omitempty"` // parallel to Columns; missing entries are TEXT
+	PrimaryKey string        `json:"primary_key"`
+	Versioned  bool          `json:"versioned,omitempty"` // every row carries an MVCC version header
+	Indexes    []IndexSchema `json:"indexes,omitempty"`   // secondary indexes, in the order they were created
+}
+
+// IndexSchema describes a secondary index: its name (unique within the database) and the columns it orders rows by.
+type IndexSchema struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"` // no two rows may have the same non-NULL values in Columns
+}
+
+// TypeOf returns the type of the i-th column. Tables created before column
+// types existed have no Types and are treated as all TEXT.
+func (s *TableSchema) TypeOf(i int) ColumnType {
+	if i < len(s.Types) && s.Types[i] != "" {
+		return s.Types[i]
+	}
+	return TypeText
+}
+
+// ColumnIndex returns the position of a column, or -1 if the table has no such column.
+func (s *TableSchema) ColumnIndex(name string) int {
+	for i, col := range s.Columns {
+		if col == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Catalog file formats:
+
+	1  one JSON table schema per line
+	2  a header line, then one JSON table schema per line
+*/
+const (
+	catalogFormat = 2
+	catalogMagic  = "letsgodb catalog"
+)
+
+// FileHeader is the first line of a catalog file.
+type FileHeader struct {
+	Magic     string    `json:"magic"`
+	Format    int       `json:"format"`
+	PageSize  int       `json:"page_size"` // of the database's page files
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by"`
 }
 
 // Catalog manages table schemas and persists them to a catalog file.
 type Catalog struct {
 	filename string
 	mu       sync.Mutex
+	header   FileHeader
 	tables   map[string]*TableSchema
 }
 
 // NewCatalog creates a new Catalog instance and loads existing schemas from file.
+// A file from before catalog headers is rewritten with one.
 func NewCatalog(filename string) (*Catalog, error) {
 	c := &Catalog{
 		filename: filename,
@@ -43,7 +141,17 @@ func (c *Catalog) load() error {
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			var header FileHeader
+			if json.Unmarshal(scanner.Bytes(), &header) == nil && header.Magic == catalogMagic {
+				if err := c.checkHeader(header); err != nil {
+					return err
+				}
+				c.header = header
+				continue
+			}
+		}
 		var schema TableSchema
 		if err := json.Unmarshal(scanner.Bytes(), &schema); err != nil {
 			return fmt.Errorf("failed to parse catalog entry: %w", err)
@@ -53,38 +161,63 @@ func (c *Catalog) load() error {
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("error reading catalog file: %w", err)
 	}
+	if c.header.Magic == "" {
+		// a new catalog, or one in format 1
+		c.header = FileHeader{Magic: catalogMagic, Format: catalogFormat, PageSize: storage.PageSize, Created: time.Now().UTC(), CreatedBy: "letsgodb"}
+		return c.save()
+	}
 	return nil
 }
 
-// AddTable adds a new table schema to the catalog and persists it.
+func (c *Catalog) checkHeader(h FileHeader) error {
+	switch {
+	case h.Format > catalogFormat:
+		return fmt.Errorf("%w: %s is in format %d, written by a newer letsgodb (this one reads format %d)", storage.ErrFileFormat, c.filename, h.Format, catalogFormat)
+	case h.PageSize != storage.PageSize:
+		return fmt.Errorf("%w: %s is for %d byte pages, this build uses %d", storage.ErrFileFormat, c.filename, h.PageSize, storage.PageSize)
+	}
+	return nil
+}
+
+// Header returns the header of the catalog file.
+func (c *Catalog) Header() FileHeader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header
+}
+
+// AddTable adds a new table schema with untyped (TEXT) columns to the catalog and persists it.
 func (c *Catalog) AddTable(name string, columns []string) error {
+	return c.AddTypedTable(name, columns, nil)
+}
+
+// AddTypedTable adds a new table schema whose columns have the given types and persists it.
+// The first column is the primary key.
+func (c *Catalog) AddTypedTable(name string, columns []string, types []ColumnType) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if _, exists := c.tables[name]; exists {
 		return fmt.Errorf("table %q already exists", name)
 	}
+	if len(columns) == 0 {
+		return fmt.Errorf("table %q must have at least one column", name)
+	}
+	if types != nil && len(types) != len(columns) {
+		return fmt.Errorf("table %q: %d columns but %d types", name, len(columns), len(types))
+	}
 	schema := &TableSchema{
 		Name:       name,
 		Columns:    columns,
+		Types:      types,
 		PrimaryKey: columns[0],
+		Versioned:  true,
 	}
-	// Append to file
-	file, err := os.OpenFile(c.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open catalog file for writing: %w", err)
-	}
-	defer file.Close()
-
-	data, err := json.Marshal(schema)
-	if err != nil {
-		return fmt.Errorf("failed to marshal schema: %w", err)
-	}
-	if _, err := file.Write(append(data, '\n')); err != nil {
-		return fmt.Errorf("failed to write schema to catalog: %w", err)
-	}
-
 	c.tables[name] = schema
+	if err := c.save(); err != nil {
+		delete(c.tables, name)
+		return err
+	}
 	return nil
 }
 
@@ -115,26 +248,148 @@ func (c *Catalog) DropTable(name string) error {
 	defer c.mu.Unlock()
 
 	if _, exists := c.tables[name]; !exists {
-		return fmt.Errorf("table %q does not exist", name)
+		return fmt.Errorf("table %q %w", name, ErrNoSuchTable)
 	}
 	// Remove from in-memory map
 	delete(c.tables, name)
+	return c.save()
+}
 
-	// Rewrite the catalog file with all remaining tables
-	file, err := os.OpenFile(c.filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open catalog file for rewriting: %w", err)
+/*
+CheckIndex reports why an index could not be added to a table: the table or
+one of the columns does not exist, or the name is taken by another index.
+*/
+func (c *Catalog) CheckIndex(table string, index IndexSchema) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkIndex(table, index)
+}
+
+func (c *Catalog) checkIndex(table string, index IndexSchema) error {
+	schema, exists := c.tables[table]
+	if !exists {
+		return fmt.Errorf("table %q %w", table, ErrNoSuchTable)
 	}
-	defer file.Close()
+	if t, _ := c.findIndex(index.Name); t != nil {
+		return fmt.Errorf("index %q already exists", index.Name)
+	}
+	if index.Name == PrimaryKeyIndex {
+		return fmt.Errorf("index name %q is reserved for the primary key", index.Name)
+	}
+	if len(index.Columns) == 0 {
+		return fmt.Errorf("index %q must have at least one column", index.Name)
+	}
+	for i, col := range index.Columns {
+		if schema.ColumnIndex(col) == -1 {
+			return fmt.Errorf("column %q %w in table %q", col, ErrNoSuchColumn, table)
+		}
+		if slices.Contains(index.Columns[:i], col) {
+			return fmt.Errorf("index %q lists column %q twice", index.Name, col)
+		}
+	}
+	return nil
+}
+
+/*
+AddIndex adds a secondary index to a table and persists it. The table's
+schema is replaced rather than changed, so tables opened with the old one
+keep a consistent view.
+*/
+func (c *Catalog) AddIndex(table string, index IndexSchema) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkIndex(table, index); err != nil {
+		return err
+	}
+	schema := *c.tables[table]
+	schema.Indexes = append(slices.Clone(schema.Indexes), index)
+	c.tables[table] = &schema
+	return c.save()
+}
+
+// FindIndex returns the table an index belongs to and its definition, or nils if there is no such index.
+func (c *Catalog) FindIndex(name string) (*TableSchema, *IndexSchema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.findIndex(name)
+}
+
+func (c *Catalog) findIndex(name string) (*TableSchema, *IndexSchema) {
+	for _, schema := range c.tables {
+		for i := range schema.Indexes {
+			if schema.Indexes[i].Name == name {
+				return schema, &schema.Indexes[i]
+			}
+		}
+	}
+	return nil, nil
+}
 
+// DropIndex removes a secondary index from its table and persists the catalog.
+func (c *Catalog) DropIndex(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	table, _ := c.findIndex(name)
+	if table == nil {
+		return fmt.Errorf("index %q %w", name, ErrNoSuchIndex)
+	}
+	schema := *table
+	schema.Indexes = slices.DeleteFunc(slices.Clone(schema.Indexes), func(ix IndexSchema) bool { return ix.Name == name })
+	c.tables[schema.Name] = &schema
+	return c.save()
+}
+
+// MarkVersioned records that every row of a table has been rewritten with an MVCC version header.
+func (c *Catalog) MarkVersioned(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schema, exists := c.tables[name]
+	if !exists {
+		return fmt.Errorf("table %q %w", name, ErrNoSuchTable)
+	}
+	schema.Versioned = true
+	return c.save()
+}
+
+// save rewrites the catalog file with all tables. The caller holds c.mu.
+// The new contents go to a temporary file that is synced and renamed over
+// the catalog, so a crash leaves either the old catalog or the new one.
+func (c *Catalog) save() error {
+	var buf bytes.Buffer
+	header, err := json.Marshal(c.header)
+	if err != nil {
+		return fmt.Errorf("failed to marshal catalog header: %w", err)
+	}
+	buf.Write(append(header, '\n'))
 	for _, schema := range c.tables {
 		data, err := json.Marshal(schema)
 		if err != nil {
 			return fmt.Errorf("failed to marshal schema: %w", err)
 		}
-		if _, err := file.Write(append(data, '\n')); err != nil {
-			return fmt.Errorf("failed to write schema to catalog: %w", err)
-		}
+		buf.Write(append(data, '\n'))
+	}
+
+	tmp := c.filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create catalog file: %w", err)
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if err = errors.Join(err, file.Close()); err == nil {
+		err = os.Rename(tmp, c.filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	if err := syncDir(filepath.Dir(c.filename)); err != nil {
+		return fmt.Errorf("failed to sync catalog directory: %w", err)
 	}
 	return nil
 }
@@ -1,8 +1,13 @@
 package catalog
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/razzat008/letsgodb/internal/storage"
 )
 
 func TestCatalogBasicUsage(t *testing.T) {
@@ -71,3 +76,107 @@ func TestCatalogBasicUsage(t *testing.T) {
 		t.Errorf("Catalog did not persist 'posts' table")
 	}
 }
+
+func TestCatalogHeader(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "catalog.db")
+
+	// A catalog from before headers is rewritten with one, keeping its tables
+	legacy := `{"name":"users","columns":["id","name"],"primary_key":"id"}` + "\n"
+	if err := os.WriteFile(testFile, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cat, err := NewCatalog(testFile)
+	if err != nil {
+		t.Fatalf("Failed to open format 1 catalog: %v", err)
+	}
+	created := cat.Header().Created
+	if cat.GetTable("users") == nil {
+		t.Fatalf("table lost in upgrade")
+	}
+	data, _ := os.ReadFile(testFile)
+	if !strings.HasPrefix(string(data), `{"magic":"letsgodb catalog","format":2,`) {
+		t.Fatalf("catalog not rewritten with a header: %s", data)
+	}
+	cat, err = NewCatalog(testFile)
+	if err != nil || cat.GetTable("users") == nil || !cat.Header().Created.Equal(created) {
+		t.Fatalf("reopening upgraded catalog: %v", err)
+	}
+	if _, err := os.Stat(testFile + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary catalog file left behind: %v", err)
+	}
+
+	// A table that cannot be written is not added, and the catalog keeps its old contents
+	if err := os.Mkdir(testFile+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := cat.AddTable("orders", []string{"id"}); err == nil {
+		t.Fatalf("AddTable with an unwritable catalog: no error")
+	}
+	if cat.GetTable("orders") != nil {
+		t.Errorf("table added although the catalog was not written")
+	}
+	if after, _ := os.ReadFile(testFile); string(after) != string(data) {
+		t.Errorf("failed write changed the catalog: %s", after)
+	}
+	os.Remove(testFile + ".tmp")
+
+	// A catalog from a newer format is refused
+	newer := `{"magic":"letsgodb catalog","format":99,"page_size":4096}` + "\n"
+	if err := os.WriteFile(testFile, []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCatalog(testFile); !errors.Is(err, storage.ErrFileFormat) {
+		t.Fatalf("opening a newer catalog: got %v, want ErrFileFormat", err)
+	}
+}
+
+func TestCatalogIndexes(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "catalog.db")
+	cat, err := NewCatalog(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cat.AddTable("users", []string{"id", "email", "age"}); err != nil {
+		t.Fatal(err)
+	}
+	before := cat.GetTable("users")
+	if err := cat.AddIndex("users", IndexSchema{Name: "by_email", Columns: []string{"email"}, Unique: true}); err != nil {
+		t.Fatalf("AddIndex: %v", err)
+	}
+	if len(before.Indexes) != 0 {
+		t.Errorf("AddIndex changed the schema tables already use")
+	}
+	for _, bad := range []struct {
+		table string
+		index IndexSchema
+	}{
+		{"users", IndexSchema{Name: "by_email", Columns: []string{"age"}}},
+		{"users", IndexSchema{Name: "pk", Columns: []string{"age"}}},
+		{"users", IndexSchema{Name: "by_x", Columns: []string{"x"}}},
+		{"users", IndexSchema{Name: "by_age", Columns: []string{"age", "age"}}},
+		{"posts", IndexSchema{Name: "by_age", Columns: []string{"age"}}},
+	} {
+		if err := cat.AddIndex(bad.table, bad.index); err == nil {
+			t.Errorf("AddIndex(%s, %+v) succeeded", bad.table, bad.index)
+		}
+	}
+
+	// Indexes persist with their table
+	cat, err = NewCatalog(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, index := cat.FindIndex("by_email")
+	if table == nil || table.Name != "users" || !index.Unique || index.Columns[0] != "email" {
+		t.Fatalf("FindIndex after reopening = %+v, %+v", table, index)
+	}
+	if err := cat.DropIndex("by_email"); err != nil {
+		t.Fatalf("DropIndex: %v", err)
+	}
+	if err := cat.DropIndex("by_email"); !errors.Is(err, ErrNoSuchIndex) {
+		t.Fatalf("dropping a dropped index: %v, want ErrNoSuchIndex", err)
+	}
+	if cat, err = NewCatalog(testFile); err != nil || len(cat.GetTable("users").Indexes) != 0 {
+		t.Fatalf("index still there after DropIndex and reopening: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package catalog
+
+import "os"
+
+// syncDir flushes a directory so that a rename inside it survives a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
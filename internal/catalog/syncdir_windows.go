@@ -0,0 +1,7 @@
+package catalog
+
+// syncDir does nothing on Windows, where directories cannot be opened for
+// syncing; renames there are made durable by the file system itself.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"bytes"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+)
+
+// indexBatch is how many index entries a Cursor reads at a time.
+const indexBatch = 256
+
+/*
+Cursor reads the rows matching a WHERE clause as the snapshot sees them,
+through one access path. It holds one heap page of rows, or one batch of
+index entries, at a time, so memory does not grow with the table.
+
+An index is read a batch at a time from the key after the last one read. If
+commits keep changing the index while a batch is read (see index.entries),
+the cursor goes on with a heap scan of the keys it has not reached yet.
+*/
+type Cursor struct {
+	t     *Table
+	path  AccessPath
+	where par.Expr
+
+	rest    keyBounds // index keys not read yet
+	useHeap bool      // every heap page is read; with an index path, for the keys in rest
+	page    uint32    // next heap page
+	done    bool
+
+	rids []RID // the rows read ahead
+	rows []Row
+	rid  RID
+	row  Row
+	err  error
+}
+
+// Scan returns a cursor over the rows matching where (nil = all rows), read through p.
+func (t *Table) Scan(p AccessPath, where par.Expr) *Cursor {
+	return &Cursor{t: t, path: p, where: where, rest: p.bounds, useHeap: p.Index == ""}
+}
+
+// Next moves to the next row, reporting false at the end or on an error.
+func (c *Cursor) Next() bool {
+	for len(c.rows) == 0 {
+		if c.done || c.err != nil {
+			return false
+		}
+		if c.useHeap {
+			c.err = c.readPage()
+		} else {
+			c.err = c.readEntries()
+		}
+	}
+	c.rid, c.row = c.rids[0], c.rows[0]
+	c.rids, c.rows = c.rids[1:], c.rows[1:]
+	return true
+}
+
+// Row returns the row Next moved to.
+func (c *Cursor) Row() Row {
+	return c.row
+}
+
+// Err returns the error that ended the scan, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close drops the rows read ahead. The cursor holds no pages between calls, so this is only to free memory early.
+func (c *Cursor) Close() error {
+	c.rids, c.rows, c.done = nil, nil, true
+	return nil
+}
+
+// keep queues a row for Next if where matches it.
+func (c *Cursor) keep(rid RID, row Row) {
+	if c.where == nil || EvalWhere(c.where, c.t.Schema, row) {
+		c.rids = append(c.rids, rid)
+		c.rows = append(c.rows, row)
+	}
+}
+
+// readPage reads the next heap page. The page count is checked each time, as the table's writer may append pages.
+func (c *Cursor) readPage() error {
+	if c.page >= uint32(c.t.heap.pager.PageCount()) {
+		c.done = true
+		return nil
+	}
+	rids, rows, err := visibleOnPage(c.t.heap, c.t.snap, c.page)
+	if err != nil {
+		return err
+	}
+	c.page++
+	for i, row := range rows {
+		if c.useHeap && c.path.Index != "" && !c.rest.includes(c.key(rids[i], row)) {
+			continue
+		}
+		c.keep(rids[i], row)
+	}
+	return nil
+}
+
+// key returns the index key of a row version, for the index the path reads.
+func (c *Cursor) key(rid RID, row Row) []byte {
+	if c.path.ix != nil {
+		return c.path.ix.key(c.t.Schema, row, rid)
+	}
+	return EncodeKey(row[c.t.pkCol])
+}
+
+// readEntries reads the next batch of index entries and the row versions they point at.
+func (c *Cursor) readEntries() error {
+	ix := &c.t.pk
+	if c.path.ix != nil {
+		ix = &c.path.ix.index
+	}
+	entries, ok, err := ix.entries(c.rest, indexBatch)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		c.useHeap = true
+		return nil
+	}
+	for _, e := range entries {
+		if err := c.resolve(e); err != nil {
+			return err
+		}
+	}
+	if len(entries) < indexBatch {
+		c.done = true
+	} else {
+		c.rest.lo, c.rest.loIncl = entries[len(entries)-1].key, false
+	}
+	return nil
+}
+
+// resolve queues the row version an index entry leads to, if the snapshot sees it.
+func (c *Cursor) resolve(e indexEntry) error {
+	if c.path.ix == nil {
+		rid, row, found, err := c.t.resolve(e.key, e.rid)
+		if err == nil && found {
+			c.keep(rid, row)
+		}
+		return err
+	}
+	v, row, found, err := readVersion(c.t.heap, e.rid)
+	if err != nil {
+		return err
+	}
+	// the slot may hold another row by now, if the entry was read before its version was pruned
+	if found && c.t.snap.visible(v.xmin, v.xmax) && bytes.Equal(c.path.ix.key(c.t.Schema, row, e.rid), e.key) {
+		c.keep(e.rid, row)
+	}
+	return nil
+}
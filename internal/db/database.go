@@ -1,96 +1,431 @@
 package db
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/csv"
+	"errors"
+	"fmt"
 
+	"github.com/razzat008/letsgodb/internal/catalog"
 	"github.com/razzat008/letsgodb/internal/storage"
 )
 
-// serializing row : converts a slice of string value into a length prefixed byte slice
-// format: [row_length(uint16)][csv_data]
-func SerializeRow(values []string) []byte {
-	var buf bytes.Buffer
-	writer := csv.NewWriter(&buf)
-	_ = writer.Write(values)
-	writer.Flush()
-	rowBytes := buf.Bytes()
-	length := uint16(len(rowBytes))
-	out := make([]byte, 2+len(rowBytes))
-	binary.LittleEndian.PutUint16(out[0:2], length)
-	copy(out[2:], rowBytes)
-	return out
-}
-
-// deserializeRow reads a length-prefixed row from data and returns the values and bytes consumed.
-func DeserializeRow(data []byte) ([]string, int) {
-	if len(data) < 2 {
-		return nil, 0
-	}
-	length := binary.LittleEndian.Uint16(data[0:2])
-	if len(data) < int(2+length) {
-		return nil, 0
-	}
-	rowBytes := data[2 : 2+length]
-	reader := csv.NewReader(bytes.NewReader(rowBytes))
-	values, err := reader.Read()
+// RID locates a row on disk: the page it lives in and its slot within that page.
+type RID struct {
+	Page uint32
+	Slot uint16
+}
+
+// Pack squeezes a RID into the uint64 value stored in B+Tree leaves.
+func (r RID) Pack() uint64 {
+	return uint64(r.Page)<<16 | uint64(r.Slot)
+}
+
+// UnpackRID is the inverse of RID.Pack.
+func UnpackRID(v uint64) RID {
+	return RID{Page: uint32(v >> 16), Slot: uint16(v)}
+}
+
+// Heap is a table's row storage: slotted pages plus the free-space map that tracks room in them.
+type Heap struct {
+	schema    *catalog.TableSchema // decides how tuples are encoded
+	pager     *storage.Pager
+	fsm       *FreeSpaceMap
+	toastFile *storage.Pager // rows too large for the heap's pages (see toast.go)
+}
+
+// OpenHeap opens the heap file, its free-space map and its toast file, rebuilding the map if it is missing.
+// Writes go to txn when one is given (nil = straight to disk).
+func OpenHeap(heapPath, fsmPath, toastPath string, schema *catalog.TableSchema, txn *storage.Txn) (*Heap, error) {
+	var pagers [3]*storage.Pager
+	for i, path := range []string{heapPath, fsmPath, toastPath} {
+		pager, err := openPager(path, txn)
+		if err != nil {
+			for _, opened := range pagers[:i] {
+				opened.Close()
+			}
+			return nil, err
+		}
+		pagers[i] = pager
+	}
+	h := &Heap{
+		schema:    schema,
+		pager:     pagers[0],
+		fsm:       newFreeSpaceMap(pagers[1]),
+		toastFile: pagers[2],
+	}
+	if h.fsm.pager.PageCount() == 0 {
+		for pageNum := uint32(0); pageNum < uint32(h.pager.PageCount()); pageNum++ {
+			page, err := h.pager.GetPage(pageNum)
+			if err == nil {
+				err = h.fsm.Set(pageNum, slottedPage(page).freeSpace())
+			}
+			if err != nil {
+				h.Close()
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
+
+// Close releases the heap, free-space map and toast files.
+func (h *Heap) Close() error {
+	return errors.Join(h.pager.Close(), h.fsm.pager.Close(), h.toastFile.Close())
+}
+
+// InsertRow places a row in the first page the free-space map says has room,
+// allocating a new page only when none does. Returns the location of the row.
+func InsertRow(h *Heap, row Row) (RID, error) {
+	return insertVersion(h, version{}, row)
+}
+
+// insertVersion stores a row with the given version header, as InsertRow does.
+func insertVersion(h *Heap, v version, row Row) (RID, error) {
+	return insertTuple(h, serializeVersion(h.schema, v, row))
+}
+
+// insertTuple stores an encoded tuple, as InsertRow does.
+func insertTuple(h *Heap, tuple []byte) (RID, error) {
+	tuple, err := h.toast(tuple)
+	if err != nil {
+		return RID{}, err
+	}
+	if len(tuple) > MaxTupleSize {
+		return RID{}, fmt.Errorf("row is %d bytes, larger than the %d byte limit", len(tuple), MaxTupleSize)
+	}
+	// A reused slot needs no directory entry, but asking for one keeps the search simple
+	pageNum, ok, err := h.fsm.Find(len(tuple)+slotSize, h.pager.PageCount())
+	if err != nil {
+		return RID{}, err
+	}
+	var page slottedPage
+	if ok {
+		if page, err = h.pager.GetPage(pageNum); err != nil {
+			return RID{}, err
+		}
+		if err := page.check(); err != nil {
+			return RID{}, fmt.Errorf("page %d: %w", pageNum, err)
+		}
+	}
+	if !ok || !page.fits(len(tuple)) {
+		if pageNum, err = h.pager.AllocatePage(); err != nil {
+			return RID{}, err
+		}
+		page = make(slottedPage, storage.UsablePageSize)
+	}
+	slot := page.insert(tuple)
+	if err := h.pager.FlushPage(pageNum, page); err != nil {
+		return RID{}, err
+	}
+	return RID{Page: pageNum, Slot: uint16(slot)}, h.fsm.Set(pageNum, page.freeSpace())
+}
+
+// ReadRow reads the single row stored at rid.
+func ReadRow(h *Heap, rid RID) (Row, error) {
+	if int(rid.Page) >= h.pager.PageCount() {
+		return nil, fmt.Errorf("row %v: page out of range", rid)
+	}
+	page, err := h.pager.GetPage(rid.Page)
+	if err != nil {
+		return nil, err
+	}
+	tuple, err := slottedPage(page).tuple(int(rid.Slot))
+	if err != nil {
+		return nil, fmt.Errorf("row %v: %w", rid, err)
+	}
+	if tuple == nil {
+		return nil, fmt.Errorf("row %v: no row at this location", rid)
+	}
+	return h.decode(tuple)
+}
+
+// DeleteRow removes the row at rid and returns its space to the page and free-space map.
+func DeleteRow(h *Heap, rid RID) error {
+	if int(rid.Page) >= h.pager.PageCount() {
+		return fmt.Errorf("row %v: page out of range", rid)
+	}
+	data, err := h.pager.GetPage(rid.Page)
+	if err != nil {
+		return err
+	}
+	page := slottedPage(data)
+	if err := page.check(); err != nil {
+		return fmt.Errorf("row %v: %w", rid, err)
+	}
+	tuple, _ := page.tuple(int(rid.Slot))
+	if tuple == nil {
+		return fmt.Errorf("row %v: no row at this location", rid)
+	}
+	if err := h.freeToast(tuple); err != nil {
+		return err
+	}
+	page.remove(int(rid.Slot))
+	if err := h.pager.FlushPage(rid.Page, page); err != nil {
+		return err
+	}
+	return h.fsm.Set(rid.Page, page.freeSpace())
+}
+
+/*
+UpdateRow replaces the row at rid with values.
+The new version stays in the same slot when its page still has room for it;
+otherwise it is moved to another page and the new location is returned.
+*/
+func UpdateRow(h *Heap, rid RID, row Row) (RID, error) {
+	if int(rid.Page) >= h.pager.PageCount() {
+		return RID{}, fmt.Errorf("row %v: page out of range", rid)
+	}
+	data, err := h.pager.GetPage(rid.Page)
+	if err != nil {
+		return RID{}, err
+	}
+	page := slottedPage(data)
+	if err := page.check(); err != nil {
+		return RID{}, fmt.Errorf("row %v: %w", rid, err)
+	}
+	old, _ := page.tuple(int(rid.Slot))
+	if old == nil {
+		return RID{}, fmt.Errorf("row %v: no row at this location", rid)
+	}
+	tuple, err := h.toast(SerializeRow(h.schema, row))
+	if err != nil {
+		return RID{}, err
+	}
+	if err := h.freeToast(old); err != nil {
+		return RID{}, err
+	}
+	page.remove(int(rid.Slot))
+	moved := !page.fitsAt(int(rid.Slot), len(tuple))
+	if !moved {
+		page.put(int(rid.Slot), tuple)
+	}
+	if err := h.pager.FlushPage(rid.Page, page); err != nil {
+		return RID{}, err
+	}
+	if err := h.fsm.Set(rid.Page, page.freeSpace()); err != nil {
+		return RID{}, err
+	}
+	if moved {
+		return insertTuple(h, tuple)
+	}
+	return rid, nil
+}
+
+// ScanRows calls fn with the location and values of every row in the heap.
+func ScanRows(h *Heap, fn func(rid RID, row Row)) error {
+	for pageNum := uint32(0); pageNum < uint32(h.pager.PageCount()); pageNum++ {
+		err := readPage(h, pageNum, func(page slottedPage) error {
+			for slot := 0; slot < page.slotCount(); slot++ {
+				tuple, err := page.tuple(slot)
+				if err != nil {
+					return fmt.Errorf("page %d: %w", pageNum, err)
+				}
+				if tuple == nil {
+					continue
+				}
+				row, err := h.decode(tuple)
+				if err != nil {
+					return fmt.Errorf("page %d slot %d: %w", pageNum, slot, err)
+				}
+				fn(RID{Page: pageNum, Slot: uint16(slot)}, row)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPage calls fn with a heap page pinned in the buffer pool, for reading only.
+func readPage(h *Heap, pageNum uint32, fn func(page slottedPage) error) error {
+	data, unpin, err := h.pager.Pin(pageNum)
+	if err != nil {
+		return err
+	}
+	defer unpin()
+	return fn(slottedPage(data))
+}
+
+/*
+readVersion reads the version stored at rid; ok is false when the slot is empty.
+Unlike ReadRow it accepts pages past the heap's end as seen when it was opened,
+since the index may already point at pages a later commit appended.
+*/
+func readVersion(h *Heap, rid RID) (v version, row Row, ok bool, err error) {
+	page, unpin, err := h.pager.Pin(rid.Page)
 	if err != nil {
-		return nil, 0
+		return version{}, nil, false, err
 	}
-	return values, int(2 + length)
+	defer unpin()
+	tuple, err := slottedPage(page).tuple(int(rid.Slot))
+	if err != nil {
+		return version{}, nil, false, fmt.Errorf("row %v: %w", rid, err)
+	}
+	if tuple == nil {
+		return version{}, nil, false, nil
+	}
+	v, _ = tupleVersion(tuple)
+	row, err = h.decode(tuple)
+	if err != nil {
+		return version{}, nil, false, fmt.Errorf("row %v: %w", rid, err)
+	}
+	return v, row, true, nil
 }
 
-// InsertRow appends a row to the last page with space, or allocates a new page if needed.
-// Returns the page number where the row was written.
-func InsertRow(pager *storage.Pager, values []string) (uint32, error) {
-	rowBytes := SerializeRow(values)
-	// For now, always use page 0 (expand later for multi-page)
-	var pageNum uint32 = 0
-	if pager.PageCount() == 0 {
-		pageNum = pager.AllocatePage()
+// rewriteVersion changes the header of the version at rid in place.
+func rewriteVersion(h *Heap, rid RID, fn func(tuple []byte)) error {
+	page, err := h.pager.GetPage(rid.Page)
+	if err != nil {
+		return err
+	}
+	tuple, err := slottedPage(page).tuple(int(rid.Slot))
+	if err != nil {
+		return fmt.Errorf("row %v: %w", rid, err)
+	}
+	if _, ok := tupleVersion(tuple); !ok {
+		return fmt.Errorf("row %v: no row version at this location", rid)
 	}
-	page := pager.GetPage(pageNum)
+	fn(tuple)
+	return h.pager.FlushPage(rid.Page, page)
+}
 
-	// Find offset: scan for first zero byte (simple, not robust for deletes)
-	offset := 0
-	for offset < storage.PageSize {
-		if page[offset] == 0 {
-			break
+// markDeleted records that transaction xid deleted (or replaced) the version at rid.
+func markDeleted(h *Heap, rid RID, xid XID) error {
+	return rewriteVersion(h, rid, func(tuple []byte) { setXmax(tuple, xid) })
+}
+
+/*
+prunePage removes the versions on a page that were deleted by a transaction
+below horizon, which no snapshot can see any more. fn is called with each
+one before it goes.
+*/
+func prunePage(h *Heap, pageNum uint32, horizon XID, fn func(rid RID, row Row) error) error {
+	data, err := h.pager.GetPage(pageNum)
+	if err != nil {
+		return err
+	}
+	page := slottedPage(data)
+	if err := page.check(); err != nil {
+		return fmt.Errorf("page %d: %w", pageNum, err)
+	}
+	var dead []int
+	for slot := 0; slot < page.slotCount(); slot++ {
+		tuple, _ := page.tuple(slot)
+		if v, ok := tupleVersion(tuple); !ok || v.xmax == 0 || v.xmax >= horizon {
+			continue
+		}
+		row, err := h.decode(tuple)
+		if err != nil {
+			return fmt.Errorf("page %d slot %d: %w", pageNum, slot, err)
+		}
+		if err := fn(RID{Page: pageNum, Slot: uint16(slot)}, row); err != nil {
+			return err
+		}
+		dead = append(dead, slot)
+	}
+	if len(dead) == 0 {
+		return nil
+	}
+	// fn may have rewritten versions on this page
+	if data, err = h.pager.GetPage(pageNum); err != nil {
+		return err
+	}
+	page = slottedPage(data)
+	for _, slot := range dead {
+		tuple, err := page.tuple(slot)
+		if err == nil {
+			err = h.freeToast(tuple)
 		}
-		// Skip to next row
-		_, consumed := DeserializeRow(page[offset:])
-		if consumed == 0 {
-			break
+		if err != nil {
+			return err
 		}
-		offset += consumed
+		page.remove(slot)
 	}
+	if err := h.pager.FlushPage(pageNum, page); err != nil {
+		return err
+	}
+	return h.fsm.Set(pageNum, page.freeSpace())
+}
 
-	if offset+len(rowBytes) > storage.PageSize {
-		// Not enough space, allocate new page
-		pageNum = pager.AllocatePage()
-		page = pager.GetPage(pageNum)
-		offset = 0
+// scanVisible calls fn with the location and values of every row version snap sees.
+// A nil snapshot sees the versions nobody has deleted.
+func scanVisible(h *Heap, snap *Snapshot, fn func(rid RID, row Row) error) error {
+	for pageNum := uint32(0); pageNum < uint32(h.pager.PageCount()); pageNum++ {
+		rids, rows, err := visibleOnPage(h, snap, pageNum)
+		if err != nil {
+			return err
+		}
+		// fn runs unpinned: it may read other pages, or the table's writer may change this one
+		for i, row := range rows {
+			if err := fn(rids[i], row); err != nil {
+				return err
+			}
+		}
 	}
-	copy(page[offset:], rowBytes)
-	err := pager.FlushPage(pageNum, page)
-	return pageNum, err
+	return nil
+}
+
+// visibleOnPage reads the row versions snap sees on one heap page.
+func visibleOnPage(h *Heap, snap *Snapshot, pageNum uint32) (rids []RID, rows []Row, err error) {
+	err = readPage(h, pageNum, func(page slottedPage) error {
+		for slot := 0; slot < page.slotCount(); slot++ {
+			tuple, err := page.tuple(slot)
+			if err != nil {
+				return fmt.Errorf("page %d: %w", pageNum, err)
+			}
+			if tuple == nil {
+				continue
+			}
+			if v, _ := tupleVersion(tuple); !snap.visible(v.xmin, v.xmax) {
+				continue
+			}
+			row, err := h.decode(tuple)
+			if err != nil {
+				return fmt.Errorf("page %d slot %d: %w", pageNum, slot, err)
+			}
+			rids = append(rids, RID{Page: pageNum, Slot: uint16(slot)})
+			rows = append(rows, row)
+		}
+		return nil
+	})
+	return rids, rows, err
 }
 
-// ReadAllRows reads all rows from all pages in the pager.
-func ReadAllRows(pager *storage.Pager) [][]string {
-	var rows [][]string
-	for pageNum := uint32(0); pageNum < uint32(pager.PageCount()); pageNum++ {
-		page := pager.GetPage(pageNum)
-		offset := 0
-		for offset < storage.PageSize {
-			values, consumed := DeserializeRow(page[offset:])
-			if consumed == 0 || values == nil || (len(values) > 0 && values[0] == "") {
-				break
+// scanVersions calls fn with the location, version header and values of every row version in the heap, deleted ones included.
+func scanVersions(h *Heap, fn func(rid RID, v version, row Row) error) error {
+	for pageNum := uint32(0); pageNum < uint32(h.pager.PageCount()); pageNum++ {
+		var rids []RID
+		var versions []version
+		var rows []Row
+		err := readPage(h, pageNum, func(page slottedPage) error {
+			for slot := 0; slot < page.slotCount(); slot++ {
+				tuple, err := page.tuple(slot)
+				if err != nil {
+					return fmt.Errorf("page %d: %w", pageNum, err)
+				}
+				if tuple == nil {
+					continue
+				}
+				row, err := h.decode(tuple)
+				if err != nil {
+					return fmt.Errorf("page %d slot %d: %w", pageNum, slot, err)
+				}
+				v, _ := tupleVersion(tuple)
+				rids = append(rids, RID{Page: pageNum, Slot: uint16(slot)})
+				versions = append(versions, v)
+				rows = append(rows, row)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, row := range rows {
+			if err := fn(rids[i], versions[i], row); err != nil {
+				return err
 			}
-			rows = append(rows, values)
-			offset += consumed
 		}
 	}
-	return rows
+	return nil
 }
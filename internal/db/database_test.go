@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+)
+
+func TestHeapSlotReuse(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{Name: "t", Columns: []string{"id", "name"}, PrimaryKey: "id"}
+	heapPath, fsmPath, toastPath := filepath.Join(dir, "t.heap"), filepath.Join(dir, "t.fsm"), filepath.Join(dir, "t.toast")
+	h, err := OpenHeap(heapPath, fsmPath, toastPath, schema, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := func(i int) RID {
+		t.Helper()
+		row, err := ParseRow(schema, []string{fmt.Sprint(i), fmt.Sprintf("'row-%d'", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rid, err := InsertRow(h, row)
+		if err != nil {
+			t.Fatalf("InsertRow(%d): %v", i, err)
+		}
+		return rid
+	}
+
+	var rids []RID
+	for i := 0; i < 300; i++ {
+		rids = append(rids, insert(i))
+	}
+	if h.pager.PageCount() < 2 {
+		t.Fatalf("300 rows fit in %d page", h.pager.PageCount())
+	}
+
+	// A deleted row's slot on the first page is the next one filled, not a new one at the end
+	if err := DeleteRow(h, rids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRow(h, rids[1]); err == nil {
+		t.Errorf("ReadRow of a deleted row: no error")
+	}
+	if err := DeleteRow(h, rids[1]); err == nil {
+		t.Errorf("deleting a row twice: no error")
+	}
+	if rid := insert(1000); rid != rids[1] {
+		t.Errorf("insert after delete went to %v, want %v", rid, rids[1])
+	}
+	if row, err := ReadRow(h, rids[2]); err != nil || !slices.Equal(row.Strings(), []string{"2", "row-2"}) {
+		t.Errorf("neighbouring row reads %v, %v", row, err)
+	}
+
+	// A missing free-space map is rebuilt from the pages on open
+	if err := DeleteRow(h, rids[0]); err != nil {
+		t.Fatal(err)
+	}
+	pages := h.pager.PageCount()
+	h.Close()
+	if err := os.Remove(fsmPath); err != nil {
+		t.Fatal(err)
+	}
+	if h, err = OpenHeap(heapPath, fsmPath, toastPath, schema, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if rid := insert(1001); rid != rids[0] {
+		t.Errorf("insert after rebuilding the map went to %v, want %v", rid, rids[0])
+	}
+	if h.pager.PageCount() != pages {
+		t.Errorf("heap grew from %d to %d pages", pages, h.pager.PageCount())
+	}
+}
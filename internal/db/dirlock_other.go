@@ -0,0 +1,14 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockDir only creates the lock file where flock is not available; keeping
+// other processes away from the database is then up to the user.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, "LOCK"), os.O_RDWR|os.O_CREATE, 0644)
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"strings"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+)
+
+/*
+Costs are in pages read, plus a charge for every row decoded and checked
+against WHERE; with pages mostly coming from the buffer pool, rows weigh
+more than usual. The pool makes no difference between reading pages in order
+and at random, so an index scan costs the index pages it reads and the heap
+pages holding its rows, at most once each.
+
+Without statistics on column values, selectivities are fixed guesses: an
+equality matches few rows (one if the column is unique), a comparison a third.
+*/
+const (
+	statsSamplePages    = 16    // heap pages Stats reads to count rows
+	cpuRowCost          = 0.1   // cost of examining one row
+	indexEntriesPerPage = 100   // rough B+Tree leaf fan-out
+	eqSelectivity       = 0.005 // share of rows an equality matches
+	rangeSelectivity    = 1.0 / 3
+)
+
+// TableStats estimates the size of a table, for planning.
+type TableStats struct {
+	Pages int     // heap pages
+	Rows  float64 // current rows
+}
+
+// Stats estimates the table's size by counting the current rows on a sample of its heap pages.
+func (t *Table) Stats() (TableStats, error) {
+	if t.stats != nil {
+		return *t.stats, nil
+	}
+	pages := t.heap.pager.PageCount()
+	sample := min(pages, statsSamplePages)
+	live := 0
+	for i := 0; i < sample; i++ {
+		err := readPage(t.heap, uint32(i*pages/sample), func(page slottedPage) error {
+			for slot := 0; slot < page.slotCount(); slot++ {
+				tuple, err := page.tuple(slot)
+				if err != nil {
+					return err
+				}
+				if tuple == nil {
+					continue
+				}
+				if v, ok := tupleVersion(tuple); !ok || v.xmax == 0 {
+					live++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return TableStats{}, err
+		}
+	}
+	st := TableStats{Pages: pages}
+	if sample > 0 {
+		st.Rows = float64(live) * float64(pages) / float64(sample)
+	}
+	t.stats = &st
+	return st, nil
+}
+
+// Selectivity estimates the share of the table's rows where matches (nil = all rows).
+func (t *Table) Selectivity(where par.Expr) (float64, error) {
+	st, err := t.Stats()
+	if err != nil {
+		return 0, err
+	}
+	if where == nil {
+		return 1, nil
+	}
+	return t.selectivity(where, st.Rows), nil
+}
+
+func (t *Table) selectivity(expr par.Expr, rows float64) float64 {
+	switch e := expr.(type) {
+	case *par.Condition:
+		eq := eqSelectivity
+		if t.unique(e.Column) {
+			eq = 1 / max(rows, 1)
+		}
+		switch e.Operator {
+		case "=":
+			return eq
+		case "!=":
+			return 1 - eq
+		}
+		return rangeSelectivity
+	case *par.IsNullExpr:
+		if e.Not {
+			return 1 - eqSelectivity
+		}
+		return eqSelectivity
+	case *par.NotExpr:
+		return 1 - t.selectivity(e.Expr, rows)
+	case *par.BinaryExpr:
+		l, r := t.selectivity(e.Left, rows), t.selectivity(e.Right, rows)
+		if strings.ToUpper(e.Operator) == "AND" {
+			return l * r
+		}
+		return l + r - l*r
+	}
+	return 1
+}
+
+// unique reports whether no two current rows can share a non-NULL value of column.
+func (t *Table) unique(column string) bool {
+	if column == t.Schema.PrimaryKey {
+		return true
+	}
+	for _, ix := range t.indexes {
+		if ix.schema.Unique && len(ix.cols) == 1 && t.Schema.Columns[ix.cols[0]] == column {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessPath is one way to read the rows of a table that match a WHERE clause.
+type AccessPath struct {
+	Index string  // index read, catalog.PrimaryKeyIndex for the primary key ("" = every heap page)
+	Cond  string  // the comparisons that bound the part of the index read, e.g. "age >= 18"
+	Rows  float64 // estimated rows read, before the rest of WHERE is checked
+	Cost  float64
+
+	ix     *secondaryIndex // nil for the heap and the primary key
+	bounds keyBounds       // keys read from the index
+}
+
+/*
+AccessPaths lists the ways to read the rows matching where, with their
+estimated cost: the heap scan first, then the primary key and each
+secondary index whose leading column where bounds.
+*/
+func (t *Table) AccessPaths(where par.Expr) ([]AccessPath, error) {
+	st, err := t.Stats()
+	if err != nil {
+		return nil, err
+	}
+	paths := []AccessPath{{Rows: st.Rows, Cost: float64(st.Pages) + st.Rows*cpuRowCost}}
+	if r, ok := keyRange(where, t.Schema.PrimaryKey, t.Schema.TypeOf(t.pkCol)); ok {
+		paths = append(paths, t.indexPath(catalog.PrimaryKeyIndex, t.Schema.PrimaryKey, where, r, st))
+	}
+	for _, ix := range t.indexes {
+		col := ix.cols[0]
+		if r, ok := keyRange(where, t.Schema.Columns[col], t.Schema.TypeOf(col)); ok {
+			p := t.indexPath(ix.schema.Name, t.Schema.Columns[col], where, r, st)
+			p.ix, p.bounds = ix, ix.bounds(t.Schema.TypeOf(col), r)
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+func (t *Table) indexPath(name, column string, where par.Expr, r keyBounds, st TableStats) AccessPath {
+	var sel float64
+	switch {
+	case r.lo != nil && r.hi != nil && r.loIncl && r.hiIncl && string(r.lo) == string(r.hi):
+		sel = t.selectivity(&par.Condition{Column: column, Operator: "="}, st.Rows)
+	case r.lo != nil && r.hi != nil:
+		sel = rangeSelectivity * rangeSelectivity
+	default:
+		sel = rangeSelectivity
+	}
+	rows := st.Rows * sel
+	return AccessPath{
+		Index:  name,
+		Cond:   strings.Join(conditionsOn(where, column), " AND "),
+		Rows:   rows,
+		Cost:   1 + rows/indexEntriesPerPage + min(rows, float64(st.Pages)) + rows*cpuRowCost,
+		bounds: r,
+	}
+}
+
+// conditionsOn renders the comparisons on column that keyRange narrows an index range with.
+func conditionsOn(expr par.Expr, column string) []string {
+	switch e := expr.(type) {
+	case *par.Condition:
+		if e.Column == column && e.Operator != "!=" {
+			return []string{par.FormatExpr(e)}
+		}
+	case *par.BinaryExpr:
+		if strings.ToUpper(e.Operator) == "AND" {
+			return append(conditionsOn(e.Left, column), conditionsOn(e.Right, column)...)
+		}
+	}
+	return nil
+}
+
+// BestPath returns the cheapest way to read the rows matching where.
+func (t *Table) BestPath(where par.Expr) (AccessPath, error) {
+	paths, err := t.AccessPaths(where)
+	if err != nil {
+		return AccessPath{}, err
+	}
+	best := paths[0]
+	for _, p := range paths[1:] {
+		if p.Cost < best.Cost {
+			best = p
+		}
+	}
+	return best, nil
+}
@@ -4,55 +4,85 @@ import (
 	"strings"
 
 	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
 )
 
+// truth is a three-valued logic result: comparisons involving NULL are unknown.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthUnknown
+	truthTrue
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
 // EvalWhere evaluates a WHERE expression (Expr) against a row.
-// columns: schema column names
-// row: row values (as []string)
-func EvalWhere(expr par.Expr, columns, row []string) bool {
+// schema: the table's columns and their types
+// row: typed row values
+// A row matches only when the expression is TRUE; FALSE and UNKNOWN both filter it out.
+func EvalWhere(expr par.Expr, schema *catalog.TableSchema, row Row) bool {
+	return evalTruth(expr, schema, row) == truthTrue
+}
+
+func evalTruth(expr par.Expr, schema *catalog.TableSchema, row Row) truth {
 	switch e := expr.(type) {
 	case *par.Condition:
 		// Find column index
-		idx := -1
-		for i, col := range columns {
-			if col == e.Column {
-				idx = i
-				break
-			}
-		}
+		idx := schema.ColumnIndex(e.Column)
 		if idx == -1 || idx >= len(row) {
-			return false
+			return truthFalse
+		}
+		// compare the stored value (age in `age > 18`) with the literal (18) as the column's type,
+		// so numbers are ordered numerically rather than as text
+		c, ok := CompareLiteral(row[idx], e.Value)
+		if !ok {
+			return truthUnknown
 		}
-		val := strings.Trim(row[idx], "'") // base value: which we're comparing with | in age > 18
-		condVal := strings.Trim(e.Value, "'") // value we're matching against | 18 is the condVal and other numbers are val
 		switch e.Operator {
 		case "=":
-			return val == condVal
+			return truthOf(c == 0)
 		case "!=":
-			return val != condVal
+			return truthOf(c != 0)
 		case ">":
-			return val > condVal
+			return truthOf(c > 0)
 		case "<":
-			return val < condVal
+			return truthOf(c < 0)
 		case ">=":
-			return val >= condVal
+			return truthOf(c >= 0)
 		case "<=":
-			return val <= condVal
+			return truthOf(c <= 0)
 		default:
-			return false
+			return truthFalse
+		}
+	case *par.IsNullExpr:
+		idx := schema.ColumnIndex(e.Column)
+		if idx == -1 || idx >= len(row) {
+			return truthFalse
 		}
+		return truthOf(row[idx].Null != e.Not)
+	case *par.NotExpr:
+		// NOT UNKNOWN stays UNKNOWN
+		return truthTrue - evalTruth(e.Expr, schema, row)
 	case *par.BinaryExpr:
-		left := EvalWhere(e.Left, columns, row)
-		right := EvalWhere(e.Right, columns, row)
+		left := evalTruth(e.Left, schema, row)
+		right := evalTruth(e.Right, schema, row)
+		// with FALSE < UNKNOWN < TRUE, AND is the minimum and OR the maximum
 		switch strings.ToUpper(e.Operator) {
 		case "AND":
-			return left && right
+			return min(left, right)
 		case "OR":
-			return left || right
+			return max(left, right)
 		default:
-			return false
+			return truthFalse
 		}
 	default:
-		return false
+		return truthFalse
 	}
 }
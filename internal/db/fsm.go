@@ -0,0 +1,73 @@
+package db
+
+import (
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+FreeSpaceMap records roughly how much room each heap page has left, so inserts
+can find a page without reading the whole table.
+It lives in its own file with one byte per heap page: the page's free bytes
+divided by fsmGranularity. A page whose byte is c has at least c*fsmGranularity
+bytes free.
+*/
+type FreeSpaceMap struct {
+	pager *storage.Pager
+}
+
+const fsmGranularity = 16
+
+func newFreeSpaceMap(pager *storage.Pager) *FreeSpaceMap {
+	return &FreeSpaceMap{pager: pager}
+}
+
+// Set records that heap page pageNum has free bytes available.
+func (m *FreeSpaceMap) Set(pageNum uint32, free int) error {
+	fsmPage := pageNum / storage.UsablePageSize
+	for uint32(m.pager.PageCount()) <= fsmPage {
+		if _, err := m.pager.AllocatePage(); err != nil {
+			return err
+		}
+	}
+	page, err := m.pager.GetPage(fsmPage)
+	if err != nil {
+		return err
+	}
+	page[pageNum%storage.UsablePageSize] = byte(min(free/fsmGranularity, 255))
+	return m.pager.FlushPage(fsmPage, page)
+}
+
+// Find returns the first heap page (below heapPages) recorded as having at least need free bytes.
+func (m *FreeSpaceMap) Find(need int, heapPages int) (uint32, bool, error) {
+	// round up so any page we pick is guaranteed to have room
+	category := (need + fsmGranularity - 1) / fsmGranularity
+	for fsmPage := 0; fsmPage < m.pager.PageCount(); fsmPage++ {
+		pageNum, done, err := m.findIn(uint32(fsmPage), category, heapPages)
+		if err != nil {
+			return 0, false, err
+		}
+		if done {
+			return uint32(max(pageNum, 0)), pageNum >= 0, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// findIn searches one map page; done is false if the search has to go on to the next one.
+func (m *FreeSpaceMap) findIn(fsmPage uint32, category, heapPages int) (pageNum int, done bool, err error) {
+	page, unpin, err := m.pager.Pin(fsmPage)
+	if err != nil {
+		return 0, false, err
+	}
+	defer unpin()
+	for i, c := range page {
+		pageNum := int(fsmPage)*storage.UsablePageSize + i
+		if pageNum >= heapPages {
+			return -1, true, nil
+		}
+		if int(c) >= category {
+			return pageNum, true, nil
+		}
+	}
+	return 0, false, nil
+}
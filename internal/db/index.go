@@ -0,0 +1,313 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/razzat008/letsgodb/internal/btree"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+// ErrDuplicateValue is wrapped by the error for a row that would give a unique index the same values twice.
+var ErrDuplicateValue = errors.New("duplicate value violates unique index")
+
+// index is an open B+Tree index file.
+type index struct {
+	*btree.BTree
+	file *storage.Pager
+	gen  uint64 // generation of file when the root was read
+}
+
+// openIndex opens (or creates) an index file; fresh reports whether it was new, and needs filling.
+func openIndex(path string, txn *storage.Txn) (ix index, fresh bool, err error) {
+	file, err := openPager(path, txn)
+	if err != nil {
+		return index{}, false, err
+	}
+	gen, fresh := file.Generation(), file.PageCount() == 0
+	tree, err := btree.Open(file)
+	if err != nil {
+		file.Close()
+		return index{}, false, err
+	}
+	return index{BTree: tree, file: file, gen: gen}, fresh, nil
+}
+
+func (ix *index) close() error {
+	if ix.file == nil {
+		return nil
+	}
+	return ix.file.Close()
+}
+
+// refresh rereads the index root if a commit has written the index since it was read.
+func (ix *index) refresh() error {
+	gen := ix.file.Generation()
+	if gen == ix.gen {
+		return nil
+	}
+	tree, err := btree.Open(ix.file)
+	if err != nil {
+		return err
+	}
+	ix.BTree, ix.gen = tree, gen
+	return nil
+}
+
+type indexEntry struct {
+	key []byte
+	rid RID
+}
+
+/*
+entries collects the first limit index entries in r. A transaction committing while
+the nodes are read may split ones not reached yet, so the entries only count
+if nothing was written to the index meanwhile; ok is false when that keeps
+happening and the heap has to be scanned instead.
+*/
+func (ix *index) entries(r keyBounds, limit int) (entries []indexEntry, ok bool, err error) {
+	for try := 0; try < 3; try++ {
+		if err := ix.refresh(); err != nil {
+			return nil, false, err
+		}
+		entries = entries[:0]
+		c := ix.Seek(r.lo)
+		for ; c.Valid() && len(entries) < limit; c.Next() {
+			if !r.includes(c.Key()) {
+				if r.past(c.Key()) {
+					break
+				}
+				continue
+			}
+			entries = append(entries, indexEntry{bytes.Clone(c.Key()), UnpackRID(c.Value())})
+		}
+		if ix.file.Generation() == ix.gen {
+			return entries, c.Err() == nil, c.Err()
+		}
+	}
+	return nil, false, nil
+}
+
+// IndexPath returns the B+Tree file of a table's secondary index.
+func IndexPath(dbDir, table, index string) string {
+	return filepath.Join(dbDir, table+"."+index+".idx")
+}
+
+/*
+A secondary index has an entry for every row version in the heap, not just
+the newest one, so any snapshot finds the versions it sees; entries go when
+their version is pruned. An entry's key is the indexed values followed by the
+version's RID, which keeps keys unique when rows share values:
+
+	value  [0x00] for NULL, or [0x01] and the value's EncodeKey bytes
+	       (TEXT and BLOB with 0x00 escaped as 0x00 0xFF and ended by 0x00 0x00)
+	rid    RID.Pack, big-endian
+
+Each value's bytes sort the way its column does and none is the prefix of
+another, so the entries for a value of the first column lie together and a
+range of values is a range of keys. NULLs sort first.
+*/
+type secondaryIndex struct {
+	index
+	schema catalog.IndexSchema
+	cols   []int // positions of the indexed columns in the table
+}
+
+const (
+	keyNull    = 0x00
+	keyNotNull = 0x01
+)
+
+// appendKeyValue appends the index key bytes of one value of a column of type typ.
+func appendKeyValue(dst []byte, typ catalog.ColumnType, v Value) []byte {
+	if v.Null {
+		return append(dst, keyNull)
+	}
+	return appendEncoded(append(dst, keyNotNull), typ, EncodeKey(v))
+}
+
+// appendEncoded appends EncodeKey bytes of a non-NULL value, made prefix-free for variable-length types.
+func appendEncoded(dst []byte, typ catalog.ColumnType, key []byte) []byte {
+	if typ != catalog.TypeText && typ != catalog.TypeBlob {
+		return append(dst, key...)
+	}
+	for _, b := range key {
+		dst = append(dst, b)
+		if b == 0 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return append(dst, 0, 0)
+}
+
+// prefix returns the key bytes of a row's indexed values; hasNull reports whether one of them is NULL.
+func (ix *secondaryIndex) prefix(schema *catalog.TableSchema, row Row) (prefix []byte, hasNull bool) {
+	for _, col := range ix.cols {
+		prefix = appendKeyValue(prefix, schema.TypeOf(col), row[col])
+		hasNull = hasNull || row[col].Null
+	}
+	return prefix, hasNull
+}
+
+// key returns the entry key of the row version at rid.
+func (ix *secondaryIndex) key(schema *catalog.TableSchema, row Row, rid RID) []byte {
+	prefix, _ := ix.prefix(schema, row)
+	return binary.BigEndian.AppendUint64(prefix, rid.Pack())
+}
+
+// bounds turns a range of values of the index's first column into the range of entry keys holding them.
+func (ix *secondaryIndex) bounds(typ catalog.ColumnType, r keyBounds) keyBounds {
+	// non-NULL values only: a comparison with NULL is never true
+	out := keyBounds{lo: []byte{keyNotNull}, hi: []byte{keyNotNull + 1}, loIncl: true}
+	if r.lo != nil {
+		out.lo = appendEncoded([]byte{keyNotNull}, typ, r.lo)
+		if !r.loIncl {
+			out.lo = prefixEnd(out.lo)
+		}
+	}
+	if r.hi != nil {
+		out.hi = appendEncoded([]byte{keyNotNull}, typ, r.hi)
+		if r.hiIncl {
+			out.hi = prefixEnd(out.hi)
+		}
+	}
+	return out
+}
+
+// prefixEnd returns the smallest key above every key starting with prefix (nil if there is none).
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// describe renders a row's indexed values for error messages, e.g. "(a, 1)".
+func (ix *secondaryIndex) describe(row Row) string {
+	values := make([]string, len(ix.cols))
+	for i, col := range ix.cols {
+		values[i] = row[col].String()
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
+
+// openIndexes opens the secondary indexes listed in the table's schema, building the ones whose file is new.
+func (t *Table) openIndexes(dbDir string, txn *storage.Txn) error {
+	for _, s := range t.Schema.Indexes {
+		if err := t.openSecondary(dbDir, s, txn); err != nil {
+			return fmt.Errorf("index %q: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+func (t *Table) openSecondary(dbDir string, s catalog.IndexSchema, txn *storage.Txn) error {
+	ix := &secondaryIndex{schema: s}
+	for _, name := range s.Columns {
+		col := t.Schema.ColumnIndex(name)
+		if col == -1 {
+			return fmt.Errorf("column %q %w in table %q", name, catalog.ErrNoSuchColumn, t.Schema.Name)
+		}
+		ix.cols = append(ix.cols, col)
+	}
+	var fresh bool
+	var err error
+	if ix.index, fresh, err = openIndex(IndexPath(dbDir, t.Schema.Name, s.Name), txn); err != nil {
+		return err
+	}
+	t.indexes = append(t.indexes, ix)
+	if fresh && t.heap.pager.PageCount() > 0 {
+		return t.buildIndex(ix)
+	}
+	return nil
+}
+
+// buildIndex adds an entry for every row version in the heap to an empty index, checking uniqueness among the current rows.
+func (t *Table) buildIndex(ix *secondaryIndex) error {
+	current := make(map[string]bool)
+	return scanVersions(t.heap, func(rid RID, v version, row Row) error {
+		if prefix, hasNull := ix.prefix(t.Schema, row); ix.schema.Unique && !hasNull && v.xmax == 0 {
+			if current[string(prefix)] {
+				return fmt.Errorf("%w %q: %s appears more than once", ErrDuplicateValue, ix.schema.Name, ix.describe(row))
+			}
+			current[string(prefix)] = true
+		}
+		return ix.insert(t.Schema, row, rid)
+	})
+}
+
+func (ix *secondaryIndex) insert(schema *catalog.TableSchema, row Row, rid RID) error {
+	key := ix.key(schema, row, rid)
+	if len(key) > btree.MaxKeySize {
+		return fmt.Errorf("index %q: values %s too long (max %d bytes)", ix.schema.Name, ix.describe(row), btree.MaxKeySize-8)
+	}
+	return ix.Insert(key, rid.Pack())
+}
+
+/*
+checkIndexes makes sure row can be added to every secondary index: its
+values fit in a key, and no current row has the same values in a unique index.
+Rows deleted by a transaction the snapshot does not see fail with
+ErrSerialization, as for primary keys.
+*/
+func (t *Table) checkIndexes(row Row) error {
+	for _, ix := range t.indexes {
+		prefix, hasNull := ix.prefix(t.Schema, row)
+		if len(prefix)+8 > btree.MaxKeySize {
+			return fmt.Errorf("index %q: values %s too long (max %d bytes)", ix.schema.Name, ix.describe(row), btree.MaxKeySize-8)
+		}
+		if !ix.schema.Unique || hasNull {
+			continue
+		}
+		c := ix.Seek(prefix)
+		for ; c.Valid() && bytes.HasPrefix(c.Key(), prefix); c.Next() {
+			rid := UnpackRID(c.Value())
+			v, other, found, err := readVersion(t.heap, rid)
+			if err != nil {
+				return err
+			}
+			if !found || !bytes.Equal(ix.key(t.Schema, other, rid), c.Key()) {
+				continue
+			}
+			switch {
+			case v.xmax == 0:
+				return fmt.Errorf("%w %q: %s", ErrDuplicateValue, ix.schema.Name, ix.describe(row))
+			case t.snap != nil && !t.snap.sees(v.xmax):
+				return ErrSerialization
+			}
+		}
+		if err := c.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addEntries adds the row version at rid to every secondary index.
+func (t *Table) addEntries(rid RID, row Row) error {
+	for _, ix := range t.indexes {
+		if err := ix.insert(t.Schema, row, rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEntries takes the row version at rid out of every secondary index.
+func (t *Table) removeEntries(rid RID, row Row) error {
+	for _, ix := range t.indexes {
+		if _, err := ix.Delete(ix.key(t.Schema, row, rid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
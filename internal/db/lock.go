@@ -0,0 +1,170 @@
+// Table locks: sessions share a Database, so every table a transaction uses
+// is locked until the transaction ends (strict two-phase locking). Readers
+// work from MVCC snapshots and share a table with its writer; writers take
+// turns, which keeps two transactions from committing diverging copies of the
+// same page. Dropping a table waits until nobody else uses it.
+package db
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// LockMode is how a transaction holds a table.
+type LockMode int
+
+const (
+	LockShared          LockMode = iota + 1 // reading: any number of transactions, alongside one writer
+	LockExclusive                           // writing: one transaction at a time
+	LockAccessExclusive                     // dropping: one transaction and nobody else
+)
+
+// ErrDeadlock is returned to the transaction whose lock request would wait forever.
+var ErrDeadlock = errors.New("deadlock detected")
+
+func conflicts(a, b LockMode) bool {
+	if a == LockAccessExclusive || b == LockAccessExclusive {
+		return true
+	}
+	return a == LockExclusive && b == LockExclusive
+}
+
+// tableLock is the lock on one table: who holds it and who waits for it, first come first served.
+type tableLock struct {
+	holders map[*Session]LockMode
+	queue   []lockRequest
+}
+
+type lockRequest struct {
+	session *Session
+	table   string
+	mode    LockMode
+}
+
+type lockManager struct {
+	mu      sync.Mutex
+	changed sync.Cond // broadcast whenever a lock is released or a waiter gives up
+	tables  map[string]*tableLock
+	waiting map[*Session]lockRequest // sessions blocked in acquire
+}
+
+func newLockManager() *lockManager {
+	m := &lockManager{
+		tables:  make(map[string]*tableLock),
+		waiting: make(map[*Session]lockRequest),
+	}
+	m.changed.L = &m.mu
+	return m
+}
+
+/*
+acquire locks a table for s, waiting behind conflicting holders and earlier
+conflicting requests. A lock s holds is upgraded in place when it asks for a
+stronger one. If waiting would close a cycle of sessions waiting on each
+other, s gets ErrDeadlock instead and the others keep waiting.
+*/
+func (m *lockManager) acquire(s *Session, table string, mode LockMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.tables[table]
+	if !ok {
+		l = &tableLock{holders: make(map[*Session]LockMode)}
+		m.tables[table] = l
+	}
+	held, holds := l.holders[s]
+	if held >= mode {
+		return nil
+	}
+	req := lockRequest{s, table, mode}
+	if !holds {
+		l.queue = append(l.queue, req)
+	}
+	for {
+		if len(m.blockers(req)) == 0 {
+			l.holders[s] = mode
+			m.dequeue(l, s)
+			return nil
+		}
+		if m.reaches(req, s, make(map[*Session]bool)) {
+			m.dequeue(l, s)
+			m.changed.Broadcast()
+			return ErrDeadlock
+		}
+		m.waiting[s] = req
+		m.changed.Wait()
+		delete(m.waiting, s)
+	}
+}
+
+func (m *lockManager) dequeue(l *tableLock, s *Session) {
+	l.queue = slices.DeleteFunc(l.queue, func(r lockRequest) bool { return r.session == s })
+}
+
+// blockers returns the sessions req has to wait for: holders of a conflicting
+// lock and, unless req upgrades a lock it already holds, conflicting requests queued ahead of it.
+func (m *lockManager) blockers(req lockRequest) []*Session {
+	var out []*Session
+	l := m.tables[req.table]
+	for other, mode := range l.holders {
+		if other != req.session && conflicts(req.mode, mode) {
+			out = append(out, other)
+		}
+	}
+	if _, holds := l.holders[req.session]; holds {
+		return out
+	}
+	for _, ahead := range l.queue {
+		if ahead.session == req.session {
+			break
+		}
+		if conflicts(req.mode, ahead.mode) {
+			out = append(out, ahead.session)
+		}
+	}
+	return out
+}
+
+// reaches reports whether req is blocked by target directly or through other waiting sessions.
+func (m *lockManager) reaches(req lockRequest, target *Session, seen map[*Session]bool) bool {
+	for _, b := range m.blockers(req) {
+		if b == target {
+			return true
+		}
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		if next, ok := m.waiting[b]; ok && m.reaches(next, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// held returns the mode s holds table in, 0 if it holds no lock on it.
+func (m *lockManager) held(s *Session, table string) LockMode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.tables[table]; ok {
+		return l.holders[s]
+	}
+	return 0
+}
+
+// releaseAll drops every lock s holds and wakes the sessions waiting for one.
+func (m *lockManager) releaseAll(s *Session, tables []string) {
+	if len(tables) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, table := range tables {
+		l := m.tables[table]
+		delete(l.holders, s)
+		if len(l.holders) == 0 && len(l.queue) == 0 {
+			delete(m.tables, table)
+		}
+	}
+	m.changed.Broadcast()
+}
@@ -0,0 +1,159 @@
+/*
+Multi-version concurrency control: every tuple carries the id of the
+transaction that created it (xmin) and of the one that deleted or replaced it
+(xmax, 0 while it is current). A reader decides from its snapshot which
+version of a row it sees, so it needs no lock that would hold up writers and
+never sees a transaction half applied.
+
+Transactions write into private pages until they commit, so every xid found
+on disk belongs to a committed transaction; a snapshot only has to tell which
+of them had committed when it was taken.
+*/
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// XID identifies a transaction. 0 marks versions every transaction sees (rows written before versioning).
+type XID uint64
+
+// ErrSerialization is returned when a transaction tries to change a row that a
+// transaction it cannot see has changed since its snapshot was taken.
+var ErrSerialization = errors.New("could not serialize access due to concurrent update")
+
+// Snapshot is the set of transactions whose changes a transaction sees: its
+// own and those that had committed when the snapshot was taken.
+type Snapshot struct {
+	xid    XID          // the transaction the snapshot belongs to
+	xmin   XID          // every transaction below xmin had finished
+	xmax   XID          // transactions from xmax on had not started
+	active map[XID]bool // transactions in [xmin, xmax) still running
+}
+
+// sees reports whether the changes of transaction x are visible in the snapshot.
+func (s *Snapshot) sees(x XID) bool {
+	switch {
+	case x == s.xid || x < s.xmin:
+		return true
+	case x >= s.xmax:
+		return false
+	}
+	return !s.active[x]
+}
+
+// visible reports whether a tuple version created by xmin and deleted by xmax (0 = not deleted) is seen.
+// Without a snapshot (a table used by one writer at a time) only undeleted versions are.
+func (s *Snapshot) visible(xmin, xmax XID) bool {
+	if s == nil {
+		return xmax == 0
+	}
+	return s.sees(xmin) && (xmax == 0 || !s.sees(xmax))
+}
+
+/*
+xidLease is how many xids are reserved on disk at a time. The file holding
+the reservation is rewritten only when it runs out, and a crash at most skips
+the rest of a lease.
+*/
+const xidLease = 1 << 16
+
+// txnManager hands out transaction ids and snapshots and knows which transactions are running.
+type txnManager struct {
+	mu      sync.Mutex
+	file    *os.File
+	next    XID
+	limit   XID               // xids below limit are reserved in file
+	running map[XID]*Snapshot // every running transaction and its current snapshot
+}
+
+func openTxnManager(dir string) (*txnManager, error) {
+	file, err := os.OpenFile(filepath.Join(dir, "xid"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open xid file: %w", err)
+	}
+	var buf [8]byte
+	n, err := file.ReadAt(buf[:], 0)
+	if err != nil && n != 0 {
+		file.Close()
+		return nil, fmt.Errorf("failed to read xid file: %w", err)
+	}
+	// Ids up to the last reservation may have been used before a crash
+	m := &txnManager{file: file, next: max(1, XID(binary.LittleEndian.Uint64(buf[:]))), running: make(map[XID]*Snapshot)}
+	m.limit = m.next
+	return m, nil
+}
+
+// begin starts a transaction and returns its snapshot.
+func (m *txnManager) begin() (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.next >= m.limit {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(m.next+xidLease))
+		if _, err := m.file.WriteAt(buf[:], 0); err != nil {
+			return nil, fmt.Errorf("failed to reserve xids: %w", err)
+		}
+		if err := m.file.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to reserve xids: %w", err)
+		}
+		m.limit = m.next + xidLease
+	}
+	xid := m.next
+	m.next++
+	snap := m.take(xid)
+	m.running[xid] = snap
+	return snap, nil
+}
+
+// refresh replaces the snapshot of a running transaction with a current one.
+func (m *txnManager) refresh(snap *Snapshot) *Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fresh := m.take(snap.xid)
+	m.running[snap.xid] = fresh
+	return fresh
+}
+
+func (m *txnManager) take(xid XID) *Snapshot {
+	snap := &Snapshot{xid: xid, xmin: m.next, xmax: m.next, active: make(map[XID]bool)}
+	for other := range m.running {
+		if other != xid {
+			snap.active[other] = true
+			snap.xmin = min(snap.xmin, other)
+		}
+	}
+	snap.xmin = min(snap.xmin, xid)
+	return snap
+}
+
+// end forgets a transaction once its pages are in place (or discarded).
+func (m *txnManager) end(xid XID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, xid)
+}
+
+/*
+horizon returns the oldest xid some running transaction may not see yet.
+A version deleted by a transaction below the horizon is invisible to every
+snapshot, current and future, and its space can be reused.
+*/
+func (m *txnManager) horizon() XID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.next
+	for _, snap := range m.running {
+		h = min(h, snap.xmin)
+	}
+	return h
+}
+
+func (m *txnManager) close() error {
+	return m.file.Close()
+}
@@ -0,0 +1,467 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+// Database is an open database directory: its catalog and the write-ahead log and buffer pool shared by its tables.
+// One Database is shared by every session using the directory, and only one process may open it.
+type Database struct {
+	Dir     string
+	Catalog *catalog.Catalog
+	wal     *storage.WAL
+	pool    *storage.BufferPool
+	txns    *txnManager
+	locks   *lockManager
+	dirLock *os.File
+}
+
+// Session is one user of a Database and the transaction it has open, if any.
+// A session is used by one goroutine at a time; sessions of a Database may run concurrently.
+type Session struct {
+	db       *Database
+	txn      *storage.Txn // transaction the current statement writes into
+	snap     *Snapshot    // what the transaction sees; taken at BEGIN or when a statement starts
+	explicit bool         // txn was started by BEGIN and outlives single statements
+	failed   bool         // a statement of the explicit transaction failed
+	locked   []string     // tables the session holds locks on, released when the transaction ends
+}
+
+var (
+	ErrNoTransaction = errors.New("no transaction in progress")
+	ErrInTransaction = errors.New("a transaction is already in progress")
+	ErrTxnAborted    = errors.New("current transaction is aborted, commands ignored until end of transaction block")
+	ErrTxnRolledBack = errors.New("transaction was aborted by an earlier error and has been rolled back")
+	ErrLocked        = errors.New("database is in use by another process")
+)
+
+// Options tune an open database.
+type Options struct {
+	PoolSize int // bytes of pages the database caches; 0 means storage.DefaultPoolSize
+}
+
+// OpenDatabase opens the database stored in dir with the default options.
+func OpenDatabase(dir string) (*Database, error) {
+	return OpenDatabaseWith(dir, Options{})
+}
+
+/*
+OpenDatabaseWith opens the database stored in dir.
+Opening the write-ahead log replays it first, so pages from writes that were
+acknowledged before a crash are restored before anything reads the tables.
+Tables stored in an older file format are upgraded to the current one, and
+tables written before rows had MVCC version headers are given them.
+*/
+func OpenDatabaseWith(dir string, opts Options) (*Database, error) {
+	dirLock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// temporary files are only used while the database is open
+	if err := os.RemoveAll(filepath.Join(dir, tempDirName)); err != nil {
+		dirLock.Close()
+		return nil, err
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = storage.DefaultPoolSize
+	}
+	pool := storage.NewBufferPool(opts.PoolSize)
+	wal, err := storage.OpenWAL(dir, pool)
+	if err != nil {
+		dirLock.Close()
+		return nil, err
+	}
+	cat, err := catalog.NewCatalog(filepath.Join(dir, "catalog.db"))
+	if err != nil {
+		wal.Close()
+		dirLock.Close()
+		return nil, err
+	}
+	txns, err := openTxnManager(dir)
+	if err != nil {
+		wal.Close()
+		dirLock.Close()
+		return nil, err
+	}
+	d := &Database{Dir: dir, Catalog: cat, wal: wal, pool: pool, txns: txns, locks: newLockManager(), dirLock: dirLock}
+	if err := errors.Join(d.upgradeFiles(), d.versionTables()); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// versionTables rewrites the rows of tables from before MVCC with version headers, one table per transaction.
+func (d *Database) versionTables() error {
+	for _, schema := range d.Catalog.ListTables() {
+		if schema.Versioned {
+			continue
+		}
+		txn := d.wal.Begin()
+		t, err := OpenTable(d.Dir, schema, txn)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		err = errors.Join(t.addVersionHeaders(), t.Close())
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("adding version headers to %q: %w", schema.Name, err)
+		}
+		if err := txn.Commit(); err != nil {
+			return err
+		}
+		if err := d.Catalog.MarkVersioned(schema.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewSession starts a session on the database.
+func (d *Database) NewSession() *Session {
+	return &Session{db: d}
+}
+
+// Database returns the database the session uses.
+func (s *Session) Database() *Database {
+	return s.db
+}
+
+/*
+OpenTable opens a table of the session's database by name, inside the current
+transaction. The table is locked in the given mode first, waiting for
+conflicting transactions to end, and stays locked until this one does.
+Rows are read as the transaction's snapshot sees them. A statement outside
+BEGIN that writes takes a fresh snapshot once it has the lock, so it works on
+the rows the writer before it committed.
+*/
+func (s *Session) OpenTable(name string, mode LockMode) (*Table, error) {
+	if s.txn == nil {
+		return nil, ErrNoTransaction
+	}
+	if err := s.lock(name, mode); err != nil {
+		return nil, err
+	}
+	// looked up under the lock: the table may have been dropped while we waited
+	schema := s.db.Catalog.GetTable(name)
+	if schema == nil {
+		return nil, fmt.Errorf("table %q %w", name, catalog.ErrNoSuchTable)
+	}
+	writer := s.db.locks.held(s, name) >= LockExclusive
+	if !writer {
+		// Reading may still write pages (a missing free-space map is rebuilt), but
+		// committing them could overwrite the writer's: they go to a throwaway transaction.
+		scratch := s.db.wal.Begin()
+		t, err := OpenTable(s.db.Dir, schema, scratch)
+		if err != nil {
+			scratch.Rollback()
+			return nil, err
+		}
+		t.snap, t.scratch = s.snap, scratch
+		return t, nil
+	}
+	if !s.explicit && mode >= LockExclusive {
+		s.snap = s.db.txns.refresh(s.snap)
+	}
+	t, err := OpenTable(s.db.Dir, schema, s.txn)
+	if err != nil {
+		return nil, err
+	}
+	t.snap, t.horizon = s.snap, s.db.txns.horizon()
+	return t, nil
+}
+
+func (s *Session) lock(table string, mode LockMode) error {
+	if err := s.db.locks.acquire(s, table, mode); err != nil {
+		return err
+	}
+	for _, t := range s.locked {
+		if t == table {
+			return nil
+		}
+	}
+	s.locked = append(s.locked, table)
+	return nil
+}
+
+func (s *Session) unlock() {
+	s.db.locks.releaseAll(s, s.locked)
+	s.locked = nil
+}
+
+// InTransaction reports whether a transaction started with BEGIN is open.
+func (s *Session) InTransaction() bool {
+	return s.explicit
+}
+
+// Aborted reports whether a statement of the explicit transaction failed.
+func (s *Session) Aborted() bool {
+	return s.explicit && s.failed
+}
+
+// Begin starts an explicit transaction; statements run until Commit or Rollback all apply or none do.
+func (s *Session) Begin() error {
+	if s.explicit {
+		return ErrInTransaction
+	}
+	snap, err := s.db.txns.begin()
+	if err != nil {
+		return err
+	}
+	s.txn, s.snap = s.db.wal.Begin(), snap
+	s.explicit = true
+	s.failed = false
+	return nil
+}
+
+// end forgets the transaction's snapshot and releases its locks once its pages are in place or discarded.
+func (s *Session) end() {
+	s.db.txns.end(s.snap.xid)
+	s.snap = nil
+	s.unlock()
+}
+
+// Commit commits the explicit transaction. If one of its statements failed it is rolled back instead.
+func (s *Session) Commit() error {
+	if !s.explicit {
+		return ErrNoTransaction
+	}
+	txn, failed := s.txn, s.failed
+	s.txn, s.explicit, s.failed = nil, false, false
+	defer s.end()
+	if failed {
+		txn.Rollback()
+		return ErrTxnRolledBack
+	}
+	return txn.Commit()
+}
+
+// Rollback discards everything the explicit transaction wrote.
+func (s *Session) Rollback() error {
+	if !s.explicit {
+		return ErrNoTransaction
+	}
+	txn := s.txn
+	s.txn, s.explicit, s.failed = nil, false, false
+	defer s.end()
+	return txn.Rollback()
+}
+
+/*
+Run executes one statement's work in a transaction. Outside BEGIN the statement
+gets a transaction of its own that commits when fn succeeds and rolls back when
+it fails, so a statement never applies halfway. Inside BEGIN a failure marks
+the whole transaction as aborted: later statements are refused and COMMIT
+rolls it back.
+*/
+func (s *Session) Run(fn func() error) error {
+	finish, err := s.Start()
+	if err != nil {
+		return err
+	}
+	return finish(fn())
+}
+
+/*
+Start begins one statement's work as Run does, for a statement that outlives
+a function call, such as a query whose rows are read a few at a time. The
+statement ends when finish is called with its outcome; until then the
+session's transaction, snapshot and locks stay in place, and no other
+statement may start on the session.
+*/
+func (s *Session) Start() (finish func(err error) error, err error) {
+	if s.explicit {
+		if s.failed {
+			return nil, ErrTxnAborted
+		}
+		return func(err error) error {
+			if err != nil {
+				s.failed = true
+			}
+			return err
+		}, nil
+	}
+	snap, err := s.db.txns.begin()
+	if err != nil {
+		return nil, err
+	}
+	s.txn, s.snap = s.db.wal.Begin(), snap
+	return func(err error) error {
+		defer func() {
+			s.txn = nil
+			s.end()
+		}()
+		if err != nil {
+			s.txn.Rollback()
+			return err
+		}
+		return s.txn.Commit()
+	}, nil
+}
+
+// DropTable drops a table once no transaction is using it. It may not run inside a transaction.
+func (s *Session) DropTable(name string) error {
+	if s.explicit {
+		return ErrInTransaction
+	}
+	if err := s.lock(name, LockAccessExclusive); err != nil {
+		return err
+	}
+	defer s.unlock()
+	return s.db.DropTable(name)
+}
+
+// DropTable removes a table from the catalog and deletes its files.
+func (d *Database) DropTable(name string) error {
+	schema := d.Catalog.GetTable(name)
+	if err := d.Catalog.DropTable(name); err != nil {
+		return err
+	}
+	if err := d.removeFiles(tableFiles(d.Dir, schema)...); err != nil {
+		return fmt.Errorf("failed to delete table file: %w", err)
+	}
+	return nil
+}
+
+// CreateIndex adds a secondary index to a table once no transaction is using it. It may not run inside a transaction.
+func (s *Session) CreateIndex(table string, index catalog.IndexSchema) error {
+	if s.explicit {
+		return ErrInTransaction
+	}
+	if err := s.lock(table, LockAccessExclusive); err != nil {
+		return err
+	}
+	defer s.unlock()
+	return s.db.CreateIndex(table, index)
+}
+
+/*
+CreateIndex fills a new index file from the rows of a table, then adds the
+index to the catalog. Until the catalog has it nothing uses the file, so a
+crash in between only leaves a file that the next CREATE INDEX of that name
+replaces.
+*/
+func (d *Database) CreateIndex(table string, index catalog.IndexSchema) error {
+	if err := d.Catalog.CheckIndex(table, index); err != nil {
+		return err
+	}
+	schema := d.Catalog.GetTable(table)
+	path := IndexPath(d.Dir, table, index.Name)
+	if err := d.removeFiles(path); err != nil {
+		return err
+	}
+	txn := d.wal.Begin()
+	t, err := OpenTable(d.Dir, schema, txn)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	err = t.openSecondary(d.Dir, index, txn)
+	if err = errors.Join(err, t.Close()); err != nil {
+		txn.Rollback()
+		return errors.Join(err, d.removeFiles(path))
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	if err := d.Catalog.AddIndex(table, index); err != nil {
+		return errors.Join(err, d.removeFiles(path))
+	}
+	return nil
+}
+
+// DropIndex removes a secondary index once no transaction is using its table. It may not run inside a transaction.
+func (s *Session) DropIndex(name string) error {
+	if s.explicit {
+		return ErrInTransaction
+	}
+	schema, _ := s.db.Catalog.FindIndex(name)
+	if schema == nil {
+		return fmt.Errorf("index %q %w", name, catalog.ErrNoSuchIndex)
+	}
+	if err := s.lock(schema.Name, LockAccessExclusive); err != nil {
+		return err
+	}
+	defer s.unlock()
+	return s.db.DropIndex(name)
+}
+
+// DropIndex removes a secondary index from the catalog and deletes its file.
+func (d *Database) DropIndex(name string) error {
+	schema, _ := d.Catalog.FindIndex(name)
+	if schema == nil {
+		return fmt.Errorf("index %q %w", name, catalog.ErrNoSuchIndex)
+	}
+	if err := d.Catalog.DropIndex(name); err != nil {
+		return err
+	}
+	return d.removeFiles(IndexPath(d.Dir, schema.Name, name))
+}
+
+/*
+removeFiles deletes page files nothing uses any more. The log is checkpointed
+first so replaying it can never bring them back, and their pages leave the
+buffer pool so a file created later under the same name does not find them.
+*/
+func (d *Database) removeFiles(paths ...string) error {
+	if err := d.wal.Checkpoint(); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		d.pool.Discard(path)
+	}
+	return nil
+}
+
+// Close rolls back the session's unfinished transaction.
+func (s *Session) Close() error {
+	if s.explicit {
+		return s.Rollback()
+	}
+	return nil
+}
+
+// Close checkpoints the log and releases it and the directory. Sessions must be closed first.
+func (d *Database) Close() error {
+	return errors.Join(d.wal.Close(), d.txns.close(), d.dirLock.Close())
+}
+
+// tempDirName is the directory of a database that holds its temporary files.
+const tempDirName = "tmp"
+
+/*
+TempPager creates a scratch page file in the database's tmp directory (see
+storage.NewTempPager). Files a crash leaves there are removed the next time
+the database is opened.
+*/
+func (d *Database) TempPager() (*storage.Pager, error) {
+	dir := filepath.Join(d.Dir, tempDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return storage.NewTempPager(dir)
+}
+
+// openPager opens a page file, caching its pages in the database's pool and
+// keeping its writes in txn when one is given.
+func openPager(path string, txn *storage.Txn) (*storage.Pager, error) {
+	if txn == nil {
+		return storage.NewPager(path, nil)
+	}
+	pager, err := storage.NewPager(path, txn.Pool())
+	if err != nil {
+		return nil, err
+	}
+	pager.Join(txn)
+	return pager, nil
+}
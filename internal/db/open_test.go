@@ -0,0 +1,770 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+func countRows(t *testing.T, s *Session, table string) int {
+	t.Helper()
+	var n int
+	err := s.Run(func() error {
+		tbl, err := s.OpenTable(table, LockShared)
+		if err != nil {
+			return err
+		}
+		defer tbl.Close()
+		rows, err := tbl.Select(nil)
+		n = len(rows)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	return n
+}
+
+func TestDatabaseTransactions(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	if err := d.Catalog.AddTable("t", []string{"id", "name"}); err != nil {
+		t.Fatal(err)
+	}
+	s := d.NewSession()
+	insert := func(id int) error {
+		return s.Run(func() error {
+			tbl, err := s.OpenTable("t", LockExclusive)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			return tbl.Insert([]string{fmt.Sprint(id), "'x'"})
+		})
+	}
+
+	// Rolled back: enough rows to span several heap pages and index nodes
+	s.Begin()
+	for i := 0; i < 300; i++ {
+		if err := insert(i); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if n := countRows(t, s, "t"); n != 300 {
+		t.Fatalf("transaction sees %d rows, want 300", n)
+	}
+	s.Rollback()
+	if n := countRows(t, s, "t"); n != 0 {
+		t.Fatalf("after ROLLBACK: %d rows, want 0", n)
+	}
+
+	// A failed statement aborts the transaction and COMMIT rolls it back
+	s.Begin()
+	insert(1)
+	if err := insert(1); err == nil {
+		t.Fatalf("expected duplicate key error")
+	}
+	if err := insert(2); err != ErrTxnAborted {
+		t.Fatalf("statement in aborted transaction: got %v", err)
+	}
+	if err := s.Commit(); err != ErrTxnRolledBack {
+		t.Fatalf("COMMIT of aborted transaction: got %v", err)
+	}
+
+	// Committed rows survive reopening; an open transaction is dropped on Close
+	s.Begin()
+	insert(1)
+	insert(2)
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	s.Begin()
+	insert(3)
+	s.Close()
+	d.Close()
+
+	d, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer d.Close()
+	s = d.NewSession()
+	if n := countRows(t, s, "t"); n != 2 {
+		t.Fatalf("after reopen: %d rows, want 2", n)
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	d, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	defer d.Close()
+	d.Catalog.AddTable("a", []string{"id", "name"})
+	d.Catalog.AddTable("b", []string{"id", "name"})
+	if _, err := OpenDatabase(d.Dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second OpenDatabase: got %v, want ErrLocked", err)
+	}
+
+	// Writers insert into both tables in one transaction, half of them in the
+	// opposite order, so some deadlock and retry. Readers count meanwhile, from
+	// the heap of a and the index of b, and must never see a transaction's row
+	// in one table but not the other.
+	const writers, perWriter = 6, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := d.NewSession()
+			defer s.Close()
+			tables := []string{"a", "b"}
+			if w%2 == 1 {
+				tables = []string{"b", "a"}
+			}
+			for i := 0; i < perWriter; i++ {
+				id := fmt.Sprint(w*perWriter + i)
+				for {
+					s.Begin()
+					err := s.Run(func() error {
+						for _, name := range tables {
+							tbl, err := s.OpenTable(name, LockExclusive)
+							if err != nil {
+								return err
+							}
+							err = tbl.Insert([]string{id, "'x'"})
+							tbl.Close()
+							if err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+					if errors.Is(err, ErrDeadlock) {
+						s.Rollback()
+						continue
+					}
+					if err == nil {
+						err = s.Commit()
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+					break
+				}
+			}
+		}()
+	}
+	for r := 0; r < 3; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := d.NewSession()
+			for i := 0; i < 30; i++ {
+				var na, nb int
+				s.Begin()
+				err := s.Run(func() error {
+					for _, p := range []struct {
+						name  string
+						where par.Expr
+						n     *int
+					}{{"a", nil, &na}, {"b", &par.Condition{Column: "id", Operator: ">=", Value: "''"}, &nb}} {
+						tbl, err := s.OpenTable(p.name, LockShared)
+						if err != nil {
+							return err
+						}
+						rows, err := tbl.Select(p.where)
+						tbl.Close()
+						if err != nil {
+							return err
+						}
+						*p.n = len(rows)
+					}
+					return nil
+				})
+				s.Rollback()
+				if err != nil && !errors.Is(err, ErrDeadlock) {
+					errs <- err
+					return
+				}
+				if err == nil && na != nb {
+					errs <- fmt.Errorf("reader saw %d rows in a but %d in b", na, nb)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	s := d.NewSession()
+	if na, nb := countRows(t, s, "a"), countRows(t, s, "b"); na != writers*perWriter || nb != na {
+		t.Fatalf("rows: a=%d b=%d, want %d", na, nb, writers*perWriter)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	d, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	defer d.Close()
+	if err := d.Catalog.AddTable("t", []string{"id", "v"}); err != nil {
+		t.Fatal(err)
+	}
+	writer := d.NewSession()
+	write := func(fn func(tbl *Table) error) error {
+		return writer.Run(func() error {
+			tbl, err := writer.OpenTable("t", LockExclusive)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			return fn(tbl)
+		})
+	}
+	mustWrite := func(fn func(tbl *Table) error) {
+		t.Helper()
+		if err := write(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		mustWrite(func(tbl *Table) error { return tbl.Insert([]string{fmt.Sprint(i), "'old'"}) })
+	}
+
+	// The reader's snapshot is taken at BEGIN and its shared lock does not hold up the writer
+	reader := d.NewSession()
+	reader.Begin()
+	if n := countRows(t, reader, "t"); n != 3 {
+		t.Fatalf("reader sees %d rows, want 3", n)
+	}
+	mustWrite(func(tbl *Table) error { return tbl.Insert([]string{"4", "'new'"}) })
+	mustWrite(func(tbl *Table) error {
+		_, err := tbl.Delete(&par.Condition{Column: "id", Operator: "=", Value: "1"})
+		return err
+	})
+	mustWrite(func(tbl *Table) error {
+		_, err := tbl.Update([]par.Assignment{{Column: "v", Value: "'new'"}}, nil)
+		return err
+	})
+	mustWrite(func(tbl *Table) error { return tbl.Insert([]string{"1", "'new'"}) })
+
+	err = reader.Run(func() error {
+		tbl, err := reader.OpenTable("t", LockShared)
+		if err != nil {
+			return err
+		}
+		defer tbl.Close()
+		rows, err := tbl.Select(nil)
+		if err != nil {
+			return err
+		}
+		got := fmt.Sprint(rows)
+		for _, r := range []string{"1", "2", "3"} {
+			row, ok, err := tbl.Lookup(r)
+			if err != nil || !ok || row[1].String() != "old" {
+				return fmt.Errorf("lookup %s = %v, %v, %v", r, row, ok, err)
+			}
+		}
+		if _, ok, _ := tbl.Lookup("4"); ok || len(rows) != 3 || strings.Contains(got, "new") {
+			return fmt.Errorf("reader sees changes made after its snapshot: %s", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changing a row someone changed after the snapshot cannot succeed
+	err = reader.Run(func() error {
+		tbl, err := reader.OpenTable("t", LockExclusive)
+		if err != nil {
+			return err
+		}
+		defer tbl.Close()
+		_, err = tbl.Delete(&par.Condition{Column: "id", Operator: "=", Value: "2"})
+		return err
+	})
+	if !errors.Is(err, ErrSerialization) {
+		t.Fatalf("delete of a concurrently updated row: %v, want ErrSerialization", err)
+	}
+	reader.Rollback()
+	if n := countRows(t, reader, "t"); n != 4 {
+		t.Fatalf("after the reader's transaction: %d rows, want 4", n)
+	}
+
+	// With nobody looking, old versions are reclaimed and the heap stops growing
+	for i := 0; i < 100; i++ {
+		mustWrite(func(tbl *Table) error {
+			_, err := tbl.Update([]par.Assignment{{Column: "v", Value: fmt.Sprintf("'v%d'", i)}}, nil)
+			return err
+		})
+	}
+	info, err := os.Stat(TablePath(d.Dir, "t"))
+	if err != nil || info.Size() > 2*storage.PageSize { // the file header and one page
+		t.Fatalf("heap is %d bytes after repeated updates, %v", info.Size(), err)
+	}
+}
+
+func TestVersionLegacyTables(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{Name: "old", Columns: []string{"id", "name"}, Types: []catalog.ColumnType{catalog.TypeInteger, catalog.TypeText}, PrimaryKey: "id"}
+	h, err := OpenHeap(TablePath(dir, "old"), FSMPath(dir, "old"), ToastPath(dir, "old"), schema, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Format 1 tuples, packed as tightly as they were written before version headers
+	pageNum, _ := h.pager.AllocatePage()
+	page := make(slottedPage, storage.UsablePageSize)
+	for i := 0; i < 200; i++ {
+		tuple := SerializeRow(schema, Row{{Type: catalog.TypeInteger, Int: int64(i)}, {Type: catalog.TypeText, Str: fmt.Sprintf("row-%d", i)}})
+		legacy := append([]byte{tupleFormatBinary}, tuple[1+versionHeaderSize:]...)
+		if !page.fits(len(legacy)) {
+			pageNum, _ = h.pager.AllocatePage()
+			page = make(slottedPage, storage.UsablePageSize)
+		}
+		page.insert(legacy)
+		if err := h.pager.FlushPage(pageNum, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Close()
+	if err := os.WriteFile(filepath.Join(dir, "catalog.db"), []byte(`{"name":"old","columns":["id","name"],"types":["INTEGER","TEXT"],"primary_key":"id"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	defer d.Close()
+	if !d.Catalog.GetTable("old").Versioned {
+		t.Errorf("table not marked versioned")
+	}
+	s := d.NewSession()
+	err = s.Run(func() error {
+		tbl, err := s.OpenTable("old", LockExclusive)
+		if err != nil {
+			return err
+		}
+		defer tbl.Close()
+		for _, id := range []string{"0", "150", "199"} {
+			if row, ok, err := tbl.Lookup(id); err != nil || !ok || row[1].String() != "row-"+id {
+				return fmt.Errorf("lookup %s = %v, %v, %v", id, row, ok, err)
+			}
+		}
+		_, err = tbl.Delete(&par.Condition{Column: "id", Operator: "<", Value: "100"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, s, "old"); n != 100 {
+		t.Fatalf("%d rows after delete, want 100", n)
+	}
+}
+
+// A data directory from before file headers is upgraded when it is opened: rows survive, deleted versions do not.
+func TestUpgradeFormat1(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{Name: "old", Columns: []string{"id", "name"}, Types: []catalog.ColumnType{catalog.TypeInteger, catalog.TypeText}, PrimaryKey: "id", Versioned: true}
+	// Format 1 pages, filled to the last byte of PageSize
+	var file []byte
+	page := slottedPage(make([]byte, storage.PageSize))
+	for i := 0; i < 300; i++ {
+		v := version{xmin: 1}
+		if i%3 == 0 {
+			v.xmax = 2 // deleted by a committed transaction
+		}
+		tuple := serializeVersion(schema, v, Row{{Type: catalog.TypeInteger, Int: int64(i)}, {Type: catalog.TypeText, Str: fmt.Sprintf("row-%d", i)}})
+		if !page.fits(len(tuple)) {
+			file = append(file, page...)
+			page = slottedPage(make([]byte, storage.PageSize))
+		}
+		page.insert(tuple)
+	}
+	file = append(file, page...)
+	files := map[string][]byte{
+		TablePath(dir, "old"):            file,
+		FSMPath(dir, "old"):              bytes.Repeat([]byte{0xff}, storage.PageSize),
+		PKIndexPath(dir, "old"):          bytes.Repeat([]byte{0x01}, storage.PageSize),
+		filepath.Join(dir, "catalog.db"): []byte(`{"name":"old","columns":["id","name"],"types":["INTEGER","TEXT"],"primary_key":"id","versioned":true}` + "\n"),
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for open := 0; open < 2; open++ {
+		d, err := OpenDatabase(dir)
+		if err != nil {
+			t.Fatalf("OpenDatabase: %v", err)
+		}
+		if h, err := storage.ReadFileHeader(TablePath(dir, "old")); err != nil || h.Version != storage.FormatVersion {
+			t.Errorf("heap header after upgrade: %+v, %v", h, err)
+		}
+		if h := d.Catalog.Header(); h.Format != 2 || h.PageSize != storage.PageSize {
+			t.Errorf("catalog header after upgrade: %+v", h)
+		}
+		s := d.NewSession()
+		if n := countRows(t, s, "old"); n != 200 {
+			t.Errorf("%d rows after upgrade, want 200", n)
+		}
+		err = s.Run(func() error {
+			tbl, err := s.OpenTable("old", LockShared)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			for _, id := range []string{"1", "155", "299"} {
+				if row, ok, err := tbl.Lookup(id); err != nil || !ok || row[1].String() != "row-"+id {
+					return fmt.Errorf("lookup %s = %v, %v, %v", id, row, ok, err)
+				}
+			}
+			if _, ok, err := tbl.Lookup("3"); ok || err != nil {
+				return fmt.Errorf("deleted row 3 found after upgrade: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Close()
+	}
+	if _, err := os.Stat(TablePath(dir, "old") + ".upgrade"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("upgrade left its scratch file behind: %v", err)
+	}
+}
+
+// A directory written before slotted pages: JSON lines for the catalog, and
+// heap pages of CSV records behind a uint16 length, holding the literals as typed.
+func TestUpgradeCSVPages(t *testing.T) {
+	dir := t.TempDir()
+	var file []byte
+	page := make([]byte, 0, storage.PageSize)
+	for i := 0; i < 300; i++ {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{fmt.Sprint(i), fmt.Sprintf("'user %d, \"quoted\"'", i)})
+		w.Flush()
+		if len(page)+2+buf.Len() > storage.PageSize {
+			file = append(file, page[:storage.PageSize]...)
+			page = make([]byte, 0, storage.PageSize)
+		}
+		page = binary.LittleEndian.AppendUint16(page, uint16(buf.Len()))
+		page = append(page, buf.Bytes()...)
+	}
+	file = append(file, page[:storage.PageSize]...)
+	catalogLine := []byte(`{"name":"users","columns":["id","name"],"primary_key":"id"}` + "\n")
+	write := func(heap []byte) {
+		t.Helper()
+		if err := os.WriteFile(TablePath(dir, "users"), heap, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "catalog.db"), catalogLine, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leftovers := func() {
+		t.Helper()
+		if matches, _ := filepath.Glob(TablePath(dir, "users") + ".upgrade*"); len(matches) != 0 {
+			t.Errorf("upgrade left %v behind", matches)
+		}
+	}
+
+	// A page that is neither layout fails the open and leaves the old heap as it was
+	bad := slices.Clone(file)
+	bad[storage.PageSize+10] = 0xff
+	bad[storage.PageSize-1] = 0xff
+	write(bad)
+	if d, err := OpenDatabase(dir); err == nil {
+		d.Close()
+		t.Fatalf("OpenDatabase of a damaged heap: no error")
+	}
+	if data, _ := os.ReadFile(TablePath(dir, "users")); !bytes.Equal(data, bad) {
+		t.Errorf("failed upgrade changed the heap")
+	}
+	leftovers()
+
+	write(file)
+	d, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	defer d.Close()
+	leftovers()
+	s := d.NewSession()
+	if n := countRows(t, s, "users"); n != 300 {
+		t.Errorf("%d rows after upgrade, want 300", n)
+	}
+	err = s.Run(func() error {
+		tbl, err := s.OpenTable("users", LockShared)
+		if err != nil {
+			return err
+		}
+		defer tbl.Close()
+		for _, id := range []string{"0", "150", "299"} {
+			if row, ok, err := tbl.Lookup(id); err != nil || !ok || row[1].String() != "user "+id+`, "quoted"` {
+				return fmt.Errorf("lookup %s = %v, %v, %v", id, row, ok, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A damaged page fails the statements that read it with an error; the session and the other tables carry on.
+func TestCorruptPageError(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	s := d.NewSession()
+	for _, table := range []string{"bad", "good"} {
+		if err := d.Catalog.AddTable(table, []string{"id", "name"}); err != nil {
+			t.Fatal(err)
+		}
+		err := s.Run(func() error {
+			tbl, err := s.OpenTable(table, LockExclusive)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			return tbl.Insert([]string{"1", "'x'"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+
+	path := TablePath(dir, "bad")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = OpenDatabase(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer d.Close()
+	s = d.NewSession()
+	err = s.Run(func() error {
+		tbl, err := s.OpenTable("bad", LockShared)
+		if err != nil {
+			return err
+		}
+		defer tbl.Close()
+		_, err = tbl.Select(nil)
+		return err
+	})
+	if !errors.Is(err, storage.ErrCorruptPage) || !strings.Contains(err.Error(), path) {
+		t.Fatalf("select from a damaged table: got %v, want ErrCorruptPage naming %s", err, path)
+	}
+	if n := countRows(t, s, "good"); n != 1 {
+		t.Fatalf("other table after the failure: %d rows, want 1", n)
+	}
+}
+
+func TestSecondaryIndexes(t *testing.T) {
+	d, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDatabase: %v", err)
+	}
+	defer d.Close()
+	types := []catalog.ColumnType{catalog.TypeInteger, catalog.TypeText, catalog.TypeInteger}
+	if err := d.Catalog.AddTypedTable("users", []string{"id", "email", "age"}, types); err != nil {
+		t.Fatal(err)
+	}
+	s := d.NewSession()
+	use := func(s *Session, mode LockMode, fn func(tbl *Table) error) error {
+		return s.Run(func() error {
+			tbl, err := s.OpenTable("users", mode)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			return fn(tbl)
+		})
+	}
+	for i := 0; i < 300; i++ {
+		err := use(s, LockExclusive, func(tbl *Table) error {
+			return tbl.Insert([]string{fmt.Sprint(i), fmt.Sprintf("'user%d@x'", i), fmt.Sprint(i % 50)})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Indexes are filled from the rows already there
+	if err := s.CreateIndex("users", catalog.IndexSchema{Name: "by_age", Columns: []string{"age", "id"}}); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := s.CreateIndex("users", catalog.IndexSchema{Name: "by_email", Columns: []string{"email"}, Unique: true}); err != nil {
+		t.Fatalf("CreateIndex unique: %v", err)
+	}
+
+	// Every comparison reads the index and finds what a scan of the heap finds
+	check := func(s *Session, where par.Expr) []Row {
+		t.Helper()
+		var rows []Row
+		err := use(s, LockShared, func(tbl *Table) error {
+			if p, err := tbl.BestPath(where); err != nil || p.Index == "" || p.Index == catalog.PrimaryKeyIndex {
+				return fmt.Errorf("no index used for %s (%v)", par.FormatExpr(where), err)
+			}
+			var want []string
+			err := tbl.scanPath(AccessPath{}, where, func(_ RID, row Row) error {
+				want = append(want, fmt.Sprint(row))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if rows, err = tbl.Select(where); err != nil {
+				return err
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, fmt.Sprint(row))
+			}
+			slices.Sort(got)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				return fmt.Errorf("%s: index returned %d rows, heap has %d", par.FormatExpr(where), len(got), len(want))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	for _, op := range []string{"=", "<", ">", "<=", ">="} {
+		check(s, &par.Condition{Column: "age", Operator: op, Value: "17"})
+		check(s, &par.Condition{Column: "email", Operator: op, Value: "'user17@x'"})
+	}
+	check(s, &par.BinaryExpr{
+		Left:     &par.Condition{Column: "age", Operator: ">", Value: "10"},
+		Operator: "AND",
+		Right:    &par.Condition{Column: "age", Operator: "<=", Value: "12"},
+	})
+
+	// A cursor reads an index a batch at a time; every path finds all the rows across batches
+	err = use(s, LockShared, func(tbl *Table) error {
+		where := &par.Condition{Column: "age", Operator: ">=", Value: "0"}
+		paths, err := tbl.AccessPaths(where)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			c := tbl.Scan(p, where)
+			n := 0
+			for c.Next() {
+				n++
+			}
+			if err := c.Err(); err != nil {
+				return err
+			}
+			if n != 300 {
+				return fmt.Errorf("scan using %q read %d rows, want 300", p.Index, n)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unique values are enforced; NULLs never clash
+	err = use(s, LockExclusive, func(tbl *Table) error { return tbl.Insert([]string{"1000", "'user5@x'", "1"}) })
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("insert of a taken email: %v, want ErrDuplicateValue", err)
+	}
+	for _, id := range []string{"1001", "1002"} {
+		if err := use(s, LockExclusive, func(tbl *Table) error { return tbl.Insert([]string{id, "NULL", "1"}) }); err != nil {
+			t.Fatalf("insert with a NULL email: %v", err)
+		}
+	}
+	err = use(s, LockExclusive, func(tbl *Table) error {
+		_, err := tbl.Update([]par.Assignment{{Column: "email", Value: "'user6@x'"}}, &par.Condition{Column: "id", Operator: "=", Value: "7"})
+		return err
+	})
+	if !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("update to a taken email: %v, want ErrDuplicateValue", err)
+	}
+
+	// Updates and deletes keep the indexes in step; a snapshot from before them still finds the old rows
+	reader := d.NewSession()
+	reader.Begin()
+	check(reader, &par.Condition{Column: "age", Operator: "=", Value: "3"})
+	err = use(s, LockExclusive, func(tbl *Table) error {
+		if _, err := tbl.Update([]par.Assignment{{Column: "age", Value: "99"}}, &par.Condition{Column: "age", Operator: "=", Value: "3"}); err != nil {
+			return err
+		}
+		_, err := tbl.Delete(&par.Condition{Column: "email", Operator: "=", Value: "'user4@x'"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := check(s, &par.Condition{Column: "age", Operator: "=", Value: "3"}); len(rows) != 0 {
+		t.Fatalf("%d rows still found under the old value", len(rows))
+	}
+	if rows := check(s, &par.Condition{Column: "age", Operator: "=", Value: "99"}); len(rows) != 6 {
+		t.Fatalf("%d rows found under the new value, want 6", len(rows))
+	}
+	if rows := check(reader, &par.Condition{Column: "age", Operator: "=", Value: "3"}); len(rows) != 6 {
+		t.Fatalf("snapshot from before the update finds %d rows, want 6", len(rows))
+	}
+	if rows := check(reader, &par.Condition{Column: "email", Operator: "=", Value: "'user4@x'"}); len(rows) != 1 {
+		t.Fatalf("snapshot from before the delete finds %d rows, want 1", len(rows))
+	}
+	reader.Rollback()
+
+	// A unique index can't be created over duplicates, and leaves nothing behind
+	if err := s.CreateIndex("users", catalog.IndexSchema{Name: "by_age_u", Columns: []string{"age"}, Unique: true}); !errors.Is(err, ErrDuplicateValue) {
+		t.Fatalf("unique index over duplicate values: %v, want ErrDuplicateValue", err)
+	}
+	if _, err := os.Stat(IndexPath(d.Dir, "users", "by_age_u")); !os.IsNotExist(err) {
+		t.Fatalf("failed CREATE INDEX left its file: %v", err)
+	}
+	if err := s.DropIndex("by_age"); err != nil {
+		t.Fatalf("DropIndex: %v", err)
+	}
+	if _, err := os.Stat(IndexPath(d.Dir, "users", "by_age")); !os.IsNotExist(err) {
+		t.Fatalf("dropped index file still there: %v", err)
+	}
+	if err := s.DropTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(IndexPath(d.Dir, "users", "by_email")); !os.IsNotExist(err) {
+		t.Fatalf("index file of a dropped table still there: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+Slotted page layout used by table heap pages:
+
+	[slot count uint16][free end uint16][slot 0][slot 1]...   free space   ...[tuple 1][tuple 0]
+
+Each slot is [offset uint16][length uint16] pointing at a tuple in the data area,
+which grows backwards from the end of the page. A slot with offset 0 is unused
+(its row was deleted) and can be handed out again. Tuples are kept packed against
+the end of the page, so all free space sits between the slot directory and the data.
+*/
+const (
+	pageHeaderSize = 4
+	slotSize       = 4
+	// MaxTupleSize is the largest tuple that fits in an empty page.
+	MaxTupleSize = storage.UsablePageSize - pageHeaderSize - slotSize
+)
+
+type slottedPage []byte
+
+func (p slottedPage) slotCount() int {
+	return int(binary.LittleEndian.Uint16(p[0:2]))
+}
+
+// freeEnd is where the tuple area starts; a zeroed (fresh) page stores 0 for an empty page.
+func (p slottedPage) freeEnd() int {
+	end := int(binary.LittleEndian.Uint16(p[2:4]))
+	if end == 0 {
+		return len(p)
+	}
+	return end
+}
+
+func (p slottedPage) setHeader(count, freeEnd int) {
+	binary.LittleEndian.PutUint16(p[0:2], uint16(count))
+	binary.LittleEndian.PutUint16(p[2:4], uint16(freeEnd))
+}
+
+func (p slottedPage) slot(i int) (offset, length int) {
+	at := pageHeaderSize + i*slotSize
+	return int(binary.LittleEndian.Uint16(p[at:])), int(binary.LittleEndian.Uint16(p[at+2:]))
+}
+
+func (p slottedPage) setSlot(i, offset, length int) {
+	at := pageHeaderSize + i*slotSize
+	binary.LittleEndian.PutUint16(p[at:], uint16(offset))
+	binary.LittleEndian.PutUint16(p[at+2:], uint16(length))
+}
+
+// freeSpace is the number of bytes available for a new tuple and its slot.
+func (p slottedPage) freeSpace() int {
+	return p.freeEnd() - pageHeaderSize - p.slotCount()*slotSize
+}
+
+// fits reports whether a tuple of n bytes can be inserted, reusing a free slot if there is one.
+func (p slottedPage) fits(n int) bool {
+	need := n
+	if p.freeSlot() == -1 {
+		need += slotSize
+	}
+	return need <= p.freeSpace()
+}
+
+// freeSlot returns an unused slot number, or -1 if every slot is taken.
+func (p slottedPage) freeSlot() int {
+	for i := 0; i < p.slotCount(); i++ {
+		if off, _ := p.slot(i); off == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert copies tuple into the page and returns its slot number. The caller checks fits first.
+func (p slottedPage) insert(tuple []byte) int {
+	slot := p.freeSlot()
+	if slot == -1 {
+		slot = p.slotCount()
+	}
+	p.put(slot, tuple)
+	return slot
+}
+
+// fitsAt reports whether a tuple of n bytes can be stored in the (unused) slot number given.
+func (p slottedPage) fitsAt(slot, n int) bool {
+	need := n
+	if extra := slot + 1 - p.slotCount(); extra > 0 {
+		need += extra * slotSize
+	}
+	return need <= p.freeSpace()
+}
+
+// put stores tuple in an unused slot, growing the slot directory if slot is past its end.
+func (p slottedPage) put(slot int, tuple []byte) {
+	count, end := p.slotCount(), p.freeEnd()
+	if slot >= count {
+		count = slot + 1
+	}
+	end -= len(tuple)
+	copy(p[end:], tuple)
+	p.setSlot(slot, end, len(tuple))
+	p.setHeader(count, end)
+}
+
+// tuple returns the bytes stored in slot i, or nil if the slot is unused.
+// A slot pointing outside the page is an error, so a damaged page fails the statement reading it.
+func (p slottedPage) tuple(i int) ([]byte, error) {
+	if i >= p.slotCount() {
+		return nil, nil
+	}
+	if pageHeaderSize+(i+1)*slotSize > len(p) {
+		return nil, fmt.Errorf("%w: slot %d is past the end of the page", storage.ErrCorruptPage, i)
+	}
+	off, length := p.slot(i)
+	if off == 0 {
+		return nil, nil
+	}
+	if off < pageHeaderSize || off+length > len(p) {
+		return nil, fmt.Errorf("%w: slot %d points outside the page", storage.ErrCorruptPage, i)
+	}
+	return p[off : off+length], nil
+}
+
+// check verifies the header and every slot of a page before it is changed.
+func (p slottedPage) check() error {
+	count, end := p.slotCount(), p.freeEnd()
+	if pageHeaderSize+count*slotSize > end || end > len(p) {
+		return fmt.Errorf("%w: %d slots and free space ending at %d do not fit the page", storage.ErrCorruptPage, count, end)
+	}
+	for i := 0; i < count; i++ {
+		if off, length := p.slot(i); off != 0 && (off < end || off+length > len(p)) {
+			return fmt.Errorf("%w: slot %d points outside the tuple area", storage.ErrCorruptPage, i)
+		}
+	}
+	return nil
+}
+
+// remove frees slot i and slides the tuples below it up so free space stays contiguous.
+func (p slottedPage) remove(i int) {
+	off, length := p.slot(i)
+	if off == 0 {
+		return
+	}
+	end := p.freeEnd()
+	copy(p[end+length:off+length], p[end:off])
+	clear(p[end : end+length])
+	for s := 0; s < p.slotCount(); s++ {
+		if o, l := p.slot(s); o != 0 && o < off {
+			p.setSlot(s, o+length, l)
+		}
+	}
+	p.setSlot(i, 0, 0)
+	// Trailing unused slots can be given back to the free space entirely
+	count := p.slotCount()
+	for count > 0 {
+		if o, _ := p.slot(count - 1); o != 0 {
+			break
+		}
+		count--
+	}
+	p.setHeader(count, end+length)
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+func TestSlottedPage(t *testing.T) {
+	page := make(slottedPage, storage.UsablePageSize)
+	tuple := func(i int) []byte {
+		t.Helper()
+		b, err := page.tuple(i)
+		if err != nil {
+			t.Fatalf("tuple(%d): %v", i, err)
+		}
+		return b
+	}
+	if free := page.freeSpace(); free != storage.UsablePageSize-pageHeaderSize {
+		t.Fatalf("empty page has %d bytes free", free)
+	}
+	if !page.fits(MaxTupleSize) || page.fits(MaxTupleSize+1) {
+		t.Errorf("an empty page should hold exactly MaxTupleSize (%d) bytes", MaxTupleSize)
+	}
+
+	tuples := [][]byte{bytes.Repeat([]byte("a"), 100), bytes.Repeat([]byte("b"), 200), bytes.Repeat([]byte("c"), 300)}
+	for i, tuple := range tuples {
+		if slot := page.insert(tuple); slot != i {
+			t.Fatalf("insert %d went to slot %d", i, slot)
+		}
+	}
+	used := pageHeaderSize + 3*slotSize + 600
+	if free := page.freeSpace(); free != storage.UsablePageSize-used {
+		t.Errorf("after three inserts %d bytes free, want %d", free, storage.UsablePageSize-used)
+	}
+
+	// Removing a tuple in the middle slides the ones below it up and keeps its slot
+	page.remove(1)
+	if tuple(1) != nil || page.slotCount() != 3 {
+		t.Errorf("slot 1 still in use after remove, %d slots", page.slotCount())
+	}
+	if !bytes.Equal(tuple(0), tuples[0]) || !bytes.Equal(tuple(2), tuples[2]) {
+		t.Errorf("tuples around the removed one were damaged")
+	}
+	if end := page.freeEnd(); end != storage.UsablePageSize-400 {
+		t.Errorf("tuple area starts at %d after compaction, want %d", end, storage.UsablePageSize-400)
+	}
+	if free := page.freeSpace(); free != storage.UsablePageSize-used+200 {
+		t.Errorf("remove gave back %d bytes, want 200", free-(storage.UsablePageSize-used))
+	}
+
+	// The freed slot is handed out again before the directory grows
+	d := bytes.Repeat([]byte("d"), 50)
+	if slot := page.insert(d); slot != 1 {
+		t.Errorf("insert after remove went to slot %d, want 1", slot)
+	}
+	if !bytes.Equal(tuple(1), d) || !bytes.Equal(tuple(2), tuples[2]) {
+		t.Errorf("reused slot reads back wrong")
+	}
+
+	// Trailing unused slots are dropped from the directory
+	page.remove(1)
+	page.remove(2)
+	if page.slotCount() != 1 || !bytes.Equal(tuple(0), tuples[0]) {
+		t.Errorf("%d slots left after removing the last two, want 1", page.slotCount())
+	}
+	page.remove(0)
+	if page.slotCount() != 0 || page.freeSpace() != storage.UsablePageSize-pageHeaderSize {
+		t.Errorf("emptied page: %d slots, %d bytes free", page.slotCount(), page.freeSpace())
+	}
+
+	// A page filled to the last byte
+	for page.fits(1000) {
+		page.insert(make([]byte, 1000))
+	}
+	last := page.freeSpace() - slotSize
+	page.insert(make([]byte, last))
+	if page.freeSpace() != 0 || page.fits(0) {
+		t.Errorf("full page still has %d bytes free", page.freeSpace())
+	}
+}
+
+func TestCorruptPage(t *testing.T) {
+	page := make(slottedPage, storage.UsablePageSize)
+	page.insert([]byte("abc"))
+	page.insert([]byte("def"))
+	if err := page.check(); err != nil {
+		t.Fatalf("intact page: %v", err)
+	}
+
+	// A slot reaching past the page end, and one past a slot directory that overruns it
+	page.setSlot(1, storage.UsablePageSize-2, 3)
+	if _, err := page.tuple(1); !errors.Is(err, storage.ErrCorruptPage) {
+		t.Errorf("tuple of a slot past the page end: %v", err)
+	}
+	if err := page.check(); !errors.Is(err, storage.ErrCorruptPage) {
+		t.Errorf("check of a slot past the page end: %v", err)
+	}
+	page.setHeader(storage.UsablePageSize, page.freeEnd())
+	if _, err := page.tuple(storage.UsablePageSize - 1); !errors.Is(err, storage.ErrCorruptPage) {
+		t.Errorf("tuple past the slot directory: %v", err)
+	}
+	if err := page.check(); !errors.Is(err, storage.ErrCorruptPage) {
+		t.Errorf("check of an oversized slot directory: %v", err)
+	}
+}
+
+func TestFreeSpaceMap(t *testing.T) {
+	pager, err := storage.NewPager(filepath.Join(t.TempDir(), "t.fsm"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.Close()
+	fsm := newFreeSpaceMap(pager)
+
+	find := func(need, heapPages int) (uint32, bool) {
+		t.Helper()
+		pageNum, ok, err := fsm.Find(need, heapPages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pageNum, ok
+	}
+	if _, ok := find(1, 10); ok {
+		t.Errorf("empty map found a page")
+	}
+
+	// Free space is stored rounded down to fsmGranularity, requests are rounded up
+	set := map[uint32]int{0: fsmGranularity - 1, 1: fsmGranularity, 2: 2*fsmGranularity - 1, 3: 2 * fsmGranularity}
+	for pageNum, free := range set {
+		if err := fsm.Set(pageNum, free); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		need      int
+		heapPages int
+		want      uint32
+		found     bool
+	}{
+		{0, 4, 0, true},
+		{1, 4, 1, true},
+		{fsmGranularity, 4, 1, true},
+		{fsmGranularity + 1, 4, 3, true},
+		{2 * fsmGranularity, 4, 3, true},
+		{2*fsmGranularity + 1, 4, 0, false},
+		{fsmGranularity + 1, 3, 0, false}, // pages past heapPages are never returned
+	}
+	for _, c := range cases {
+		if pageNum, ok := find(c.need, c.heapPages); ok != c.found || pageNum != c.want {
+			t.Errorf("Find(%d, %d) = %d, %v, want %d, %v", c.need, c.heapPages, pageNum, ok, c.want, c.found)
+		}
+	}
+
+	// A page's entry goes down as well as up
+	if err := fsm.Set(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if pageNum, _ := find(1, 4); pageNum != 2 {
+		t.Errorf("Find after page 1 filled up = %d, want 2", pageNum)
+	}
+
+	// Heap pages past the first map page are tracked on the next one
+	far := uint32(storage.UsablePageSize + 5)
+	if err := fsm.Set(far, storage.UsablePageSize); err != nil {
+		t.Fatal(err)
+	}
+	if pager.PageCount() != 2 {
+		t.Errorf("map has %d pages, want 2", pager.PageCount())
+	}
+	if pageNum, ok := find(MaxTupleSize, int(far)+1); !ok || pageNum != far {
+		t.Errorf("Find on the second map page = %d, %v, want %d", pageNum, ok, far)
+	}
+}
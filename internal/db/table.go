@@ -0,0 +1,682 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/btree"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+Table bundles the files backing one table: the row heap, the primary-key
+index and the secondary indexes (see index.go).
+
+A table opened by a session reads and writes row versions as its snapshot
+sees them. The index entry of a key points at the newest version stored
+under it, and every version points at the one it replaced (see tuple.go), so
+a reader whose snapshot predates the newest version follows the chain back
+to the one it sees. Without a snapshot rows are changed in place, which is
+only safe while nobody else uses the table.
+*/
+type Table struct {
+	Schema  *catalog.TableSchema
+	heap    *Heap
+	pk      index
+	pkCol   int // position of the primary key in Schema.Columns
+	indexes []*secondaryIndex
+	snap    *Snapshot
+	horizon XID          // versions deleted below it are removed as they are found (0 = never)
+	scratch *storage.Txn // discarded at Close: takes the writes of a reader that must not commit any
+	stats   *TableStats  // from the first Stats call
+}
+
+// TablePath returns the heap file of a table inside a database directory.
+func TablePath(dbDir, table string) string {
+	return filepath.Join(dbDir, table+".db")
+}
+
+// FSMPath returns the free-space map file of a table.
+func FSMPath(dbDir, table string) string {
+	return filepath.Join(dbDir, table+".fsm")
+}
+
+// ToastPath returns the file holding the table's rows that are too large for a heap page.
+func ToastPath(dbDir, table string) string {
+	return filepath.Join(dbDir, table+".toast")
+}
+
+// PKIndexPath returns the primary-key B+Tree file of a table.
+func PKIndexPath(dbDir, table string) string {
+	return filepath.Join(dbDir, table+"."+catalog.PrimaryKeyIndex+".idx")
+}
+
+// OpenTable opens (or creates) the heap and indexes for schema inside
+// txn; with a nil txn pages are written straight to disk.
+// An index whose file is missing is rebuilt from the heap on first open.
+func OpenTable(dbDir string, schema *catalog.TableSchema, txn *storage.Txn) (*Table, error) {
+	t := &Table{Schema: schema, pkCol: -1}
+	for i, col := range schema.Columns {
+		if col == schema.PrimaryKey {
+			t.pkCol = i
+			break
+		}
+	}
+	if t.pkCol == -1 {
+		return nil, fmt.Errorf("table %q: primary key %q is not a column", schema.Name, schema.PrimaryKey)
+	}
+	heap, err := OpenHeap(TablePath(dbDir, schema.Name), FSMPath(dbDir, schema.Name), ToastPath(dbDir, schema.Name), schema, txn)
+	if err != nil {
+		return nil, err
+	}
+	t.heap = heap
+
+	pk, fresh, err := openIndex(PKIndexPath(dbDir, schema.Name), txn)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.pk = pk
+	if fresh && t.heap.pager.PageCount() > 0 {
+		if err := t.rebuildPK(); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	if err := t.openIndexes(dbDir, txn); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// rebuildPK fills an empty primary-key index from the current rows in the heap.
+func (t *Table) rebuildPK() error {
+	err := scanVisible(t.heap, nil, func(rid RID, row Row) error {
+		return t.pk.Insert(EncodeKey(row[t.pkCol]), rid.Pack())
+	})
+	if err != nil {
+		return fmt.Errorf("rebuilding primary key index for %q: %w", t.Schema.Name, err)
+	}
+	return nil
+}
+
+// Close releases the table's files.
+func (t *Table) Close() error {
+	var errs []error
+	if t.heap != nil {
+		errs = append(errs, t.heap.Close())
+	}
+	errs = append(errs, t.pk.close())
+	for _, ix := range t.indexes {
+		errs = append(errs, ix.close())
+	}
+	if t.scratch != nil {
+		errs = append(errs, t.scratch.Rollback())
+	}
+	return errors.Join(errs...)
+}
+
+/*
+addVersionHeaders rewrites every row of a table written before MVCC with a
+version header that every snapshot sees, moving the index entries of rows
+that no longer fit in their page.
+*/
+func (t *Table) addVersionHeaders() error {
+	var rids []RID
+	var rows []Row
+	err := ScanRows(t.heap, func(rid RID, row Row) {
+		rids = append(rids, rid)
+		rows = append(rows, row)
+	})
+	if err != nil {
+		return err
+	}
+	for i, rid := range rids {
+		moved, err := UpdateRow(t.heap, rid, rows[i])
+		if err != nil || moved == rid {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		key := EncodeKey(rows[i][t.pkCol])
+		if _, err := t.pk.Delete(key); err != nil {
+			return err
+		}
+		if err := t.pk.Insert(key, moved.Pack()); err != nil {
+			return err
+		}
+		if err := t.removeEntries(rid, rows[i]); err != nil {
+			return err
+		}
+		if err := t.addEntries(moved, rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert validates a row against the column types and stores it, rejecting
+// duplicate primary keys and values of unique indexes via the indexes.
+func (t *Table) Insert(values []string) error {
+	if len(values) != len(t.Schema.Columns) {
+		return fmt.Errorf("expected %d values, got %d", len(t.Schema.Columns), len(values))
+	}
+	row, err := ParseRow(t.Schema, values)
+	if err != nil {
+		return err
+	}
+	if row[t.pkCol].Null {
+		return fmt.Errorf("primary key column '%s' cannot be NULL", t.Schema.PrimaryKey)
+	}
+	key := EncodeKey(row[t.pkCol])
+	if len(key) > btree.MaxKeySize {
+		return fmt.Errorf("primary key value too long (max %d bytes)", btree.MaxKeySize)
+	}
+	if t.snap == nil {
+		if _, exists, err := t.pk.Get(key); err != nil || exists {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w '%s' for column '%s'", ErrDuplicateKey, row[t.pkCol], t.Schema.PrimaryKey)
+		}
+		if err := t.checkIndexes(row); err != nil {
+			return err
+		}
+		rid, err := InsertRow(t.heap, row)
+		if err != nil {
+			return err
+		}
+		if err := t.pk.Insert(key, rid.Pack()); err != nil {
+			return err
+		}
+		return t.addEntries(rid, row)
+	}
+	entry, ok, err := t.pk.Get(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		// the key's last row is likely deleted: reclaim it first if nobody can see it any more
+		if err := t.prunePage(UnpackRID(entry).Page); err != nil {
+			return err
+		}
+	}
+	taken, err := t.keyTaken(key)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("%w '%s' for column '%s'", ErrDuplicateKey, row[t.pkCol], t.Schema.PrimaryKey)
+	}
+	if err := t.checkIndexes(row); err != nil {
+		return err
+	}
+	return t.insertVersion(key, row)
+}
+
+/*
+keyTaken reports whether a row has key, judging by the newest version stored
+under it: one nobody has deleted is a row, even if it was committed after the
+snapshot was taken. A row deleted by a transaction the snapshot does not see
+is neither free nor visible, and fails with ErrSerialization.
+*/
+func (t *Table) keyTaken(key []byte) (bool, error) {
+	entry, ok, err := t.pk.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if t.snap == nil {
+		return true, nil
+	}
+	v, _, found, err := readVersion(t.heap, UnpackRID(entry))
+	switch {
+	case err != nil || !found:
+		return false, err
+	case v.xmax == 0:
+		return true, nil
+	case !t.snap.sees(v.xmax):
+		return false, ErrSerialization
+	}
+	return false, nil
+}
+
+// insertVersion stores row as the newest version under key, created by the snapshot's transaction.
+func (t *Table) insertVersion(key []byte, row Row) error {
+	v := version{xmin: t.snap.xid}
+	entry, replaces, err := t.pk.Get(key)
+	if err != nil {
+		return err
+	}
+	if replaces {
+		v.prev, v.hasPrev = UnpackRID(entry), true
+	}
+	rid, err := insertVersion(t.heap, v, row)
+	if err != nil {
+		return err
+	}
+	if replaces {
+		if _, err := t.pk.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := t.pk.Insert(key, rid.Pack()); err != nil {
+		return err
+	}
+	return t.addEntries(rid, row)
+}
+
+// deleteVersion marks the version at rid as deleted by the snapshot's transaction.
+// A version another transaction has already deleted or replaced cannot be: ErrSerialization.
+func (t *Table) deleteVersion(rid RID) error {
+	v, _, found, err := readVersion(t.heap, rid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("row %v: no row at this location", rid)
+	}
+	if v.xmax != 0 {
+		return ErrSerialization
+	}
+	return markDeleted(t.heap, rid, t.snap.xid)
+}
+
+// resolve returns the version of key the snapshot sees, starting from the index entry at rid.
+func (t *Table) resolve(key []byte, rid RID) (RID, Row, bool, error) {
+	v, row, found, err := readVersion(t.heap, rid)
+	// pages read while a commit prunes the chain can lead back to itself
+	seen := map[RID]bool{rid: true}
+	for {
+		if err != nil || !found || !bytes.Equal(EncodeKey(row[t.pkCol]), key) {
+			return RID{}, nil, false, err
+		}
+		if t.snap.visible(v.xmin, v.xmax) {
+			return rid, row, true, nil
+		}
+		// Versions before one the snapshot sees created are older still, and not visible either
+		if t.snap == nil || t.snap.sees(v.xmin) || !v.hasPrev || seen[v.prev] {
+			return RID{}, nil, false, nil
+		}
+		rid = v.prev
+		seen[rid] = true
+		v, row, found, err = readVersion(t.heap, rid)
+	}
+}
+
+// prune removes the versions on every heap page that no snapshot sees any more.
+func (t *Table) prune() error {
+	for pageNum := uint32(0); pageNum < uint32(t.heap.pager.PageCount()); pageNum++ {
+		if err := t.prunePage(pageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+prunePage removes the versions on a heap page deleted below the horizon.
+Nothing may point at them afterwards: their secondary index entries and the
+primary-key entry of a removed newest version are dropped, and a version that
+replaced a removed one forgets it.
+*/
+func (t *Table) prunePage(pageNum uint32) error {
+	if t.horizon == 0 {
+		return nil
+	}
+	return prunePage(t.heap, pageNum, t.horizon, func(rid RID, row Row) error {
+		if err := t.removeEntries(rid, row); err != nil {
+			return err
+		}
+		key := EncodeKey(row[t.pkCol])
+		entry, ok, err := t.pk.Get(key)
+		if err != nil || !ok {
+			return err
+		}
+		if UnpackRID(entry) == rid {
+			_, err := t.pk.Delete(key)
+			return err
+		}
+		for next := UnpackRID(entry); ; {
+			v, _, found, err := readVersion(t.heap, next)
+			if err != nil || !found || !v.hasPrev {
+				return err
+			}
+			if v.prev == rid {
+				v.prev, v.hasPrev = RID{}, false
+				return rewriteVersion(t.heap, next, func(tuple []byte) { putVersion(tuple, v) })
+			}
+			next = v.prev
+		}
+	})
+}
+
+// Lookup fetches the row with the given primary-key value.
+func (t *Table) Lookup(pk string) (Row, bool, error) {
+	val, err := ParseValue(t.Schema.TypeOf(t.pkCol), pk)
+	if err != nil || val.Null {
+		return nil, false, err
+	}
+	k := EncodeKey(val)
+	c := t.Scan(AccessPath{Index: catalog.PrimaryKeyIndex, bounds: keyBounds{lo: k, hi: k, loIncl: true, hiIncl: true}}, nil)
+	defer c.Close()
+	if c.Next() {
+		return c.Row(), true, nil
+	}
+	return nil, false, c.Err()
+}
+
+// Select returns the rows matching where (nil = all rows). Large results are better read with a Cursor.
+func (t *Table) Select(where par.Expr) ([]Row, error) {
+	var rows []Row
+	err := t.scan(where, func(_ RID, row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+/*
+Delete removes the rows matching where (nil = all rows) and returns how many
+were removed. With a snapshot their versions are only marked deleted, for
+older snapshots to go on seeing; without one they leave the heap and the
+indexes at once.
+*/
+func (t *Table) Delete(where par.Expr) (int, error) {
+	if err := t.pruneBeforeScan(where); err != nil {
+		return 0, err
+	}
+	// Collect first so the scan never sees pages we are rewriting
+	var rids []RID
+	var rows []Row
+	err := t.scan(where, func(rid RID, row Row) error {
+		rids = append(rids, rid)
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, rid := range rids {
+		if t.snap != nil {
+			if err := t.deleteVersion(rid); err != nil {
+				return i, err
+			}
+			continue
+		}
+		if _, err := t.pk.Delete(EncodeKey(rows[i][t.pkCol])); err != nil {
+			return i, err
+		}
+		if err := t.removeEntries(rid, rows[i]); err != nil {
+			return i, err
+		}
+		if err := DeleteRow(t.heap, rid); err != nil {
+			return i, err
+		}
+	}
+	return len(rids), nil
+}
+
+/*
+Update applies the SET assignments to every row matching where and returns how many
+rows changed. An assignment whose value is a column reference (Ref) assigns that
+column's value in the row before the update, e.g. SET total = subtotal; its type
+must be the target column's (an INTEGER may go into a REAL). All new rows are checked for
+primary-key conflicts before anything is written; unique indexes are checked as
+each row is written, against the rows updated before it, and a conflict fails
+the statement's transaction.
+*/
+func (t *Table) Update(assignments []par.Assignment, where par.Expr) (int, error) {
+	positions := make([]int, len(assignments))
+	newValues := make([]Value, len(assignments))
+	from := make([]int, len(assignments)) // column whose old value is assigned, -1 for a literal
+	for i, a := range assignments {
+		positions[i] = t.Schema.ColumnIndex(a.Column)
+		if positions[i] == -1 {
+			return 0, fmt.Errorf("column %q %w in table %q", a.Column, catalog.ErrNoSuchColumn, t.Schema.Name)
+		}
+		from[i] = -1
+		if a.Ref {
+			if from[i] = t.Schema.ColumnIndex(a.Value); from[i] == -1 {
+				return 0, fmt.Errorf("column %q %w in table %q (quote text values)", a.Value, catalog.ErrNoSuchColumn, t.Schema.Name)
+			}
+			src, dst := t.Schema.TypeOf(from[i]), t.Schema.TypeOf(positions[i])
+			if src != dst && (src != catalog.TypeInteger || dst != catalog.TypeReal) {
+				return 0, fmt.Errorf("column %q: cannot assign %s column %q to a %s column", a.Column, src, a.Value, dst)
+			}
+			continue
+		}
+		v, err := ParseValue(t.Schema.TypeOf(positions[i]), a.Value)
+		if err != nil {
+			return 0, fmt.Errorf("column %q: %w", a.Column, err)
+		}
+		if v.Null && positions[i] == t.pkCol {
+			return 0, fmt.Errorf("primary key column '%s' cannot be NULL", t.Schema.PrimaryKey)
+		}
+		newValues[i] = v
+	}
+	if err := t.pruneBeforeScan(where); err != nil {
+		return 0, err
+	}
+
+	type change struct {
+		rid            RID
+		oldKey, newKey []byte
+		old, row       Row
+	}
+	var changes []change
+	err := t.scan(where, func(rid RID, row Row) error {
+		updated := append(Row(nil), row...)
+		for i, pos := range positions {
+			if from[i] == -1 {
+				updated[pos] = newValues[i]
+				continue
+			}
+			v := row[from[i]]
+			if v.Null && pos == t.pkCol {
+				return fmt.Errorf("primary key column '%s' cannot be NULL", t.Schema.PrimaryKey)
+			}
+			if v.Type == catalog.TypeInteger && t.Schema.TypeOf(pos) == catalog.TypeReal {
+				v = Value{Type: catalog.TypeReal, Real: float64(v.Int), Null: v.Null}
+			}
+			updated[pos] = v
+		}
+		changes = append(changes, change{rid, EncodeKey(row[t.pkCol]), EncodeKey(updated[t.pkCol]), row, updated})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// A new key may collide with a row outside the update, or with another updated row
+	freed := make(map[string]bool)
+	for _, c := range changes {
+		freed[string(c.oldKey)] = true
+	}
+	claimed := make(map[string]bool)
+	for _, c := range changes {
+		k := string(c.newKey)
+		if len(c.newKey) > btree.MaxKeySize {
+			return 0, fmt.Errorf("primary key value too long (max %d bytes)", btree.MaxKeySize)
+		}
+		taken, err := t.keyTaken(c.newKey)
+		if err != nil {
+			return 0, err
+		}
+		if (taken && !freed[k]) || claimed[k] {
+			return 0, fmt.Errorf("%w '%s' for column '%s'", ErrDuplicateKey, c.row[t.pkCol], t.Schema.PrimaryKey)
+		}
+		claimed[k] = true
+	}
+
+	if t.snap != nil {
+		// Retire every old version before adding new ones, so keys can be swapped between rows
+		for _, c := range changes {
+			if err := t.deleteVersion(c.rid); err != nil {
+				return 0, err
+			}
+		}
+		for i, c := range changes {
+			if err := t.checkIndexes(c.row); err != nil {
+				return i, err
+			}
+			if err := t.insertVersion(c.newKey, c.row); err != nil {
+				return i, err
+			}
+		}
+		return len(changes), nil
+	}
+
+	// Drop every old index entry first so keys can be swapped between rows
+	for _, c := range changes {
+		if _, err := t.pk.Delete(c.oldKey); err != nil {
+			return 0, err
+		}
+		if err := t.removeEntries(c.rid, c.old); err != nil {
+			return 0, err
+		}
+	}
+	for i, c := range changes {
+		if err := t.checkIndexes(c.row); err != nil {
+			return i, err
+		}
+		rid, err := UpdateRow(t.heap, c.rid, c.row)
+		if err != nil {
+			return i, err
+		}
+		if err := t.pk.Insert(c.newKey, rid.Pack()); err != nil {
+			return i, err
+		}
+		if err := t.addEntries(rid, c.row); err != nil {
+			return i, err
+		}
+	}
+	return len(changes), nil
+}
+
+// pruneBeforeScan lets a writer that is about to read every heap page reclaim dead versions first.
+func (t *Table) pruneBeforeScan(where par.Expr) error {
+	p, err := t.BestPath(where)
+	if err != nil || p.Index != "" {
+		return err
+	}
+	return t.prune()
+}
+
+// scan calls fn for every row matching where, as the snapshot sees it, read the cheapest way (see AccessPaths).
+func (t *Table) scan(where par.Expr, fn func(rid RID, row Row) error) error {
+	p, err := t.BestPath(where)
+	if err != nil {
+		return err
+	}
+	return t.scanPath(p, where, fn)
+}
+
+// scanPath calls fn for every row matching where, as the snapshot sees it, reading it through p.
+func (t *Table) scanPath(p AccessPath, where par.Expr, fn func(rid RID, row Row) error) error {
+	c := t.Scan(p, where)
+	defer c.Close()
+	for c.Next() {
+		if err := fn(c.rid, c.row); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// tableFiles lists every file that belongs to a table.
+func tableFiles(dbDir string, schema *catalog.TableSchema) []string {
+	files := []string{TablePath(dbDir, schema.Name), FSMPath(dbDir, schema.Name), ToastPath(dbDir, schema.Name), PKIndexPath(dbDir, schema.Name)}
+	for _, ix := range schema.Indexes {
+		files = append(files, IndexPath(dbDir, schema.Name, ix.Name))
+	}
+	return files
+}
+
+// keyBounds is a range of index keys; nil lo/hi means unbounded on that side.
+type keyBounds struct {
+	lo, hi         []byte
+	loIncl, hiIncl bool
+}
+
+func (r keyBounds) includes(key []byte) bool {
+	if r.lo != nil {
+		if c := bytes.Compare(key, r.lo); c < 0 || (c == 0 && !r.loIncl) {
+			return false
+		}
+	}
+	return !r.past(key) && !(r.hi != nil && bytes.Equal(key, r.hi) && !r.hiIncl)
+}
+
+// past reports whether key lies beyond the upper bound, so a scan can stop.
+func (r keyBounds) past(key []byte) bool {
+	return r.hi != nil && bytes.Compare(key, r.hi) > 0
+}
+
+/*
+keyRange extracts the range of column values a WHERE clause can match.
+Only comparisons on column joined by AND narrow the range; anything else
+(OR, != or other columns) returns ok=false for that branch.
+*/
+func keyRange(expr par.Expr, column string, typ catalog.ColumnType) (keyBounds, bool) {
+	switch e := expr.(type) {
+	case *par.Condition:
+		if e.Column != column {
+			return keyBounds{}, false
+		}
+		// literals that are not valid for the column type can't use the index
+		lit, err := ParseValue(typ, e.Value)
+		if err != nil || lit.Null {
+			return keyBounds{}, false
+		}
+		v := EncodeKey(lit)
+		switch e.Operator {
+		case "=":
+			return keyBounds{lo: v, hi: v, loIncl: true, hiIncl: true}, true
+		case ">":
+			return keyBounds{lo: v}, true
+		case ">=":
+			return keyBounds{lo: v, loIncl: true}, true
+		case "<":
+			return keyBounds{hi: v}, true
+		case "<=":
+			return keyBounds{hi: v, hiIncl: true}, true
+		}
+	case *par.BinaryExpr:
+		if strings.ToUpper(e.Operator) != "AND" {
+			return keyBounds{}, false
+		}
+		left, lok := keyRange(e.Left, column, typ)
+		right, rok := keyRange(e.Right, column, typ)
+		switch {
+		case lok && rok:
+			return left.intersect(right), true
+		case lok:
+			return left, true
+		case rok:
+			return right, true
+		}
+	}
+	return keyBounds{}, false
+}
+
+// intersect narrows r to the keys that also fall in o.
+func (r keyBounds) intersect(o keyBounds) keyBounds {
+	if o.lo != nil {
+		if c := bytes.Compare(o.lo, r.lo); r.lo == nil || c > 0 || (c == 0 && !o.loIncl) {
+			r.lo, r.loIncl = o.lo, o.loIncl
+		}
+	}
+	if o.hi != nil {
+		if c := bytes.Compare(o.hi, r.hi); r.hi == nil || c < 0 || (c == 0 && !o.hiIncl) {
+			r.hi, r.hiIncl = o.hi, o.hiIncl
+		}
+	}
+	return r
+}
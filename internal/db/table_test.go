@@ -0,0 +1,300 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+)
+
+func TestTableInsertDeleteReuse(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{Name: "users", Columns: []string{"id", "name"}, PrimaryKey: "id"}
+
+	table, err := OpenTable(dir, schema, nil)
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	defer table.Close()
+
+	for i := 0; i < 500; i++ {
+		if err := table.Insert([]string{fmt.Sprint(i), fmt.Sprintf("'user-%d'", i)}); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", i, err)
+		}
+	}
+	if err := table.Insert([]string{"7", "'dup'"}); err == nil {
+		t.Errorf("Expected duplicate primary key error, got nil")
+	}
+	info, _ := os.Stat(TablePath(dir, "users"))
+	sizeBefore := info.Size()
+
+	// Delete through the primary key index and through a full scan
+	n, err := table.Delete(&par.Condition{Column: "id", Operator: "=", Value: "7"})
+	if err != nil || n != 1 {
+		t.Fatalf("Delete by key = %d, %v", n, err)
+	}
+	// 'user-4', 'user-40'..'user-49' and 'user-400'..'user-499' (names compare as text)
+	n, err = table.Delete(&par.BinaryExpr{
+		Left:     &par.Condition{Column: "name", Operator: ">=", Value: "'user-4'"},
+		Operator: "AND",
+		Right:    &par.Condition{Column: "name", Operator: "<", Value: "'user-5'"},
+	})
+	if err != nil || n != 111 {
+		t.Fatalf("Delete by scan = %d, %v", n, err)
+	}
+	if _, ok, _ := table.Lookup("7"); ok {
+		t.Errorf("Deleted row still found through the index")
+	}
+	rows, err := table.Select(nil)
+	if err != nil || len(rows) != 388 {
+		t.Fatalf("Select after delete returned %d rows, %v", len(rows), err)
+	}
+
+	// Re-inserting the deleted keys must reuse the freed space
+	if err := table.Insert([]string{"7", "'back'"}); err != nil {
+		t.Fatalf("Re-insert of deleted key failed: %v", err)
+	}
+	for i := 400; i < 500; i++ {
+		if err := table.Insert([]string{fmt.Sprint(i), fmt.Sprintf("'user-%d'", i)}); err != nil {
+			t.Fatalf("Re-insert(%d) failed: %v", i, err)
+		}
+	}
+	info, _ = os.Stat(TablePath(dir, "users"))
+	if info.Size() != sizeBefore {
+		t.Errorf("Table grew from %d to %d bytes instead of reusing freed space", sizeBefore, info.Size())
+	}
+}
+
+func TestTableUpdate(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{Name: "docs", Columns: []string{"id", "body"}, PrimaryKey: "id"}
+
+	table, err := OpenTable(dir, schema, nil)
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	defer table.Close()
+
+	for i := 0; i < 200; i++ {
+		if err := table.Insert([]string{fmt.Sprint(i), "'short'"}); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", i, err)
+		}
+	}
+
+	// Growing a row on a full page must move it and keep the index pointing at it
+	big := strings.Repeat("x", 2000)
+	set := []par.Assignment{{Column: "body", Value: "'" + big + "'"}}
+	if n, err := table.Update(set, &par.Condition{Column: "id", Operator: "=", Value: "3"}); err != nil || n != 1 {
+		t.Fatalf("Update grow = %d, %v", n, err)
+	}
+	row, ok, err := table.Lookup("3")
+	if err != nil || !ok || row[1].Str != big {
+		t.Fatalf("Lookup after grow = %v, %v, %v", ok, err, row)
+	}
+
+	// Changing the key to one that exists is rejected, to a free one is allowed
+	setKey := []par.Assignment{{Column: "id", Value: "4"}}
+	if _, err := table.Update(setKey, &par.Condition{Column: "id", Operator: "=", Value: "3"}); err == nil {
+		t.Errorf("Expected duplicate primary key error, got nil")
+	}
+	setKey[0].Value = "1000"
+	if n, err := table.Update(setKey, &par.Condition{Column: "id", Operator: "=", Value: "3"}); err != nil || n != 1 {
+		t.Fatalf("Update key = %d, %v", n, err)
+	}
+	if _, ok, _ := table.Lookup("3"); ok {
+		t.Errorf("Old key still present after update")
+	}
+	if row, ok, _ := table.Lookup("1000"); !ok || row[1].Str != big {
+		t.Errorf("New key not found after update")
+	}
+	rows, _ := table.Select(nil)
+	if len(rows) != 200 {
+		t.Errorf("Expected 200 rows after updates, got %d", len(rows))
+	}
+}
+
+func TestTypedColumns(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{
+		Name:       "people",
+		Columns:    []string{"id", "age", "score"},
+		Types:      []catalog.ColumnType{catalog.TypeInteger, catalog.TypeInteger, catalog.TypeReal},
+		PrimaryKey: "id",
+	}
+	table, err := OpenTable(dir, schema, nil)
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	defer table.Close()
+
+	for i := -20; i <= 20; i++ {
+		if err := table.Insert([]string{fmt.Sprint(i), fmt.Sprint(i * 3), fmt.Sprint(float64(i) / 4)}); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", i, err)
+		}
+	}
+	if err := table.Insert([]string{"abc", "1", "1"}); err == nil {
+		t.Errorf("Expected INTEGER validation error, got nil")
+	}
+	if err := table.Insert([]string{"007", "1", "1"}); err == nil {
+		t.Errorf("Expected 007 to coerce to the existing key 7")
+	}
+
+	// A column name on the right of SET reads the row's old value
+	set := []par.Assignment{{Column: "score", Value: "age", Ref: true}, {Column: "age", Value: "id", Ref: true}}
+	if n, err := table.Update(set, &par.Condition{Column: "id", Operator: "=", Value: "4"}); err != nil || n != 1 {
+		t.Fatalf("Update from columns = %d, %v", n, err)
+	}
+	if row, ok, _ := table.Lookup("4"); !ok || row[1].Int != 4 || row[2].Real != 12 {
+		t.Errorf("Update from columns gave %v, want age 4 and score 12", row)
+	}
+	if _, err := table.Update([]par.Assignment{{Column: "age", Value: "score", Ref: true}}, nil); err == nil {
+		t.Errorf("Expected an error assigning a REAL column to an INTEGER one")
+	}
+	if row, _, _ := table.Lookup("5"); row[1].Int != 15 {
+		t.Errorf("Rejected update changed row 5: %v", row)
+	}
+
+	// Comparisons must be numeric: as text "9" > "18" and "-5" sorts after "-18"
+	cases := []struct {
+		where par.Expr
+		want  int
+	}{
+		{&par.Condition{Column: "age", Operator: ">", Value: "18"}, 14},
+		{&par.Condition{Column: "id", Operator: ">=", Value: "-5"}, 26},
+		{&par.Condition{Column: "id", Operator: "<", Value: "-18"}, 2},
+		{&par.Condition{Column: "score", Operator: "<=", Value: "'-4.5'"}, 3},
+		{&par.Condition{Column: "id", Operator: "<", Value: "2.5"}, 23},
+	}
+	for _, c := range cases {
+		rows, err := table.Select(c.where)
+		if err != nil || len(rows) != c.want {
+			t.Errorf("Select(%+v) returned %d rows, %v; want %d", c.where, len(rows), err, c.want)
+		}
+	}
+}
+
+func TestNulls(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{
+		Name:       "items",
+		Columns:    []string{"id", "label", "qty"},
+		Types:      []catalog.ColumnType{catalog.TypeInteger, catalog.TypeText, catalog.TypeInteger},
+		PrimaryKey: "id",
+	}
+	table, err := OpenTable(dir, schema, nil)
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	defer table.Close()
+
+	inserts := [][]string{{"1", "NULL", "5"}, {"2", "''", "NULL"}, {"3", "'NULL'", "20"}}
+	for _, v := range inserts {
+		if err := table.Insert(v); err != nil {
+			t.Fatalf("Insert(%v) failed: %v", v, err)
+		}
+	}
+	if err := table.Insert([]string{"NULL", "'x'", "1"}); err == nil {
+		t.Errorf("Expected NULL primary key to be rejected")
+	}
+
+	// NULL, the empty string and the text 'NULL' must all survive the round trip distinctly
+	row, _, _ := table.Lookup("1")
+	if !row[1].Null {
+		t.Errorf("Expected NULL label, got %q", row[1])
+	}
+	row, _, _ = table.Lookup("2")
+	if row[1].Null || row[1].Str != "" || !row[2].Null {
+		t.Errorf("Expected empty label and NULL qty, got %v", row.Strings())
+	}
+	row, _, _ = table.Lookup("3")
+	if row[1].Null || row[1].Str != "NULL" {
+		t.Errorf("Expected text 'NULL', got %v", row.Strings())
+	}
+
+	cases := []struct {
+		where par.Expr
+		want  int
+	}{
+		{&par.IsNullExpr{Column: "qty"}, 1},
+		{&par.IsNullExpr{Column: "label", Not: true}, 2},
+		// NULL > 10 is unknown, so NOT of it is unknown too and row 2 stays out
+		{&par.NotExpr{Expr: &par.Condition{Column: "qty", Operator: ">", Value: "10"}}, 1},
+		{&par.Condition{Column: "qty", Operator: "=", Value: "NULL"}, 0},
+		{&par.BinaryExpr{
+			Left:     &par.Condition{Column: "qty", Operator: "<", Value: "10"},
+			Operator: "OR",
+			Right:    &par.IsNullExpr{Column: "qty"},
+		}, 2},
+	}
+	for _, c := range cases {
+		rows, err := table.Select(c.where)
+		if err != nil || len(rows) != c.want {
+			t.Errorf("Select(%+v) returned %d rows, %v; want %d", c.where, len(rows), err, c.want)
+		}
+	}
+}
+
+func TestLargeRows(t *testing.T) {
+	dir := t.TempDir()
+	schema := &catalog.TableSchema{Name: "docs", Columns: []string{"id", "body"}, Types: []catalog.ColumnType{catalog.TypeInteger, catalog.TypeText}, PrimaryKey: "id"}
+	body := func(id, n int) string {
+		return strings.Repeat(fmt.Sprintf("doc %d ", id), n)[:n]
+	}
+
+	table, err := OpenTable(dir, schema, nil)
+	if err != nil {
+		t.Fatalf("Failed to open table: %v", err)
+	}
+	if err := table.Insert([]string{"1", "'" + body(1, 3<<20) + "'"}); err != nil {
+		t.Fatalf("Insert of a 3MB row failed: %v", err)
+	}
+	for i := 2; i <= 20; i++ {
+		if err := table.Insert([]string{fmt.Sprint(i), "'" + body(i, 10000) + "'"}); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", i, err)
+		}
+	}
+	if row, ok, err := table.Lookup("1"); err != nil || !ok || row[1].String() != body(1, 3<<20) {
+		t.Fatalf("3MB row did not read back: %v, %v", ok, err)
+	}
+	if n, err := table.Update([]par.Assignment{{Column: "body", Value: "'" + body(1, 2<<20) + "'"}}, &par.Condition{Column: "id", Operator: "=", Value: "1"}); err != nil || n != 1 {
+		t.Fatalf("Update of the large row = %d, %v", n, err)
+	}
+	pagesBefore := table.heap.toastFile.PageCount()
+
+	// Chunks of deleted rows are reused
+	if n, err := table.Delete(&par.Condition{Column: "id", Operator: ">", Value: "1"}); err != nil || n != 19 {
+		t.Fatalf("Delete = %d, %v", n, err)
+	}
+	for i := 2; i <= 20; i++ {
+		if err := table.Insert([]string{fmt.Sprint(i), "'" + body(i, 10000) + "'"}); err != nil {
+			t.Fatalf("Re-insert(%d) failed: %v", i, err)
+		}
+	}
+	if pages := table.heap.toastFile.PageCount(); pages != pagesBefore {
+		t.Errorf("Toast file grew from %d to %d pages instead of reusing freed chunks", pagesBefore, pages)
+	}
+	table.Close()
+
+	table, err = OpenTable(dir, schema, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen table: %v", err)
+	}
+	defer table.Close()
+	rows, err := table.Select(nil)
+	if err != nil || len(rows) != 20 {
+		t.Fatalf("Select after reopen returned %d rows, %v", len(rows), err)
+	}
+	for _, row := range rows {
+		id := int(row[0].Int)
+		want := 10000
+		if id == 1 {
+			want = 2 << 20
+		}
+		if row[1].String() != body(id, want) {
+			t.Errorf("row %d reads back %d bytes, want %d", id, len(row[1].String()), want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+Rows too large to share a heap page with others are stored out of line
+("toasted") in the table's toast file. The heap keeps a stub in the row's
+slot, so MVCC headers are read and changed there as for any other tuple:
+
+	[format uint8 = 3][xmin uint64][xmax uint64][prev uint64][tuple length uint32][first chunk page uint32]
+
+The toast file holds the whole tuple (version header included, though the
+stub's is the one that counts) in a chain of chunk pages:
+
+	page 0   [free list head uint32]
+	chunk    [next page uint32][length uint16][bytes...]
+
+Page number 0 ends a chain. Chunks of deleted rows go on the free list, linked
+through their next field, and are reused by later rows.
+*/
+const (
+	tupleFormatToasted = 3
+	toastStubSize      = 1 + versionHeaderSize + 8
+	// toastThreshold is the largest tuple kept in its heap page; four of them fit in a page.
+	toastThreshold   = MaxTupleSize / 4
+	toastChunkHeader = 6
+	toastChunkSize   = storage.UsablePageSize - toastChunkHeader
+	// MaxRowSize is the largest encoded row a table takes.
+	MaxRowSize = 256 << 20
+)
+
+// toasted reports whether a heap tuple is a stub for a row stored in the toast file.
+func toasted(tuple []byte) bool {
+	return len(tuple) == toastStubSize && tuple[0] == tupleFormatToasted
+}
+
+// toast returns what the heap should store for tuple: the tuple itself if it
+// is small enough, otherwise a stub for a copy written to the toast file.
+func (h *Heap) toast(tuple []byte) ([]byte, error) {
+	if len(tuple) <= toastThreshold {
+		return tuple, nil
+	}
+	if len(tuple) > MaxRowSize {
+		return nil, fmt.Errorf("row is %d bytes, larger than the %d byte limit", len(tuple), MaxRowSize)
+	}
+	chunks := (len(tuple) + toastChunkSize - 1) / toastChunkSize
+	pages, err := h.allocChunks(chunks)
+	if err != nil {
+		return nil, err
+	}
+	for i, pageNum := range pages {
+		page := make([]byte, storage.UsablePageSize)
+		if i+1 < len(pages) {
+			binary.LittleEndian.PutUint32(page[0:4], pages[i+1])
+		}
+		chunk := tuple[i*toastChunkSize : min((i+1)*toastChunkSize, len(tuple))]
+		binary.LittleEndian.PutUint16(page[4:6], uint16(len(chunk)))
+		copy(page[toastChunkHeader:], chunk)
+		if err := h.toastFile.FlushPage(pageNum, page); err != nil {
+			return nil, err
+		}
+	}
+	stub := make([]byte, toastStubSize)
+	copy(stub, tuple[:1+versionHeaderSize])
+	stub[0] = tupleFormatToasted
+	binary.LittleEndian.PutUint32(stub[25:29], uint32(len(tuple)))
+	binary.LittleEndian.PutUint32(stub[29:33], pages[0])
+	return stub, nil
+}
+
+// allocChunks takes n pages for a chain from the free list, then from the end of the file.
+func (h *Heap) allocChunks(n int) ([]uint32, error) {
+	if h.toastFile.PageCount() == 0 {
+		if _, err := h.toastFile.AllocatePage(); err != nil { // the free list head
+			return nil, err
+		}
+	}
+	meta, err := h.toastFile.GetPage(0)
+	if err != nil {
+		return nil, err
+	}
+	free := binary.LittleEndian.Uint32(meta[0:4])
+	pages := make([]uint32, 0, n)
+	for len(pages) < n && free != 0 {
+		pages = append(pages, free)
+		page, err := h.toastFile.GetPage(free)
+		if err != nil {
+			return nil, err
+		}
+		free = binary.LittleEndian.Uint32(page[0:4])
+	}
+	for len(pages) < n {
+		pageNum, err := h.toastFile.AllocatePage()
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, pageNum)
+	}
+	binary.LittleEndian.PutUint32(meta[0:4], free)
+	return pages, h.toastFile.FlushPage(0, meta)
+}
+
+// detoast returns the whole tuple a heap tuple stands for.
+func (h *Heap) detoast(tuple []byte) ([]byte, error) {
+	if !toasted(tuple) {
+		return tuple, nil
+	}
+	length := int(binary.LittleEndian.Uint32(tuple[25:29]))
+	out := make([]byte, 0, length)
+	for pageNum := binary.LittleEndian.Uint32(tuple[29:33]); pageNum != 0; {
+		if len(out) >= length {
+			return nil, fmt.Errorf("%w: toast chain longer than %d bytes", errCorruptTuple, length)
+		}
+		page, unpin, err := h.toastFile.Pin(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		next := binary.LittleEndian.Uint32(page[0:4])
+		n := min(int(binary.LittleEndian.Uint16(page[4:6])), toastChunkSize)
+		out = append(out, page[toastChunkHeader:toastChunkHeader+n]...)
+		unpin()
+		pageNum = next
+	}
+	if len(out) != length {
+		return nil, fmt.Errorf("%w: toast chain holds %d of %d bytes", errCorruptTuple, len(out), length)
+	}
+	copy(out[1:1+versionHeaderSize], tuple[1:1+versionHeaderSize])
+	return out, nil
+}
+
+// decode reads the row of a heap tuple, fetching it from the toast file if it is stored there.
+func (h *Heap) decode(tuple []byte) (Row, error) {
+	tuple, err := h.detoast(tuple)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeRow(h.schema, tuple)
+}
+
+// freeToast puts the chunks of a tuple that is being removed from the heap on the free list.
+func (h *Heap) freeToast(tuple []byte) error {
+	if !toasted(tuple) {
+		return nil
+	}
+	chunks := (int(binary.LittleEndian.Uint32(tuple[25:29])) + toastChunkSize - 1) / toastChunkSize
+	meta, err := h.toastFile.GetPage(0)
+	if err != nil {
+		return err
+	}
+	free := binary.LittleEndian.Uint32(meta[0:4])
+	pageNum := binary.LittleEndian.Uint32(tuple[29:33])
+	for i := 0; i < chunks && pageNum != 0; i++ {
+		page, err := h.toastFile.GetPage(pageNum)
+		if err != nil {
+			return err
+		}
+		next := binary.LittleEndian.Uint32(page[0:4])
+		clear(page)
+		binary.LittleEndian.PutUint32(page[0:4], free)
+		if err := h.toastFile.FlushPage(pageNum, page); err != nil {
+			return err
+		}
+		free, pageNum = pageNum, next
+	}
+	binary.LittleEndian.PutUint32(meta[0:4], free)
+	return h.toastFile.FlushPage(0, meta)
+}
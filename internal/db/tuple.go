@@ -0,0 +1,202 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+)
+
+/*
+Binary tuple format, driven by the table schema:
+
+	[format uint8 = 2][xmin uint64][xmax uint64][prev uint64][column count uint16][null bitmap: 1 bit per column][fields...]
+
+xmin and xmax are the transactions that created and deleted this version of
+the row (see mvcc.go); prev is RID.Pack()+1 of the version it replaced under
+the same primary key, 0 if none. Fields are written in column order and
+skipped for NULL columns:
+
+	INTEGER  8 bytes, little endian two's complement
+	REAL     8 bytes, IEEE 754 bits
+	BOOLEAN  1 byte
+	TEXT     uvarint length + UTF-8 bytes
+	BLOB     uvarint length + bytes
+
+Format 1 is the same without the version header and CSV text came before it;
+DeserializeRow still reads both, as versions every transaction sees. Format 3
+is the stub of a row stored in the toast file (see toast.go), which the heap
+reads back in format 2.
+*/
+const (
+	tupleFormatBinary    = 1
+	tupleFormatVersioned = 2
+	versionHeaderSize    = 24
+)
+
+// version is the MVCC header of a tuple.
+type version struct {
+	xmin, xmax XID
+	prev       RID
+	hasPrev    bool
+}
+
+var errCorruptTuple = errors.New("corrupt tuple")
+
+// SerializeRow encodes a typed row as a version every transaction sees.
+func SerializeRow(schema *catalog.TableSchema, row Row) []byte {
+	return serializeVersion(schema, version{}, row)
+}
+
+// serializeVersion encodes a typed row with its version header.
+func serializeVersion(schema *catalog.TableSchema, v version, row Row) []byte {
+	n := len(row)
+	head := 1 + versionHeaderSize + 2
+	out := make([]byte, head+(n+7)/8, head+(n+7)/8+8*n)
+	out[0] = tupleFormatVersioned
+	putVersion(out, v)
+	binary.LittleEndian.PutUint16(out[head-2:head], uint16(n))
+	bitmap := out[head:]
+	for i, v := range row {
+		if v.Null {
+			bitmap[i/8] |= 1 << (i % 8)
+			continue
+		}
+		switch schema.TypeOf(i) {
+		case catalog.TypeInteger:
+			out = binary.LittleEndian.AppendUint64(out, uint64(v.Int))
+		case catalog.TypeReal:
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v.Real))
+		case catalog.TypeBoolean:
+			if v.Bool {
+				out = append(out, 1)
+			} else {
+				out = append(out, 0)
+			}
+		case catalog.TypeBlob:
+			out = binary.AppendUvarint(out, uint64(len(v.Bytes)))
+			out = append(out, v.Bytes...)
+		default:
+			out = binary.AppendUvarint(out, uint64(len(v.Str)))
+			out = append(out, v.Str...)
+		}
+	}
+	return out
+}
+
+func putVersion(tuple []byte, v version) {
+	var prev uint64
+	if v.hasPrev {
+		prev = v.prev.Pack() + 1
+	}
+	binary.LittleEndian.PutUint64(tuple[1:9], uint64(v.xmin))
+	binary.LittleEndian.PutUint64(tuple[9:17], uint64(v.xmax))
+	binary.LittleEndian.PutUint64(tuple[17:25], prev)
+}
+
+// tupleVersion reads the version header of a tuple; older formats have none and are seen by everyone.
+func tupleVersion(tuple []byte) (version, bool) {
+	if len(tuple) < 1+versionHeaderSize || (tuple[0] != tupleFormatVersioned && tuple[0] != tupleFormatToasted) {
+		return version{}, false
+	}
+	v := version{
+		xmin: XID(binary.LittleEndian.Uint64(tuple[1:9])),
+		xmax: XID(binary.LittleEndian.Uint64(tuple[9:17])),
+	}
+	if prev := binary.LittleEndian.Uint64(tuple[17:25]); prev != 0 {
+		v.prev, v.hasPrev = UnpackRID(prev-1), true
+	}
+	return v, true
+}
+
+// setXmax marks a versioned tuple in place as deleted by xid.
+func setXmax(tuple []byte, xid XID) {
+	binary.LittleEndian.PutUint64(tuple[9:17], uint64(xid))
+}
+
+// DeserializeRow decodes a tuple produced by SerializeRow (or an older format 1 or CSV tuple).
+func DeserializeRow(schema *catalog.TableSchema, data []byte) (Row, error) {
+	if len(data) == 0 {
+		return nil, errCorruptTuple
+	}
+	switch data[0] {
+	case tupleFormatVersioned:
+		if len(data) < 1+versionHeaderSize {
+			return nil, errCorruptTuple
+		}
+		data = data[versionHeaderSize:] // the rest reads like format 1
+	case tupleFormatToasted:
+		return nil, fmt.Errorf("%w: toasted row read without its heap", errCorruptTuple)
+	case tupleFormatBinary:
+	default:
+		return deserializeCSVRow(schema, data)
+	}
+	if len(data) < 3 {
+		return nil, errCorruptTuple
+	}
+	n := int(binary.LittleEndian.Uint16(data[1:3]))
+	off := 3 + (n+7)/8
+	if len(data) < off || n > len(schema.Columns) {
+		return nil, errCorruptTuple
+	}
+	bitmap := data[3:off]
+	row := make(Row, len(schema.Columns))
+	for i := range row {
+		t := schema.TypeOf(i)
+		row[i].Type = t
+		// columns past the stored count (or flagged in the bitmap) are NULL
+		if i >= n || bitmap[i/8]&(1<<(i%8)) != 0 {
+			row[i].Null = true
+			continue
+		}
+		switch t {
+		case catalog.TypeInteger, catalog.TypeReal:
+			if len(data) < off+8 {
+				return nil, errCorruptTuple
+			}
+			bits := binary.LittleEndian.Uint64(data[off:])
+			if t == catalog.TypeInteger {
+				row[i].Int = int64(bits)
+			} else {
+				row[i].Real = math.Float64frombits(bits)
+			}
+			off += 8
+		case catalog.TypeBoolean:
+			if len(data) < off+1 {
+				return nil, errCorruptTuple
+			}
+			row[i].Bool = data[off] != 0
+			off++
+		default:
+			length, sz := binary.Uvarint(data[off:])
+			if sz <= 0 || uint64(len(data)-off-sz) < length {
+				return nil, errCorruptTuple
+			}
+			off += sz
+			field := data[off : off+int(length)]
+			if t == catalog.TypeBlob {
+				row[i].Bytes = append([]byte(nil), field...)
+			} else {
+				row[i].Str = string(field)
+			}
+			off += int(length)
+		}
+	}
+	return row, nil
+}
+
+// deserializeCSVRow reads a tuple in the older CSV text layout and types it with the schema.
+func deserializeCSVRow(schema *catalog.TableSchema, data []byte) (Row, error) {
+	values, err := csv.NewReader(bytes.NewReader(data)).Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(schema.Columns) {
+		return nil, fmt.Errorf("%w: %d values for %d columns", errCorruptTuple, len(values), len(schema.Columns))
+	}
+	return ParseRow(schema, values)
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+upgradeFiles brings the files of every table to the current page file format
+(see storage/header.go), before anything opens them. Only the heap holds data
+of its own: its rows are copied into a file of the new format, which then
+replaces it (rows that no longer fit a page go to a new toast file). Format 1
+heaps come in two layouts: slotted pages (see page.go), and before them pages
+of CSV records packed from the start, each behind a uint16 length. The
+free-space map, toast file and primary-key index are deleted first and
+rebuilt when the table is opened, so a crash at any point leaves a table that
+is upgraded again on the next open.
+*/
+func (d *Database) upgradeFiles() error {
+	for _, schema := range d.Catalog.ListTables() {
+		h, err := storage.ReadFileHeader(TablePath(d.Dir, schema.Name))
+		if err != nil {
+			return err
+		}
+		if h.Version == storage.FormatVersion {
+			continue
+		}
+		if h.Version != 1 {
+			return fmt.Errorf("%w: table %q is in format %d, this letsgodb reads format %d", storage.ErrFileFormat, schema.Name, h.Version, storage.FormatVersion)
+		}
+		if err := upgradeTable(d.Dir, schema); err != nil {
+			return fmt.Errorf("upgrading table %q to format %d: %w", schema.Name, storage.FormatVersion, err)
+		}
+	}
+	return nil
+}
+
+func upgradeTable(dir string, schema *catalog.TableSchema) error {
+	for _, path := range []string{FSMPath(dir, schema.Name), ToastPath(dir, schema.Name), PKIndexPath(dir, schema.Name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	path := TablePath(dir, schema.Name)
+	tmp := path + ".upgrade"
+	tmpFSM, tmpToast := tmp+".fsm", tmp+".toast"
+	for _, p := range []string{tmp, tmpFSM, tmpToast} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	csvLayout, err := isCSVLayout(path, schema)
+	if err != nil {
+		return err
+	}
+	heap, err := OpenHeap(tmp, tmpFSM, tmpToast, schema, nil)
+	if err != nil {
+		return err
+	}
+	err = storage.ReadV1Pages(path, func(pageNum int, page []byte) error {
+		if csvLayout {
+			records, _ := csvRecords(page)
+			for _, record := range records {
+				row, err := deserializeCSVRow(schema, record)
+				if err != nil {
+					return fmt.Errorf("page %d: %w", pageNum, err)
+				}
+				if _, err := insertTuple(heap, SerializeRow(schema, row)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		p := slottedPage(page)
+		if err := p.check(); err != nil {
+			return fmt.Errorf("page %d: %w", pageNum, err)
+		}
+		for slot := 0; slot < p.slotCount(); slot++ {
+			tuple, err := p.tuple(slot)
+			if err != nil {
+				return fmt.Errorf("page %d: %w", pageNum, err)
+			}
+			if tuple == nil {
+				continue
+			}
+			// Only committed transactions reach the file: a deleted version is gone
+			// for good, and the versions a live one replaced are no longer needed.
+			if v, ok := tupleVersion(tuple); ok {
+				if v.xmax != 0 {
+					continue
+				}
+				v.hasPrev = false
+				putVersion(tuple, v)
+			}
+			if _, err := insertTuple(heap, tuple); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err = errors.Join(err, heap.Close()); err != nil {
+		// the old heap is untouched: leave nothing behind but it
+		for _, p := range []string{tmp, tmpFSM, tmpToast} {
+			os.Remove(p)
+		}
+		return err
+	}
+	for _, p := range []string{tmp, tmpToast} {
+		if err := syncFile(p); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(tmpFSM); err != nil {
+		return err
+	}
+	// the heap goes last: until it is replaced, the next open starts over
+	if err := os.Rename(tmpToast, ToastPath(dir, schema.Name)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+/*
+isCSVLayout reports whether a format 1 heap holds CSV records rather than
+slotted pages. Every page must then be a run of length-prefixed records with
+one field per column, followed by zeros; a slotted page never is, since its
+tuples sit at the end of the page.
+*/
+func isCSVLayout(path string, schema *catalog.TableSchema) (bool, error) {
+	csvLayout := true
+	err := storage.ReadV1Pages(path, func(_ int, page []byte) error {
+		records, ok := csvRecords(page)
+		for _, record := range records {
+			values, err := csv.NewReader(bytes.NewReader(record)).Read()
+			ok = ok && err == nil && len(values) == len(schema.Columns)
+		}
+		csvLayout = csvLayout && ok
+		return nil
+	})
+	return csvLayout, err
+}
+
+// csvRecords splits a page of the CSV layout into its records; ok is false if it is not laid out that way.
+func csvRecords(page []byte) (records [][]byte, ok bool) {
+	off := 0
+	for off+2 <= len(page) {
+		n := int(binary.LittleEndian.Uint16(page[off:]))
+		if n == 0 {
+			break
+		}
+		if off+2+n > len(page) {
+			return nil, false
+		}
+		records = append(records, page[off+2:off+2+n])
+		off += 2 + n
+	}
+	for _, b := range page[min(off, len(page)):] {
+		if b != 0 {
+			return nil, false
+		}
+	}
+	return records, true
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+)
+
+// Value is a single typed column value. Only the field matching Type is meaningful.
+type Value struct {
+	Type  catalog.ColumnType
+	Null  bool
+	Int   int64   // INTEGER
+	Real  float64 // REAL
+	Bool  bool    // BOOLEAN
+	Str   string  // TEXT
+	Bytes []byte  // BLOB
+}
+
+// Row is one table row, one Value per schema column.
+type Row []Value
+
+// String renders a value the way the REPL prints it: INTEGER 42, REAL 3.5,
+// TEXT as is, BOOLEAN true / false and BLOB as x'0a0b'.
+func (v Value) String() string {
+	if v.Null {
+		return "NULL"
+	}
+	switch v.Type {
+	case catalog.TypeInteger:
+		return strconv.FormatInt(v.Int, 10)
+	case catalog.TypeReal:
+		return strconv.FormatFloat(v.Real, 'g', -1, 64)
+	case catalog.TypeBoolean:
+		return strconv.FormatBool(v.Bool)
+	case catalog.TypeBlob:
+		return "x'" + hex.EncodeToString(v.Bytes) + "'"
+	default:
+		return v.Str
+	}
+}
+
+// Any returns the value as a plain Go value: int64, float64, bool, string, []byte, or nil for NULL.
+func (v Value) Any() any {
+	if v.Null {
+		return nil
+	}
+	switch v.Type {
+	case catalog.TypeInteger:
+		return v.Int
+	case catalog.TypeReal:
+		return v.Real
+	case catalog.TypeBoolean:
+		return v.Bool
+	case catalog.TypeBlob:
+		return v.Bytes
+	default:
+		return v.Str
+	}
+}
+
+// Strings renders every value of the row.
+func (r Row) Strings() []string {
+	out := make([]string, len(r))
+	for i, v := range r {
+		out[i] = v.String()
+	}
+	return out
+}
+
+// unquote strips the single quotes the tokenizer keeps around string literals
+// and turns each doubled quote inside them back into one.
+func unquote(raw string) string {
+	if len(raw) >= 2 && strings.HasPrefix(raw, "'") && strings.HasSuffix(raw, "'") {
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'")
+	}
+	return raw
+}
+
+// ErrDuplicateKey is wrapped by the error for a row whose primary key is already taken.
+var ErrDuplicateKey = errors.New("duplicate primary key value")
+
+// InvalidValueError reports a literal that is not a valid value of its column's type.
+type InvalidValueError struct {
+	Type    catalog.ColumnType
+	Literal string
+}
+
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("invalid %s value %s", e.Type, e.Literal)
+}
+
+// NullLiteral is how the tokenizer spells the NULL keyword. A quoted 'NULL' stays text.
+const NullLiteral = "NULL"
+
+// ParseValue validates a literal from a statement against a column type and converts it.
+func ParseValue(t catalog.ColumnType, raw string) (Value, error) {
+	if raw == NullLiteral {
+		return Value{Type: t, Null: true}, nil
+	}
+	v := unquote(raw)
+	out := Value{Type: t}
+	switch t {
+	case catalog.TypeInteger:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Value{}, &InvalidValueError{t, raw}
+		}
+		out.Int = n
+	case catalog.TypeReal:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) {
+			return Value{}, &InvalidValueError{t, raw}
+		}
+		if f == 0 {
+			f = 0 // fold -0 into 0 so both spell (and index) the same
+		}
+		out.Real = f
+	case catalog.TypeBoolean:
+		switch strings.ToLower(v) {
+		case "true", "t", "1", "yes":
+			out.Bool = true
+		case "false", "f", "0", "no":
+			out.Bool = false
+		default:
+			return Value{}, &InvalidValueError{t, raw}
+		}
+	case catalog.TypeBlob:
+		// blobs are written as x'hex'; anything else is taken as the raw bytes
+		if h, ok := strings.CutPrefix(strings.ToLower(raw), "x'"); ok && strings.HasSuffix(h, "'") {
+			b, err := hex.DecodeString(strings.TrimSuffix(h, "'"))
+			if err != nil {
+				return Value{}, &InvalidValueError{t, raw}
+			}
+			out.Bytes = b
+		} else {
+			out.Bytes = []byte(v)
+		}
+	default:
+		out.Type = catalog.TypeText
+		out.Str = v
+	}
+	return out, nil
+}
+
+// ParseRow converts the literals of an INSERT into a typed row.
+func ParseRow(schema *catalog.TableSchema, values []string) (Row, error) {
+	row := make(Row, len(values))
+	for i, v := range values {
+		val, err := ParseValue(schema.TypeOf(i), v)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", schema.Columns[i], err)
+		}
+		row[i] = val
+	}
+	return row, nil
+}
+
+/*
+Compare orders two non-NULL values of the same type and returns -1, 0 or +1.
+Numbers compare numerically, booleans order false before true, text and blobs
+compare bytewise.
+*/
+func (v Value) Compare(o Value) int {
+	switch v.Type {
+	case catalog.TypeInteger:
+		if o.Type == catalog.TypeReal {
+			return cmp.Compare(float64(v.Int), o.Real)
+		}
+		return cmp.Compare(v.Int, o.Int)
+	case catalog.TypeReal:
+		if o.Type == catalog.TypeInteger {
+			return cmp.Compare(v.Real, float64(o.Int))
+		}
+		return cmp.Compare(v.Real, o.Real)
+	case catalog.TypeBoolean:
+		if v.Bool == o.Bool {
+			return 0
+		} else if v.Bool {
+			return 1
+		}
+		return -1
+	case catalog.TypeBlob:
+		return bytes.Compare(v.Bytes, o.Bytes)
+	default:
+		return strings.Compare(v.Str, o.Str)
+	}
+}
+
+/*
+CompareLiteral compares a stored value with a literal from a WHERE clause, reading
+the literal as the value's type. ok is false when the literal is not a valid value
+of that type or either side is NULL: the comparison is then unknown. An INTEGER
+column may be compared with a fractional literal.
+*/
+func CompareLiteral(v Value, literal string) (result int, ok bool) {
+	lit, err := ParseValue(v.Type, literal)
+	if err != nil && v.Type == catalog.TypeInteger {
+		lit, err = ParseValue(catalog.TypeReal, literal)
+	}
+	if err != nil || v.Null || lit.Null {
+		return 0, false
+	}
+	return v.Compare(lit), true
+}
+
+/*
+EncodeKey turns a value into an index key whose byte order matches Compare:
+integers become big-endian with the sign bit flipped, reals use the usual
+order-preserving float transform, text and blobs are used as is.
+*/
+func EncodeKey(v Value) []byte {
+	switch v.Type {
+	case catalog.TypeInteger:
+		return binary.BigEndian.AppendUint64(nil, uint64(v.Int)^(1<<63))
+	case catalog.TypeReal:
+		bits := math.Float64bits(v.Real)
+		if v.Real < 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return binary.BigEndian.AppendUint64(nil, bits)
+	case catalog.TypeBoolean:
+		if v.Bool {
+			return []byte{1}
+		}
+		return []byte{0}
+	case catalog.TypeBlob:
+		return append([]byte(nil), v.Bytes...)
+	default:
+		return []byte(v.Str)
+	}
+}
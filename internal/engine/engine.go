@@ -0,0 +1,331 @@
+// Engine: runs SQL statements and returns their results instead of printing them.
+// The REPL, the embedded Go API and the servers all execute through a Session.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	tok "github.com/razzat008/letsgodb/internal/Tokenizer"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+)
+
+var (
+	ErrNoDatabase = errors.New("no database selected. Use CREATE DATABASE and USE first.")
+	// ErrNoSuchDatabase is wrapped by errors about a database that does not exist.
+	ErrNoSuchDatabase = errors.New("does not exist")
+	// ErrSyntax is wrapped by every parse error.
+	ErrSyntax = errors.New("syntax error")
+	// ErrInvalidName is wrapped by errors about a database, table or index name that is not a plain file name.
+	ErrInvalidName = errors.New("invalid name")
+)
+
+// Engine owns the databases stored under one data directory.
+// Every database is opened once and shared by the sessions using it.
+// Sessions run statements concurrently; the database layer locks the tables they use.
+type Engine struct {
+	// PoolSize is the page cache of each open database in bytes (0 = storage.DefaultPoolSize).
+	// Set it before the first session uses a database.
+	PoolSize int
+	// SortMemory is how many bytes of rows a sort keeps in memory before spilling them to disk (0 = planner.DefaultSortMemory).
+	SortMemory int
+
+	root string
+	mu   sync.Mutex
+	open map[string]*openDatabase
+	refs int // holders of a shared engine
+}
+
+type openDatabase struct {
+	db   *db.Database
+	refs int // sessions using the database
+}
+
+// New returns an engine for the databases under root, creating the directory if needed.
+func New(root string) (*Engine, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return &Engine{root: root, open: make(map[string]*openDatabase)}, nil
+}
+
+var shared = struct {
+	sync.Mutex
+	engines map[string]*Engine
+}{engines: make(map[string]*Engine)}
+
+/*
+Shared returns the engine for root, creating it on first use. Every caller in
+the process gets the same engine for the same directory, so two handles on a
+database never write its log independently. Call Release when done with it.
+*/
+func Shared(root string) (*Engine, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	shared.Lock()
+	defer shared.Unlock()
+	e, ok := shared.engines[abs]
+	if !ok {
+		if e, err = New(abs); err != nil {
+			return nil, err
+		}
+		shared.engines[abs] = e
+	}
+	e.refs++
+	return e, nil
+}
+
+// Release gives up a reference taken by Shared, closing the engine after the last one.
+func (e *Engine) Release() error {
+	shared.Lock()
+	defer shared.Unlock()
+	if e.refs--; e.refs > 0 {
+		return nil
+	}
+	delete(shared.engines, e.root)
+	return e.Close()
+}
+
+// Root returns the data directory.
+func (e *Engine) Root() string {
+	return e.root
+}
+
+func (e *Engine) dir(name string) string {
+	return filepath.Join(e.root, name)
+}
+
+// acquire opens a database (replaying its log) or returns the already open one.
+func (e *Engine) acquire(name string) (*db.Database, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if o, ok := e.open[name]; ok {
+		o.refs++
+		return o.db, nil
+	}
+	if _, err := os.Stat(filepath.Join(e.dir(name), "catalog.db")); err != nil {
+		return nil, fmt.Errorf("database '%s' %w. Use CREATE DATABASE first.", name, ErrNoSuchDatabase)
+	}
+	d, err := db.OpenDatabaseWith(e.dir(name), db.Options{PoolSize: e.PoolSize})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load catalog for database '%s': %w", name, err)
+	}
+	e.open[name] = &openDatabase{db: d, refs: 1}
+	return d, nil
+}
+
+// release closes a database once no session uses it any more.
+func (e *Engine) release(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	o, ok := e.open[name]
+	if !ok {
+		return nil
+	}
+	if o.refs--; o.refs > 0 {
+		return nil
+	}
+	delete(e.open, name)
+	return o.db.Close()
+}
+
+// dropDatabase deletes a database directory unless a session has the database selected.
+func (e *Engine) dropDatabase(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.open[name]; ok {
+		return fmt.Errorf("cannot drop database '%s': it is in use by another session", name)
+	}
+	dir := e.dir(name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("database '%s' %w", name, ErrNoSuchDatabase)
+	}
+	// Remove the entire database directory and its contents
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to drop database '%s': %w", name, err)
+	}
+	return nil
+}
+
+// Close closes every open database. Sessions should be closed first.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var errs []error
+	for name, o := range e.open {
+		errs = append(errs, o.db.Close())
+		delete(e.open, name)
+	}
+	return errors.Join(errs...)
+}
+
+// Result is what a statement produced: rows for SELECT and the listing
+// statements, a row count for writes and a message for the REPL to print.
+type Result struct {
+	Columns      []string
+	Types        []catalog.ColumnType // type of each column
+	Rows         []db.Row
+	RowsAffected int
+	Message      string
+}
+
+// Session is one connection to the engine with its own current database and transaction.
+type Session struct {
+	engine *Engine
+	name   string
+	db     *db.Database
+	sess   *db.Session
+	rows   *Rows // the query whose rows are still being read, if any
+	shared bool  // the session holds a reference on a shared engine
+}
+
+// NewSession starts a session with no database selected.
+func (e *Engine) NewSession() *Session {
+	return &Session{engine: e}
+}
+
+// CurrentDatabase returns the name of the selected database, "" if none.
+func (s *Session) CurrentDatabase() string {
+	return s.name
+}
+
+// InTransaction reports whether the session has a BEGIN open.
+func (s *Session) InTransaction() bool {
+	return s.sess != nil && s.sess.InTransaction()
+}
+
+// TransactionAborted reports whether the open transaction failed and only awaits ROLLBACK or COMMIT.
+func (s *Session) TransactionAborted() bool {
+	return s.sess != nil && s.sess.Aborted()
+}
+
+// Use selects a database, like USE name;
+func (s *Session) Use(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if s.InTransaction() {
+		return ErrInTransaction
+	}
+	d, err := s.engine.acquire(name)
+	if err != nil {
+		return err
+	}
+	if err := s.leave(); err != nil {
+		s.engine.release(name)
+		return err
+	}
+	s.name, s.db, s.sess = name, d, d.NewSession()
+	return nil
+}
+
+// leave gives up the current database, rolling back an open transaction.
+func (s *Session) leave() error {
+	if s.db == nil {
+		return nil
+	}
+	s.settle()
+	err := errors.Join(s.sess.Close(), s.engine.release(s.name))
+	s.name, s.db, s.sess = "", nil, nil
+	return err
+}
+
+// Close ends the session.
+func (s *Session) Close() error {
+	err := s.leave()
+	if s.shared {
+		s.shared = false
+		err = errors.Join(err, s.engine.Release())
+	}
+	return err
+}
+
+/*
+Connect opens a session on the database stored in dir, creating the database
+if it does not exist. The session runs on the shared engine of dir's parent
+directory, so USE and CREATE DATABASE reach the databases next to it.
+*/
+func Connect(dir string) (*Session, error) {
+	dir = filepath.Clean(dir)
+	eng, err := Shared(filepath.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{engine: eng, shared: true}
+	name := filepath.Base(dir)
+	if _, err := os.Stat(filepath.Join(dir, "catalog.db")); os.IsNotExist(err) {
+		_, err = s.Execute(&par.CreateDatabaseStatement{DatabaseName: name})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if err := s.Use(name); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Exec parses and executes one SQL statement.
+func (s *Session) Exec(sql string) (*Result, error) {
+	stmt, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	return s.Execute(stmt)
+}
+
+// Parse tokenizes and parses one SQL statement; the trailing semicolon is optional.
+func Parse(sql string) (par.Statement, error) {
+	sql = strings.TrimSpace(sql)
+	if !strings.HasSuffix(sql, ";") {
+		sql += ";"
+	}
+	stmt, err := par.Parse(tok.Tokenize(sql))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSyntax, err)
+	}
+	return stmt, nil
+}
+
+// SplitStatements splits a string of ';'-terminated statements, ignoring semicolons inside quotes.
+// Blank statements are dropped.
+func SplitStatements(sql string) []string {
+	var out []string
+	start, quoted := 0, false
+	for i := 0; i < len(sql); i++ {
+		switch sql[i] {
+		case '\'':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				if stmt := strings.TrimSpace(sql[start:i]); stmt != "" {
+					out = append(out, stmt+";")
+				}
+				start = i + 1
+			}
+		}
+	}
+	if stmt := strings.TrimSpace(sql[start:]); stmt != "" {
+		out = append(out, stmt+";")
+	}
+	return out
+}
+
+// validName rejects names that would point outside the directory they are meant for: database
+// names become directories of the data directory, table and index names files of a database.
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w %q", ErrInvalidName, name)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+// SQLState maps an error from Exec or Execute to its PostgreSQL SQLSTATE code,
+// "XX000" (internal_error) when it is none of the known kinds.
+func SQLState(err error) string {
+	var invalid *db.InvalidValueError
+	switch {
+	case errors.Is(err, ErrSyntax):
+		return "42601" // syntax_error
+	case errors.Is(err, ErrInvalidName):
+		return "42602" // invalid_name
+	case errors.Is(err, catalog.ErrNoSuchTable):
+		return "42P01" // undefined_table
+	case errors.Is(err, catalog.ErrNoSuchColumn):
+		return "42703" // undefined_column
+	case errors.Is(err, catalog.ErrNoSuchIndex):
+		return "42704" // undefined_object
+	case errors.Is(err, ErrNoSuchDatabase), errors.Is(err, ErrNoDatabase):
+		return "3D000" // invalid_catalog_name
+	case errors.Is(err, db.ErrDuplicateKey), errors.Is(err, db.ErrDuplicateValue):
+		return "23505" // unique_violation
+	case errors.As(err, &invalid):
+		return "22P02" // invalid_text_representation
+	case errors.Is(err, db.ErrTxnAborted), errors.Is(err, db.ErrTxnRolledBack):
+		return "25P02" // in_failed_sql_transaction
+	case errors.Is(err, db.ErrNoTransaction):
+		return "25P01" // no_active_sql_transaction
+	case errors.Is(err, db.ErrInTransaction), errors.Is(err, ErrInTransaction):
+		return "25001" // active_sql_transaction
+	case errors.Is(err, db.ErrDeadlock):
+		return "40P01" // deadlock_detected
+	case errors.Is(err, db.ErrSerialization):
+		return "40001" // serialization_failure
+	case errors.Is(err, db.ErrLocked):
+		return "55006" // object_in_use
+	case errors.Is(err, storage.ErrCorruptPage):
+		return "XX001" // data_corrupted
+	}
+	return "XX000" // internal_error
+}
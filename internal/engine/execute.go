@@ -0,0 +1,347 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/planner"
+)
+
+// ErrInTransaction is returned for statements that may not run inside a transaction.
+var ErrInTransaction = errors.New("cannot run this statement inside a transaction; COMMIT or ROLLBACK first")
+
+// Execute runs a parsed statement in the session.
+func (s *Session) Execute(stmt par.Statement) (*Result, error) {
+	s.settle()
+	// Schema changes and switching databases are not transactional, so they may not run inside one
+	if s.InTransaction() {
+		switch stmt.(type) {
+		case *par.CreateDatabaseStatement, *par.UseDatabaseStatement, *par.CreateTableStatement, *par.DropStatement,
+			*par.CreateIndexStatement, *par.DropIndexStatement:
+			return nil, ErrInTransaction
+		}
+	}
+
+	switch st := stmt.(type) {
+	case *par.CreateDatabaseStatement:
+		// CREATE DATABASE dbname;
+		if err := validName(st.DatabaseName); err != nil {
+			return nil, err
+		}
+		dbDir := s.engine.dir(st.DatabaseName)
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+		// Create an empty catalog.db if not exists
+		catalogPath := filepath.Join(dbDir, "catalog.db")
+		if _, err := os.Stat(catalogPath); os.IsNotExist(err) {
+			f, ferr := os.Create(catalogPath)
+			if ferr != nil {
+				return nil, fmt.Errorf("failed to create catalog.db: %w", ferr)
+			}
+			f.Close()
+		}
+		return &Result{Message: fmt.Sprintf("Database '%s' created.", st.DatabaseName)}, nil
+	case *par.UseDatabaseStatement:
+		// USE dbname; opening the database replays its write-ahead log
+		if err := s.Use(st.DatabaseName); err != nil {
+			return nil, err
+		}
+		return &Result{Message: fmt.Sprintf("Switched to database '%s'.", st.DatabaseName)}, nil
+	case *par.CreateTableStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := validName(st.TableName); err != nil {
+			return nil, err
+		}
+		types := make([]catalog.ColumnType, len(st.Columns))
+		for i := range st.Columns {
+			t, err := catalog.ParseColumnType(st.Types[i])
+			if err != nil {
+				return nil, fmt.Errorf("CREATE TABLE failed: %w", err)
+			}
+			types[i] = t
+		}
+		if err := s.db.Catalog.AddTypedTable(st.TableName, st.Columns, types); err != nil {
+			return nil, fmt.Errorf("CREATE TABLE failed: %w", err)
+		}
+		return &Result{Message: "Table created: " + st.TableName}, nil
+	case *par.CreateIndexStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := errors.Join(validName(st.Name), validName(st.Table)); err != nil {
+			return nil, err
+		}
+		// The index is filled from the table's rows before CREATE INDEX returns
+		index := catalog.IndexSchema{Name: st.Name, Columns: st.Columns, Unique: st.Unique}
+		if err := s.sess.CreateIndex(st.Table, index); err != nil {
+			return nil, fmt.Errorf("CREATE INDEX failed: %w", err)
+		}
+		return &Result{Message: "Index created: " + st.Name}, nil
+	case *par.DropIndexStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := validName(st.Name); err != nil {
+			return nil, err
+		}
+		if err := s.sess.DropIndex(st.Name); err != nil {
+			return nil, fmt.Errorf("DROP INDEX failed: %w", err)
+		}
+		return &Result{Message: fmt.Sprintf("Index '%s' dropped.", st.Name)}, nil
+	case *par.ListTablesStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		res := &Result{Columns: []string{"table", "columns"}, Types: []catalog.ColumnType{catalog.TypeText, catalog.TypeText}}
+		for _, t := range s.db.Catalog.ListTables() {
+			cols := make([]string, len(t.Columns))
+			for i, col := range t.Columns {
+				cols[i] = col + " " + string(t.TypeOf(i))
+			}
+			res.Rows = append(res.Rows, db.Row{textValue(t.Name), textValue(strings.Join(cols, ", "))})
+		}
+		return res, nil
+	case *par.DropStatement:
+		return s.drop(st)
+	case *par.SelectStatement:
+		return s.query(st)
+	case *par.ExplainStatement:
+		return s.explain(st)
+	case *par.InsertStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		schema := s.db.Catalog.GetTable(st.Table)
+		if schema == nil {
+			return nil, fmt.Errorf("table %q %w", st.Table, catalog.ErrNoSuchTable)
+		}
+		if !db.ColumnsMatch(schema.Columns, st.Columns) {
+			return nil, fmt.Errorf("column mismatch: expected %v, got %v", schema.Columns, st.Columns)
+		}
+		// Flatten [][]string to []string for storage
+		var flatValues []string
+		for _, v := range st.Values {
+			flatValues = append(flatValues, v...)
+		}
+		err := s.withTable(st.Table, db.LockExclusive, func(table *db.Table) error {
+			// The primary key index rejects duplicates before the row is written
+			if err := table.Insert(flatValues); err != nil {
+				return fmt.Errorf("failed to insert row: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Result{RowsAffected: 1, Message: "Row inserted!"}, nil
+	case *par.UpdateStatement:
+		var n int
+		err := s.withTable(st.Table, db.LockExclusive, func(table *db.Table) (err error) {
+			if n, err = table.Update(st.Assignments, st.Where); err != nil {
+				return fmt.Errorf("failed to update rows: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Result{RowsAffected: n, Message: fmt.Sprintf("%d row(s) updated.", n)}, nil
+	case *par.DeleteStatement:
+		var n int
+		err := s.withTable(st.Table, db.LockExclusive, func(table *db.Table) (err error) {
+			if n, err = table.Delete(st.Where); err != nil {
+				return fmt.Errorf("failed to delete rows: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Result{RowsAffected: n, Message: fmt.Sprintf("%d row(s) deleted.", n)}, nil
+	case *par.BeginStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := s.sess.Begin(); err != nil {
+			return nil, err
+		}
+		return &Result{Message: "BEGIN"}, nil
+	case *par.CommitStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := s.sess.Commit(); err != nil {
+			return nil, err
+		}
+		return &Result{Message: "COMMIT"}, nil
+	case *par.RollbackStatement:
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := s.sess.Rollback(); err != nil {
+			return nil, err
+		}
+		return &Result{Message: "ROLLBACK"}, nil
+	case *par.ShowDatabasesStatement:
+		entries, err := os.ReadDir(s.engine.root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list databases: %w", err)
+		}
+		res := &Result{Columns: []string{"database"}, Types: []catalog.ColumnType{catalog.TypeText}}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				res.Rows = append(res.Rows, db.Row{textValue(entry.Name())})
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unsupported statement type")
+}
+
+func textValue(s string) db.Value {
+	return db.Value{Type: catalog.TypeText, Str: s}
+}
+
+// withTable runs fn on a table in the session's transaction (its own one outside BEGIN),
+// holding the table in mode until the transaction ends.
+func (s *Session) withTable(name string, mode db.LockMode, fn func(*db.Table) error) error {
+	if s.db == nil {
+		return ErrNoDatabase
+	}
+	if s.db.Catalog.GetTable(name) == nil {
+		return fmt.Errorf("table %q %w", name, catalog.ErrNoSuchTable)
+	}
+	return s.sess.Run(func() error {
+		table, err := s.sess.OpenTable(name, mode)
+		if err != nil {
+			return fmt.Errorf("failed to open table %q: %w", name, err)
+		}
+		defer table.Close()
+		return fn(table)
+	})
+}
+
+// query runs a SELECT through its plan and collects its rows.
+func (s *Session) query(st *par.SelectStatement) (*Result, error) {
+	rows, err := s.Query(st)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Columns: rows.Columns, Types: rows.Types}
+	for rows.Next() {
+		res.Rows = append(res.Rows, rows.Row())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// explain shows the plan of a SELECT, one line per row; with ANALYZE it runs the query first.
+func (s *Session) explain(st *par.ExplainStatement) (*Result, error) {
+	start := time.Now()
+	op, end, err := s.plan(st.Query)
+	if err != nil {
+		return nil, err
+	}
+	planned := time.Since(start)
+	lines := planner.Explain(op, false)
+	if st.Analyze {
+		start = time.Now()
+		if _, err := planner.Run(op); err != nil {
+			return nil, end(err)
+		}
+		lines = append(planner.Explain(op, true),
+			"Planning Time: "+planner.Millis(planned),
+			"Execution Time: "+planner.Millis(time.Since(start)))
+	}
+	if err := end(nil); err != nil {
+		return nil, err
+	}
+	res := &Result{Columns: []string{"QUERY PLAN"}, Types: []catalog.ColumnType{catalog.TypeText}}
+	for _, line := range lines {
+		res.Rows = append(res.Rows, db.Row{textValue(line)})
+	}
+	return res, nil
+}
+
+/*
+plan starts a statement and builds the physical plan of a SELECT in it,
+opening the tables the plan reads. end closes them and ends the statement
+with the given outcome; it must be called once the plan is done with.
+*/
+func (s *Session) plan(st *par.SelectStatement) (op *planner.Operator, end func(err error) error, err error) {
+	if s.db == nil {
+		return nil, nil, ErrNoDatabase
+	}
+	node, err := planner.Build(st, s.db.Catalog)
+	if err != nil {
+		return nil, nil, err
+	}
+	finish, err := s.sess.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	var tables []*db.Table
+	end = func(err error) error {
+		for _, table := range tables {
+			table.Close()
+		}
+		return finish(err)
+	}
+	opts := planner.Options{SortMemory: s.engine.SortMemory, TempPager: s.db.TempPager}
+	op, err = planner.PlanWith(node, func(name string) (*db.Table, error) {
+		table, err := s.sess.OpenTable(name, db.LockShared)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open table %q: %w", name, err)
+		}
+		tables = append(tables, table)
+		return table, nil
+	}, opts)
+	if err != nil {
+		return nil, nil, end(err)
+	}
+	return op, end, nil
+}
+
+// drop handles DROP TABLE and DROP DATABASE.
+func (s *Session) drop(st *par.DropStatement) (*Result, error) {
+	if st.Table != "" {
+		if s.db == nil {
+			return nil, ErrNoDatabase
+		}
+		if err := validName(st.Table); err != nil {
+			return nil, err
+		}
+		// Remove table from catalog and delete its data and index files
+		if err := s.sess.DropTable(st.Table); err != nil {
+			return nil, fmt.Errorf("DROP TABLE failed: %w", err)
+		}
+		return &Result{Message: fmt.Sprintf("Table '%s' dropped.", st.Table)}, nil
+	}
+
+	if st.Database != "" {
+		if err := validName(st.Database); err != nil {
+			return nil, err
+		}
+		if s.name == st.Database {
+			return nil, fmt.Errorf("cannot drop the currently selected database ('%s'). Switch to another database first.", st.Database)
+		}
+		if err := s.engine.dropDatabase(st.Database); err != nil {
+			return nil, err
+		}
+		return &Result{Message: fmt.Sprintf("Database '%s' dropped.", st.Database)}, nil
+	}
+
+	return nil, fmt.Errorf("DROP: no table or database specified")
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/planner"
+)
+
+/*
+Rows streams the rows of a statement. A SELECT's rows are pulled through its
+plan as Next asks for them, a heap page at a time, inside the statement's
+transaction: it ends, releasing its locks, once the last row has been read or
+Close is called. Starting another statement on the session reads the rest of
+an open query into memory first, so the query can end.
+*/
+type Rows struct {
+	Columns []string
+	Types   []catalog.ColumnType // type of each column
+
+	s   *Session
+	op  *planner.Operator // nil once the statement has ended
+	end func(err error) error
+	buf []db.Row // rows read ahead
+	row db.Row
+	err error
+}
+
+// Query runs a statement and returns its rows. Statements other than SELECT run to completion first.
+func (s *Session) Query(stmt par.Statement) (*Rows, error) {
+	st, ok := stmt.(*par.SelectStatement)
+	if !ok {
+		res, err := s.Execute(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return &Rows{Columns: res.Columns, Types: res.Types, buf: res.Rows}, nil
+	}
+	s.settle()
+	op, end, err := s.plan(st)
+	if err != nil {
+		return nil, err
+	}
+	if err := op.Open(); err != nil {
+		return nil, end(err)
+	}
+	r := &Rows{s: s, op: op, end: end}
+	for _, col := range op.Columns() {
+		r.Columns = append(r.Columns, col.Name)
+		r.Types = append(r.Types, col.Type)
+	}
+	s.rows = r
+	return r, nil
+}
+
+// settle reads the rest of the session's open query into memory and ends its statement.
+func (s *Session) settle() {
+	if r := s.rows; r != nil {
+		for r.op != nil {
+			row, ok, err := r.op.Next()
+			if err != nil || !ok {
+				r.finish(err)
+				break
+			}
+			r.buf = append(r.buf, row)
+		}
+	}
+}
+
+// Next moves to the next row, reporting false at the end or on an error.
+func (r *Rows) Next() bool {
+	if len(r.buf) > 0 {
+		r.row, r.buf = r.buf[0], r.buf[1:]
+		return true
+	}
+	if r.op == nil {
+		return false
+	}
+	row, ok, err := r.op.Next()
+	if err != nil || !ok {
+		r.finish(err)
+		return false
+	}
+	r.row = row
+	return true
+}
+
+// Row returns the row Next moved to.
+func (r *Rows) Row() db.Row {
+	return r.row
+}
+
+// Err returns the error that ended the rows, if any.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close ends the statement, dropping the rows not read yet.
+func (r *Rows) Close() error {
+	r.buf = nil
+	if r.op != nil {
+		r.finish(nil)
+	}
+	return r.err
+}
+
+// finish closes the plan and ends the statement with the outcome err.
+func (r *Rows) finish(err error) {
+	if cerr := r.op.Close(); err == nil {
+		err = cerr
+	}
+	r.err = r.end(err)
+	r.op = nil
+	if r.s.rows == r {
+		r.s.rows = nil
+	}
+}
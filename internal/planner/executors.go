@@ -0,0 +1,414 @@
+package planner
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+)
+
+// scan reads the rows of a table that match where through an access path.
+type scan struct {
+	table  *db.Table
+	path   db.AccessPath
+	where  par.Expr
+	cursor *db.Cursor
+}
+
+func (s *scan) open() error {
+	s.cursor = s.table.Scan(s.path, s.where)
+	return nil
+}
+
+func (s *scan) next() (db.Row, bool, error) {
+	if s.cursor.Next() {
+		return s.cursor.Row(), true, nil
+	}
+	if err := s.cursor.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read table %q: %w", s.table.Schema.Name, err)
+	}
+	return nil, false, nil
+}
+
+func (s *scan) close() error {
+	if s.cursor == nil {
+		return nil
+	}
+	return s.cursor.Close()
+}
+
+func (s *scan) describe() (string, []string) {
+	var details []string
+	if s.path.Cond != "" {
+		details = append(details, "Index Cond: "+s.path.Cond)
+	}
+	if s.where != nil && par.FormatExpr(s.where) != s.path.Cond {
+		details = append(details, "Filter: "+par.FormatExpr(s.where))
+	}
+	if s.path.Index == "" {
+		return "Seq Scan on " + s.table.Schema.Name, details
+	}
+	return fmt.Sprintf("Index Scan using %s on %s", s.path.Index, s.table.Schema.Name), details
+}
+
+type filter struct {
+	input  *Operator
+	where  par.Expr
+	schema *catalog.TableSchema
+}
+
+func (f *filter) open() error  { return nil }
+func (f *filter) close() error { return nil }
+
+func (f *filter) next() (db.Row, bool, error) {
+	for {
+		row, ok, err := f.input.Next()
+		if err != nil || !ok || db.EvalWhere(f.where, f.schema, row) {
+			return row, ok, err
+		}
+	}
+}
+
+func (f *filter) describe() (string, []string) {
+	return "Filter", []string{"Filter: " + par.FormatExpr(f.where)}
+}
+
+type project struct {
+	input *Operator
+	idx   []int // input column of each output column
+	names []string
+}
+
+func (p *project) open() error  { return nil }
+func (p *project) close() error { return nil }
+
+func (p *project) next() (db.Row, bool, error) {
+	row, ok, err := p.input.Next()
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	out := make(db.Row, len(p.idx))
+	for i, j := range p.idx {
+		out[i] = row[j]
+	}
+	return out, true, nil
+}
+
+func (p *project) describe() (string, []string) {
+	return "Project", []string{"Output: " + strings.Join(p.names, ", ")}
+}
+
+// compareValues orders two values of a column, NULLs last.
+func compareValues(a, b db.Value) int {
+	if a.Null || b.Null {
+		return cmp.Compare(boolInt(a.Null), boolInt(b.Null))
+	}
+	return a.Compare(b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// drain reads every remaining row of an open operator.
+func drain(op *Operator) ([]db.Row, error) {
+	var rows []db.Row
+	for {
+		row, ok, err := op.Next()
+		if err != nil || !ok {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// limit passes on count rows after skipping offset, and then stops reading its input.
+type limit struct {
+	input         *Operator
+	count, offset int64
+	seen          int64 // rows read from the input
+}
+
+func (l *limit) open() error  { return nil }
+func (l *limit) close() error { return nil }
+
+func (l *limit) next() (db.Row, bool, error) {
+	for {
+		if l.count >= 0 && l.seen-l.offset >= l.count {
+			return nil, false, nil
+		}
+		row, ok, err := l.input.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		l.seen++
+		if l.seen > l.offset {
+			return row, true, nil
+		}
+	}
+}
+
+func (l *limit) describe() (string, []string) {
+	var details []string
+	if l.count >= 0 {
+		details = append(details, fmt.Sprintf("Count: %d", l.count))
+	}
+	if l.offset > 0 {
+		details = append(details, fmt.Sprintf("Offset: %d", l.offset))
+	}
+	return "Limit", details
+}
+
+// nestedLoop pairs every left row with every right row; the right rows are read into memory first.
+type nestedLoop struct {
+	left, right *Operator
+	inner       []db.Row
+	outer       db.Row
+	pos         int
+}
+
+func (j *nestedLoop) open() (err error) {
+	j.inner, err = drain(j.right)
+	j.pos = len(j.inner)
+	return err
+}
+
+func (j *nestedLoop) next() (db.Row, bool, error) {
+	for j.pos == len(j.inner) {
+		if len(j.inner) == 0 {
+			return nil, false, nil
+		}
+		row, ok, err := j.left.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		j.outer, j.pos = row, 0
+	}
+	j.pos++
+	return append(slices.Clip(j.outer), j.inner[j.pos-1]...), true, nil
+}
+
+func (j *nestedLoop) close() error {
+	j.inner = nil
+	return nil
+}
+
+func (j *nestedLoop) describe() (string, []string) { return "Nested Loop", nil }
+
+// hashJoin builds a hash table of the right rows by key, then streams the left rows through it.
+type hashJoin struct {
+	left, right         *Operator
+	leftKeys, rightKeys []int
+	names               *Join
+	table               map[string][]db.Row
+	outer               db.Row
+	matches             []db.Row // right rows still to pair with outer
+}
+
+func (j *hashJoin) open() error {
+	right, err := drain(j.right)
+	if err != nil {
+		return err
+	}
+	j.table = make(map[string][]db.Row)
+	for _, r := range right {
+		if key, ok := groupKey(r, j.rightKeys); ok {
+			j.table[key] = append(j.table[key], r)
+		}
+	}
+	return nil
+}
+
+func (j *hashJoin) next() (db.Row, bool, error) {
+	for len(j.matches) == 0 {
+		row, ok, err := j.left.Next()
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		if key, ok := groupKey(row, j.leftKeys); ok {
+			j.outer, j.matches = row, j.table[key]
+		}
+	}
+	r := j.matches[0]
+	j.matches = j.matches[1:]
+	return append(slices.Clip(j.outer), r...), true, nil
+}
+
+func (j *hashJoin) close() error {
+	j.table, j.matches = nil, nil
+	return nil
+}
+
+func (j *hashJoin) describe() (string, []string) {
+	conds := make([]string, len(j.names.LeftKeys))
+	for i := range conds {
+		conds[i] = j.names.LeftKeys[i] + " = " + j.names.RightKeys[i]
+	}
+	return "Hash Join", []string{"Hash Cond: " + strings.Join(conds, " AND ")}
+}
+
+/*
+groupKey encodes the values of cols in row as a map key; ok is false if one
+is NULL, which matches nothing in a join. Each value is length-prefixed, so
+different values never give the same key.
+*/
+func groupKey(row db.Row, cols []int) (key string, ok bool) {
+	var b []byte
+	ok = true
+	for _, col := range cols {
+		if row[col].Null {
+			ok = false
+			b = append(b, 0)
+			continue
+		}
+		enc := db.EncodeKey(row[col])
+		b = binary.AppendUvarint(append(b, 1), uint64(len(enc)))
+		b = append(b, enc...)
+	}
+	return string(b), ok
+}
+
+// hashAggregate groups its whole input in a hash table, then yields the groups in the order they were first seen.
+type hashAggregate struct {
+	input   *Operator
+	group   []int // input columns grouped by
+	aggs    []Agg
+	aggCols []int    // input column of each aggregate, -1 for COUNT(*)
+	types   []Column // output column of each aggregate
+	names   []string
+	out     []db.Row
+}
+
+// accumulator is the running state of one aggregate in one group.
+type accumulator struct {
+	count    int64
+	sum      float64
+	intSum   int64
+	min, max db.Value
+}
+
+func (a *hashAggregate) open() error {
+	index := make(map[string]int)
+	var keys []db.Row
+	var accs [][]accumulator
+	for {
+		row, ok, err := a.input.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		key, _ := groupKey(row, a.group)
+		g, found := index[key]
+		if !found {
+			g = len(keys)
+			index[key] = g
+			group := make(db.Row, len(a.group))
+			for i, col := range a.group {
+				group[i] = row[col]
+			}
+			keys = append(keys, group)
+			accs = append(accs, make([]accumulator, len(a.aggs)))
+		}
+		for i, col := range a.aggCols {
+			accs[g][i].add(row, col)
+		}
+	}
+	// without GROUP BY there is one group even when there are no rows
+	if len(keys) == 0 && len(a.group) == 0 {
+		keys, accs = []db.Row{{}}, [][]accumulator{make([]accumulator, len(a.aggs))}
+	}
+	a.out = make([]db.Row, len(keys))
+	for g, key := range keys {
+		row := slices.Clip(key)
+		for i, agg := range a.aggs {
+			row = append(row, accs[g][i].result(agg, a.types[i].Type))
+		}
+		a.out[g] = row
+	}
+	return nil
+}
+
+func (acc *accumulator) add(row db.Row, col int) {
+	if col == -1 {
+		acc.count++
+		return
+	}
+	v := row[col]
+	if v.Null {
+		return
+	}
+	acc.count++
+	acc.sum += number(v)
+	acc.intSum += v.Int
+	if acc.count == 1 || compareValues(v, acc.min) < 0 {
+		acc.min = v
+	}
+	if acc.count == 1 || compareValues(v, acc.max) > 0 {
+		acc.max = v
+	}
+}
+
+func (acc *accumulator) result(agg Agg, typ catalog.ColumnType) db.Value {
+	if agg.Func == "COUNT" {
+		return db.Value{Type: catalog.TypeInteger, Int: acc.count}
+	}
+	if acc.count == 0 {
+		return db.Value{Type: typ, Null: true}
+	}
+	switch agg.Func {
+	case "SUM":
+		if typ == catalog.TypeInteger {
+			return db.Value{Type: typ, Int: acc.intSum}
+		}
+		return db.Value{Type: typ, Real: acc.sum}
+	case "AVG":
+		return db.Value{Type: catalog.TypeReal, Real: acc.sum / float64(acc.count)}
+	case "MIN":
+		return acc.min
+	}
+	return acc.max
+}
+
+// number returns a numeric value as a float64 (0 for other types).
+func number(v db.Value) float64 {
+	if v.Type == catalog.TypeInteger {
+		return float64(v.Int)
+	}
+	return v.Real
+}
+
+func (a *hashAggregate) next() (db.Row, bool, error) {
+	if len(a.out) == 0 {
+		return nil, false, nil
+	}
+	row := a.out[0]
+	a.out = a.out[1:]
+	return row, true, nil
+}
+
+func (a *hashAggregate) close() error {
+	a.out = nil
+	return nil
+}
+
+func (a *hashAggregate) describe() (string, []string) {
+	aggs := make([]string, len(a.aggs))
+	for i, agg := range a.aggs {
+		aggs[i] = agg.String()
+	}
+	details := []string{"Aggregates: " + strings.Join(aggs, ", ")}
+	if len(a.names) > 0 {
+		details = append([]string{"Group Key: " + strings.Join(a.names, ", ")}, details...)
+	}
+	return "HashAggregate", details
+}
@@ -0,0 +1,53 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Explain renders a physical plan the way PostgreSQL does, one line per
+operator with its inputs indented below it:
+
+	Project  (cost=4.35 rows=2)
+	  Output: id, email
+	  ->  Index Scan using by_age on users  (cost=4.35 rows=2)
+	        Index Cond: age = 3
+
+With analyze each operator also shows the rows it yielded and the time spent
+in it, its inputs included; the plan must have been run first.
+*/
+func Explain(op *Operator, analyze bool) []string {
+	var lines []string
+	var walk func(op *Operator, depth int)
+	walk = func(op *Operator, depth int) {
+		label, details := op.exec.describe()
+		rows, cost := op.Estimate()
+		line := fmt.Sprintf("%s  (cost=%.2f rows=%.0f)", label, cost, rows)
+		if analyze {
+			if n, elapsed, ran := op.Actual(); ran {
+				line += fmt.Sprintf(" (actual time=%s rows=%d)", Millis(elapsed), n)
+			} else {
+				line += " (never executed)"
+			}
+		}
+		if depth > 0 {
+			line = strings.Repeat(" ", 6*(depth-1)) + "  ->  " + line
+		}
+		lines = append(lines, line)
+		for _, d := range details {
+			lines = append(lines, strings.Repeat(" ", 6*depth+2)+d)
+		}
+		for _, in := range op.Inputs() {
+			walk(in, depth+1)
+		}
+	}
+	walk(op, 0)
+	return lines
+}
+
+// Millis formats a duration in milliseconds, e.g. "0.042 ms".
+func Millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+}
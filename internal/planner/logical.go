@@ -0,0 +1,264 @@
+/*
+Package planner turns a SELECT into a plan. The logical plan says what the
+query computes, as a tree of relational operators; the physical plan says
+how, picking an operator for each and a way to read each table (see
+db.Table.AccessPaths). The parser only produces scans, filters, sorts,
+limits and projections so far; joins and aggregates can be planned but not
+yet written in SQL.
+*/
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+)
+
+// Column is one column of the rows an operator produces.
+type Column struct {
+	Name string
+	Type catalog.ColumnType
+}
+
+// Node is an operator of a logical plan.
+type Node interface {
+	Output() []Column
+	Inputs() []Node
+}
+
+// Scan reads every row of a table.
+type Scan struct {
+	Table *catalog.TableSchema
+}
+
+// Filter keeps the rows Where is true for.
+type Filter struct {
+	Input Node
+	Where par.Expr
+}
+
+// Project keeps the named columns, in that order.
+type Project struct {
+	Input   Node
+	Columns []string
+}
+
+// Sort orders rows by Keys, the first key first.
+type Sort struct {
+	Input Node
+	Keys  []SortKey
+}
+
+// SortKey is a column to sort by.
+type SortKey struct {
+	Column     string
+	Desc       bool
+	NullsFirst bool // NULLs come before every value, whichever the direction
+}
+
+// Limit skips Offset rows and then passes on at most Count (-1 = all the rest).
+type Limit struct {
+	Input  Node
+	Count  int64
+	Offset int64
+}
+
+/*
+Join pairs each row of Left with the rows of Right whose RightKeys equal its
+LeftKeys, or with every row of Right when there are no keys. The joined row
+is the left row followed by the right one; a column name found on both sides
+is qualified with its table on each, as in "users.id".
+*/
+type Join struct {
+	Left, Right         Node
+	LeftKeys, RightKeys []string
+}
+
+// Aggregate groups rows by the GroupBy columns and computes Aggs over each group, yielding one row per group.
+type Aggregate struct {
+	Input   Node
+	GroupBy []string
+	Aggs    []Agg
+}
+
+// Agg is an aggregate function of a column: COUNT, SUM, AVG, MIN or MAX. COUNT also takes "*".
+type Agg struct {
+	Func   string
+	Column string
+}
+
+func (a Agg) String() string { return fmt.Sprintf("%s(%s)", a.Func, a.Column) }
+
+func (s *Scan) Output() []Column {
+	cols := make([]Column, len(s.Table.Columns))
+	for i, name := range s.Table.Columns {
+		cols[i] = Column{Name: name, Type: s.Table.TypeOf(i)}
+	}
+	return cols
+}
+
+func (f *Filter) Output() []Column { return f.Input.Output() }
+func (s *Sort) Output() []Column   { return s.Input.Output() }
+func (l *Limit) Output() []Column  { return l.Input.Output() }
+
+func (p *Project) Output() []Column {
+	in := p.Input.Output()
+	cols := make([]Column, len(p.Columns))
+	for i, name := range p.Columns {
+		cols[i] = in[columnIndex(in, name)]
+	}
+	return cols
+}
+
+func (j *Join) Output() []Column {
+	left, right := j.Left.Output(), j.Right.Output()
+	cols := append(slices.Clone(left), right...)
+	for i := range cols {
+		if i < len(left) && columnIndex(right, cols[i].Name) != -1 {
+			cols[i].Name = tableOf(j.Left) + "." + cols[i].Name
+		} else if i >= len(left) && columnIndex(left, cols[i].Name) != -1 {
+			cols[i].Name = tableOf(j.Right) + "." + cols[i].Name
+		}
+	}
+	return cols
+}
+
+// tableOf names the table a join input reads, if it reads just one.
+func tableOf(n Node) string {
+	for n != nil {
+		if s, ok := n.(*Scan); ok {
+			return s.Table.Name
+		}
+		inputs := n.Inputs()
+		if len(inputs) != 1 {
+			break
+		}
+		n = inputs[0]
+	}
+	return "?"
+}
+
+func (a *Aggregate) Output() []Column {
+	in := a.Input.Output()
+	var cols []Column
+	for _, name := range a.GroupBy {
+		cols = append(cols, in[columnIndex(in, name)])
+	}
+	for _, agg := range a.Aggs {
+		typ := catalog.TypeInteger
+		switch agg.Func {
+		case "AVG":
+			typ = catalog.TypeReal
+		case "SUM", "MIN", "MAX":
+			typ = in[columnIndex(in, agg.Column)].Type
+		}
+		cols = append(cols, Column{Name: agg.String(), Type: typ})
+	}
+	return cols
+}
+
+func (s *Scan) Inputs() []Node      { return nil }
+func (f *Filter) Inputs() []Node    { return []Node{f.Input} }
+func (p *Project) Inputs() []Node   { return []Node{p.Input} }
+func (s *Sort) Inputs() []Node      { return []Node{s.Input} }
+func (l *Limit) Inputs() []Node     { return []Node{l.Input} }
+func (j *Join) Inputs() []Node      { return []Node{j.Left, j.Right} }
+func (a *Aggregate) Inputs() []Node { return []Node{a.Input} }
+
+// columnIndex returns the position of the named column, or -1.
+func columnIndex(cols []Column, name string) int {
+	return slices.IndexFunc(cols, func(c Column) bool { return c.Name == name })
+}
+
+// Build turns a SELECT into a logical plan, checking its table and columns against the catalog.
+func Build(st *par.SelectStatement, cat *catalog.Catalog) (Node, error) {
+	schema := cat.GetTable(st.Table)
+	if schema == nil {
+		return nil, fmt.Errorf("table %q %w", st.Table, catalog.ErrNoSuchTable)
+	}
+	var n Node = &Scan{Table: schema}
+	if st.Where != nil {
+		n = &Filter{Input: n, Where: st.Where}
+	}
+	// sort before projecting, so rows can be ordered by columns the SELECT leaves out
+	if len(st.OrderBy) > 0 {
+		sort := &Sort{Input: n}
+		for _, item := range st.OrderBy {
+			if schema.ColumnIndex(item.Column) == -1 {
+				return nil, fmt.Errorf("column %q %w in table %q", item.Column, catalog.ErrNoSuchColumn, st.Table)
+			}
+			sort.Keys = append(sort.Keys, SortKey{Column: item.Column, Desc: item.Desc, NullsFirst: item.NullsFirst})
+		}
+		n = sort
+	}
+	if st.Limit != nil {
+		n = &Limit{Input: n, Count: *st.Limit, Offset: st.Offset}
+	} else if st.Offset > 0 {
+		n = &Limit{Input: n, Count: -1, Offset: st.Offset}
+	}
+	if len(st.Columns) == 1 && st.Columns[0] == "*" {
+		return n, nil
+	}
+	for _, col := range st.Columns {
+		if schema.ColumnIndex(col) == -1 {
+			return nil, fmt.Errorf("column %q %w in table %q", col, catalog.ErrNoSuchColumn, st.Table)
+		}
+	}
+	return &Project{Input: n, Columns: st.Columns}, nil
+}
+
+// Check makes sure every column a plan names exists in the rows it is applied to.
+func Check(n Node) error {
+	for _, in := range n.Inputs() {
+		if err := Check(in); err != nil {
+			return err
+		}
+	}
+	need := func(cols []Column, names ...string) error {
+		for _, name := range names {
+			if name != "*" && columnIndex(cols, name) == -1 {
+				return fmt.Errorf("column %q %w", name, catalog.ErrNoSuchColumn)
+			}
+		}
+		return nil
+	}
+	switch n := n.(type) {
+	case *Project:
+		return need(n.Input.Output(), n.Columns...)
+	case *Sort:
+		for _, k := range n.Keys {
+			if err := need(n.Input.Output(), k.Column); err != nil {
+				return err
+			}
+		}
+	case *Join:
+		if len(n.LeftKeys) != len(n.RightKeys) {
+			return fmt.Errorf("join has %d left keys but %d right keys", len(n.LeftKeys), len(n.RightKeys))
+		}
+		if err := need(n.Left.Output(), n.LeftKeys...); err != nil {
+			return err
+		}
+		return need(n.Right.Output(), n.RightKeys...)
+	case *Aggregate:
+		if err := need(n.Input.Output(), n.GroupBy...); err != nil {
+			return err
+		}
+		for _, agg := range n.Aggs {
+			switch agg.Func {
+			case "COUNT":
+			case "SUM", "AVG", "MIN", "MAX":
+				if agg.Column == "*" {
+					return fmt.Errorf("%s needs a column", agg)
+				}
+			default:
+				return fmt.Errorf("unknown aggregate function %s", agg.Func)
+			}
+			if err := need(n.Input.Output(), agg.Column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
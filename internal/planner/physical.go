@@ -0,0 +1,264 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+/*
+Estimates above the scans are guesses in the same spirit as db's: a filter
+keeps a third of its rows, an equi-join yields as many rows as its larger
+side, and grouping leaves a tenth. Costs add a charge per row handled to the
+cost of the inputs, in the units of db.AccessPath.Cost.
+*/
+const (
+	cpuRowCost        = 0.1
+	filterSelectivity = 1.0 / 3
+	groupsPerRow      = 0.1
+)
+
+/*
+Operator is a node of a physical plan. Rows are pulled through the plan one
+at a time: Open the root, call Next until it reports no more rows, then
+Close it; each operator does the same with its inputs. Only the operators
+that must see every row first (Sort, HashAggregate, and the inner side of a
+join) hold rows, a Sort spilling them to disk past its memory budget; the
+rest pass each on as soon as it is read, so a scan holds one heap page at a
+time.
+*/
+type Operator struct {
+	exec   executor
+	inputs []*Operator
+	cols   []Column
+
+	estRows, cost float64
+	rows          int
+	elapsed       time.Duration
+	ran           bool
+}
+
+// executor is what an operator does; Operator runs it and keeps count.
+type executor interface {
+	open() error // called once the inputs are open
+	next() (db.Row, bool, error)
+	close() error
+	describe() (label string, details []string)
+}
+
+func newOperator(exec executor, cols []Column, rows, cost float64, inputs ...*Operator) *Operator {
+	return &Operator{exec: exec, inputs: inputs, cols: cols, estRows: rows, cost: cost}
+}
+
+// Columns describes the rows the operator yields.
+func (op *Operator) Columns() []Column { return op.cols }
+
+// Inputs returns the operators op reads from.
+func (op *Operator) Inputs() []*Operator { return op.inputs }
+
+// Estimate returns the rows the operator is expected to yield and the cost of the plan up to it.
+func (op *Operator) Estimate() (rows, cost float64) { return op.estRows, op.cost }
+
+// Actual returns the rows the operator yielded and the time spent in it, its inputs included; ran is false until it was opened.
+func (op *Operator) Actual() (rows int, elapsed time.Duration, ran bool) {
+	return op.rows, op.elapsed, op.ran
+}
+
+// Open opens the operator's inputs and then the operator.
+// On failure it closes whatever it opened, so the caller has nothing to close.
+func (op *Operator) Open() error {
+	start := time.Now()
+	defer func() { op.elapsed += time.Since(start) }()
+	op.ran = true
+	for i, in := range op.inputs {
+		if err := in.Open(); err != nil {
+			for _, opened := range op.inputs[:i] {
+				opened.Close()
+			}
+			return err
+		}
+	}
+	if err := op.exec.open(); err != nil {
+		op.Close()
+		return err
+	}
+	return nil
+}
+
+// Next returns the next row; ok is false when there are no more.
+func (op *Operator) Next() (row db.Row, ok bool, err error) {
+	start := time.Now()
+	row, ok, err = op.exec.next()
+	op.elapsed += time.Since(start)
+	if ok {
+		op.rows++
+	}
+	return row, ok, err
+}
+
+// Close closes the operator and then its inputs.
+func (op *Operator) Close() error {
+	err := op.exec.close()
+	for _, in := range op.inputs {
+		if cerr := in.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Run executes a plan and returns all its rows.
+func Run(op *Operator) ([]db.Row, error) {
+	if err := op.Open(); err != nil {
+		return nil, err
+	}
+	var rows []db.Row
+	for {
+		row, ok, err := op.Next()
+		if err != nil || !ok {
+			if cerr := op.Close(); err == nil {
+				err = cerr
+			}
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Options tune how a plan runs.
+type Options struct {
+	SortMemory int                            // bytes of rows a sort keeps in memory; 0 means DefaultSortMemory
+	TempPager  func() (*storage.Pager, error) // creates the file a sort writes its runs to; nil keeps every sort in memory
+}
+
+// Plan chooses an operator for each node of a logical plan with the default options.
+func Plan(n Node, open func(table string) (*db.Table, error)) (*Operator, error) {
+	return PlanWith(n, open, Options{})
+}
+
+// PlanWith chooses an operator for each node of a logical plan; open supplies the tables it reads, opened for the statement.
+func PlanWith(n Node, open func(table string) (*db.Table, error), opts Options) (*Operator, error) {
+	if err := Check(n); err != nil {
+		return nil, err
+	}
+	if opts.SortMemory == 0 {
+		opts.SortMemory = DefaultSortMemory
+	}
+	return plan(n, open, opts)
+}
+
+func plan(n Node, open func(table string) (*db.Table, error), opts Options) (*Operator, error) {
+	if f, ok := n.(*Filter); ok {
+		if s, ok := f.Input.(*Scan); ok {
+			return planScan(s, f.Where, open)
+		}
+	}
+	if s, ok := n.(*Scan); ok {
+		return planScan(s, nil, open)
+	}
+	var inputs []*Operator
+	for _, in := range n.Inputs() {
+		op, err := plan(in, open, opts)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, op)
+	}
+	in := inputs[0]
+	rows, cost := in.Estimate()
+	cols := n.Output()
+	switch n := n.(type) {
+	case *Filter:
+		exec := &filter{input: in, where: n.Where, schema: schemaOf(in.Columns())}
+		return newOperator(exec, cols, rows*filterSelectivity, cost+rows*cpuRowCost, in), nil
+	case *Project:
+		exec := &project{input: in, names: n.Columns}
+		for _, name := range n.Columns {
+			exec.idx = append(exec.idx, columnIndex(in.Columns(), name))
+		}
+		return newOperator(exec, cols, rows, cost, in), nil
+	case *Sort:
+		exec := &sortOp{input: in, names: n.Keys, memory: opts.SortMemory, temp: opts.TempPager, schema: schemaOf(cols), bound: -1}
+		for _, k := range n.Keys {
+			exec.keys = append(exec.keys, sortKey{col: columnIndex(cols, k.Column), desc: k.Desc, nullsFirst: k.NullsFirst})
+		}
+		return newOperator(exec, cols, rows, cost+rows*math.Log2(max(rows, 2))*cpuRowCost, in), nil
+	case *Limit:
+		// a sort only has to keep the rows the limit passes on
+		if sort, ok := in.exec.(*sortOp); ok && n.Count >= 0 && n.Offset+n.Count >= 0 {
+			sort.bound = n.Offset + n.Count
+			sortRows, sortCost := in.inputs[0].Estimate()
+			in.cost = sortCost + sortRows*math.Log2(max(min(sortRows, float64(sort.bound)), 2))*cpuRowCost
+			cost = in.cost
+		}
+		exec := &limit{input: in, count: n.Count, offset: n.Offset}
+		out := max(rows-float64(n.Offset), 0)
+		if n.Count >= 0 {
+			out = min(out, float64(n.Count))
+		}
+		return newOperator(exec, cols, out, cost, in), nil
+	case *Join:
+		right := inputs[1]
+		rrows, rcost := right.Estimate()
+		if len(n.LeftKeys) == 0 {
+			exec := &nestedLoop{left: in, right: right}
+			return newOperator(exec, cols, rows*rrows, cost+rcost+rows*rrows*cpuRowCost, in, right), nil
+		}
+		exec := &hashJoin{left: in, right: right, names: n}
+		for i := range n.LeftKeys {
+			exec.leftKeys = append(exec.leftKeys, columnIndex(in.Columns(), n.LeftKeys[i]))
+			exec.rightKeys = append(exec.rightKeys, columnIndex(right.Columns(), n.RightKeys[i]))
+		}
+		return newOperator(exec, cols, max(rows, rrows), cost+rcost+(rows+rrows)*cpuRowCost, in, right), nil
+	case *Aggregate:
+		exec := &hashAggregate{input: in, aggs: n.Aggs, names: n.GroupBy, types: cols[len(n.GroupBy):]}
+		for _, name := range n.GroupBy {
+			exec.group = append(exec.group, columnIndex(in.Columns(), name))
+		}
+		for _, agg := range n.Aggs {
+			exec.aggCols = append(exec.aggCols, columnIndex(in.Columns(), agg.Column))
+		}
+		groups := 1.0
+		if len(n.GroupBy) > 0 {
+			groups = max(rows*groupsPerRow, 1)
+		}
+		return newOperator(exec, cols, groups, cost+rows*cpuRowCost, in), nil
+	}
+	return nil, fmt.Errorf("cannot plan %T", n)
+}
+
+// planScan reads a table the cheapest way, checking where (nil = all rows) as it goes.
+func planScan(s *Scan, where par.Expr, open func(table string) (*db.Table, error)) (*Operator, error) {
+	table, err := open(s.Table.Name)
+	if err != nil {
+		return nil, err
+	}
+	path, err := table.BestPath(where)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table %q: %w", s.Table.Name, err)
+	}
+	st, err := table.Stats()
+	if err != nil {
+		return nil, err
+	}
+	sel, err := table.Selectivity(where)
+	if err != nil {
+		return nil, err
+	}
+	return newOperator(&scan{table: table, path: path, where: where}, s.Output(), st.Rows*sel, path.Cost), nil
+}
+
+// schemaOf describes rows with the given columns, for EvalWhere.
+func schemaOf(cols []Column) *catalog.TableSchema {
+	schema := &catalog.TableSchema{}
+	for _, c := range cols {
+		schema.Columns = append(schema.Columns, c.Name)
+		schema.Types = append(schema.Types, c.Type)
+	}
+	return schema
+}
@@ -0,0 +1,293 @@
+package planner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	tok "github.com/razzat008/letsgodb/internal/Tokenizer"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+)
+
+// testDB creates users(id, age, name) with 200 rows and an index on age, and orders(id, user_id, total) with 3 orders for each of the first 10 users.
+func testDB(t *testing.T) (*db.Database, *db.Session) {
+	t.Helper()
+	d, err := db.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	err = d.Catalog.AddTypedTable("users", []string{"id", "age", "name"},
+		[]catalog.ColumnType{catalog.TypeInteger, catalog.TypeInteger, catalog.TypeText})
+	if err == nil {
+		err = d.Catalog.AddTypedTable("orders", []string{"id", "user_id", "total"},
+			[]catalog.ColumnType{catalog.TypeInteger, catalog.TypeInteger, catalog.TypeReal})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := d.NewSession()
+	insert := func(table string, values ...string) {
+		err := s.Run(func() error {
+			tbl, err := s.OpenTable(table, db.LockExclusive)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			return tbl.Insert(values)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		insert("users", fmt.Sprint(i), fmt.Sprint(i%20), fmt.Sprintf("'user%d'", i))
+	}
+	for i := 0; i < 30; i++ {
+		insert("orders", fmt.Sprint(i), fmt.Sprint(i/3), fmt.Sprintf("%d.5", i))
+	}
+	if err := s.CreateIndex("users", catalog.IndexSchema{Name: "by_age", Columns: []string{"age"}}); err != nil {
+		t.Fatal(err)
+	}
+	return d, s
+}
+
+// run plans and runs n, returning the plan's EXPLAIN ANALYZE lines and its rows as strings.
+func run(t *testing.T, s *db.Session, n Node) (plan []string, rows []string) {
+	t.Helper()
+	return runWith(t, s, n, Options{})
+}
+
+func runWith(t *testing.T, s *db.Session, n Node, opts Options) (plan []string, rows []string) {
+	t.Helper()
+	err := s.Run(func() error {
+		var tables []*db.Table
+		defer func() {
+			for _, tbl := range tables {
+				tbl.Close()
+			}
+		}()
+		op, err := PlanWith(n, func(name string) (*db.Table, error) {
+			tbl, err := s.OpenTable(name, db.LockShared)
+			if err == nil {
+				tables = append(tables, tbl)
+			}
+			return tbl, err
+		}, opts)
+		if err != nil {
+			return err
+		}
+		out, err := Run(op)
+		for _, row := range out {
+			rows = append(rows, strings.Join(row.Strings(), ","))
+		}
+		plan = Explain(op, true)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan, rows
+}
+
+func TestAccessPaths(t *testing.T) {
+	d, s := testDB(t)
+	cases := []struct {
+		where string
+		scan  string // first line of the plan
+		rows  int
+	}{
+		{"", "Seq Scan on users", 200},
+		{"id = 7", "Index Scan using pk on users", 1},
+		{"age = 3 AND name = 'user3'", "Index Scan using by_age on users", 1},
+		{"age > 17", "Index Scan using by_age on users", 20},
+		{"age != 3", "Seq Scan on users", 190},
+		{"age = 3 OR id = 4", "Seq Scan on users", 11},
+	}
+	for _, c := range cases {
+		sql := "SELECT * FROM users"
+		if c.where != "" {
+			sql += " WHERE " + c.where
+		}
+		stmt, err := par.Parse(tok.Tokenize(sql + ";"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := Build(stmt.(*par.SelectStatement), d.Catalog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, rows := run(t, s, n)
+		if !strings.HasPrefix(plan[0], c.scan+"  (") || len(rows) != c.rows {
+			t.Errorf("%s: got %d rows from\n%s\nwant %d rows from %s", sql, len(rows), strings.Join(plan, "\n"), c.rows, c.scan)
+		}
+		if !strings.Contains(plan[0], fmt.Sprintf("rows=%d)", c.rows)) {
+			t.Errorf("%s: actual rows missing from %q", sql, plan[0])
+		}
+	}
+
+	if _, err := Build(&par.SelectStatement{Columns: []string{"nope"}, Table: "users"}, d.Catalog); err == nil {
+		t.Errorf("Build with an unknown column: no error")
+	}
+}
+
+func TestJoinAggregate(t *testing.T) {
+	d, s := testDB(t)
+	users := &Scan{Table: d.Catalog.GetTable("users")}
+	orders := &Scan{Table: d.Catalog.GetTable("orders")}
+
+	// orders per user, for users under 5, biggest spender first
+	n := &Limit{Count: 3, Offset: 1, Input: &Sort{
+		Keys: []SortKey{{Column: "SUM(total)", Desc: true}},
+		Input: &Aggregate{
+			GroupBy: []string{"name"},
+			Aggs:    []Agg{{Func: "COUNT", Column: "*"}, {Func: "SUM", Column: "total"}, {Func: "MAX", Column: "orders.id"}},
+			Input: &Join{
+				Left:     &Filter{Input: users, Where: &par.Condition{Column: "id", Operator: "<", Value: "5"}},
+				Right:    orders,
+				LeftKeys: []string{"id"}, RightKeys: []string{"user_id"},
+			},
+		},
+	}}
+	plan, rows := run(t, s, n)
+	want := []string{"user3,3,31.5,11", "user2,3,22.5,8", "user1,3,13.5,5"}
+	if !slices.Equal(rows, want) {
+		t.Errorf("got rows %q, want %q", rows, want)
+	}
+	for i, label := range []string{"Limit", "Sort", "HashAggregate", "Hash Join", "Index Scan using pk on users", "Seq Scan on orders"} {
+		found := slices.ContainsFunc(plan, func(line string) bool { return strings.Contains(line, label+"  (") })
+		if !found {
+			t.Errorf("operator %d %q missing from plan\n%s", i, label, strings.Join(plan, "\n"))
+		}
+	}
+
+	// without keys a join pairs every row; without GROUP BY an aggregate yields one row, even for no input
+	_, rows = run(t, s, &Aggregate{
+		Aggs:  []Agg{{Func: "COUNT", Column: "*"}, {Func: "MIN", Column: "total"}},
+		Input: &Join{Left: &Filter{Input: users, Where: &par.Condition{Column: "age", Operator: "=", Value: "0"}}, Right: orders},
+	})
+	if want := []string{"300,0.5"}; !slices.Equal(rows, want) {
+		t.Errorf("cross join count: got %q, want %q", rows, want)
+	}
+	_, rows = run(t, s, &Aggregate{
+		Aggs:  []Agg{{Func: "COUNT", Column: "total"}, {Func: "AVG", Column: "total"}},
+		Input: &Filter{Input: orders, Where: &par.Condition{Column: "id", Operator: ">", Value: "100"}},
+	})
+	if want := []string{"0,NULL"}; !slices.Equal(rows, want) {
+		t.Errorf("aggregate of nothing: got %q, want %q", rows, want)
+	}
+
+	bad := &Join{Left: users, Right: orders, LeftKeys: []string{"nope"}, RightKeys: []string{"user_id"}}
+	if _, err := Plan(bad, nil); err == nil {
+		t.Errorf("Plan of a join on an unknown column: no error")
+	}
+}
+
+func TestSortLimit(t *testing.T) {
+	d, s := testDB(t)
+	for _, id := range []string{"201", "200"} {
+		err := s.Run(func() error {
+			tbl, err := s.OpenTable("users", db.LockExclusive)
+			if err != nil {
+				return err
+			}
+			defer tbl.Close()
+			return tbl.Insert([]string{id, "NULL", "'nobody'"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(sql string, opts Options) (plan []string, rows []string) {
+		t.Helper()
+		stmt, err := par.Parse(tok.Tokenize(sql))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := Build(stmt.(*par.SelectStatement), d.Catalog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runWith(t, s, n, opts)
+	}
+
+	// NULLs come first descending unless NULLS LAST says otherwise; later keys break ties
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT id, age FROM users ORDER BY age DESC, id;", []string{"200,NULL", "201,NULL", "19,19", "39,19"}},
+		{"SELECT id, age FROM users ORDER BY age DESC NULLS LAST, id DESC;", []string{"199,19", "179,19"}},
+		{"SELECT id FROM users ORDER BY age ASC NULLS FIRST, name DESC;", []string{"201", "200", "80", "60"}},
+		{"SELECT id FROM users WHERE id > 195 ORDER BY age, id DESC;", []string{"196", "197", "198", "199", "201", "200"}},
+	}
+	for _, c := range cases {
+		_, rows := query(c.sql, Options{})
+		if len(rows) < len(c.want) || !slices.Equal(rows[:len(c.want)], c.want) {
+			t.Errorf("%s: got %q..., want %q...", c.sql, rows[:min(len(rows), len(c.want))], c.want)
+		}
+	}
+
+	// a sort over its memory budget writes runs to a temporary file and merges them, in several passes here
+	sql := "SELECT * FROM users ORDER BY name DESC, age NULLS FIRST;"
+	_, want := query(sql, Options{})
+	plan, rows := query(sql, Options{SortMemory: 4096, TempPager: d.TempPager})
+	if !slices.Equal(rows, want) {
+		t.Errorf("external sort: got %d rows %q..., want %q...", len(rows), rows[:min(len(rows), 3)], want[:3])
+	}
+	if !slices.ContainsFunc(plan, func(line string) bool { return strings.Contains(line, "Sort Method: external merge") }) {
+		t.Errorf("sort did not spill:\n%s", strings.Join(plan, "\n"))
+	}
+	if files, _ := os.ReadDir(filepath.Join(d.Dir, "tmp")); len(files) != 0 {
+		t.Errorf("temporary files left behind: %v", files)
+	}
+
+	// LIMIT stops the scan once it has its rows; with ORDER BY the sort keeps only those rows in a heap
+	plan, rows = query("SELECT id FROM users LIMIT 3 OFFSET 2;", Options{})
+	if want := []string{"2", "3", "4"}; !slices.Equal(rows, want) {
+		t.Errorf("LIMIT 3 OFFSET 2: got %q, want %q", rows, want)
+	}
+	if !slices.ContainsFunc(plan, func(line string) bool { return strings.Contains(line, "Seq Scan") && strings.Contains(line, "rows=5)") }) {
+		t.Errorf("scan read past the limit:\n%s", strings.Join(plan, "\n"))
+	}
+	sql = "SELECT id, name FROM users ORDER BY age DESC, name LIMIT 50 OFFSET 5;"
+	_, all := query("SELECT id, name FROM users ORDER BY age DESC, name;", Options{})
+	for _, opts := range []Options{{}, {SortMemory: 4096, TempPager: d.TempPager}} {
+		plan, rows := query(sql, opts)
+		if !slices.Equal(rows, all[5:55]) {
+			t.Errorf("%s: got %q..., want %q...", sql, rows[:min(len(rows), 3)], all[5:8])
+		}
+		// a heap that outgrows the memory budget gives way to the external sort
+		method := "top-N heapsort"
+		if opts.TempPager != nil {
+			method = "external merge"
+		}
+		if !slices.ContainsFunc(plan, func(line string) bool { return strings.Contains(line, "Sort Method: "+method) }) {
+			t.Errorf("%s: no %s in\n%s", sql, method, strings.Join(plan, "\n"))
+		}
+	}
+	if _, rows := query("SELECT id FROM users WHERE id > 195 ORDER BY id OFFSET 3;", Options{}); !slices.Equal(rows, []string{"199", "200", "201"}) {
+		t.Errorf("OFFSET without LIMIT: got %q", rows)
+	}
+	if _, rows := query("SELECT * FROM users ORDER BY id LIMIT 0;", Options{}); len(rows) != 0 {
+		t.Errorf("LIMIT 0: got %d rows", len(rows))
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM users ORDER age;", "SELECT * FROM users ORDER BY age NULLS;",
+		"SELECT * FROM users LIMIT -1;", "SELECT * FROM users LIMIT ten;", "SELECT * FROM users LIMIT 5 OFFSET;",
+		"SELECT * FROM users OFFSET 1 LIMIT 5;",
+	} {
+		if _, err := par.Parse(tok.Tokenize(sql)); err == nil {
+			t.Errorf("%s: no syntax error", sql)
+		}
+	}
+	if _, err := Build(&par.SelectStatement{Columns: []string{"*"}, Table: "users", OrderBy: []par.OrderItem{{Column: "nope"}}}, d.Catalog); err == nil {
+		t.Errorf("Build with an unknown ORDER BY column: no error")
+	}
+}
@@ -0,0 +1,523 @@
+package planner
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/storage"
+)
+
+// DefaultSortMemory is how many bytes of rows a sort holds in memory before it writes them to disk.
+const DefaultSortMemory = 16 << 20
+
+type sortKey struct {
+	col        int
+	desc       bool
+	nullsFirst bool
+}
+
+/*
+sortOp sorts its input. Rows are gathered in memory until they take more than
+the memory budget; they are then sorted and written out as a run to a
+temporary page file, and gathering starts over. Runs are merged into one
+sorted stream, one heap page of each at a time; when there are more runs than
+the budget has room for pages, groups of them are merged into longer runs
+first. Ties keep the order the rows were read in, in memory and on disk.
+
+Under a LIMIT only the first bound rows are needed, and they are kept in a
+bounded heap instead (see top).
+*/
+type sortOp struct {
+	input  *Operator
+	keys   []sortKey
+	names  []SortKey
+	memory int
+	temp   func() (*storage.Pager, error) // nil: never spill
+	schema *catalog.TableSchema           // encodes the rows of runs
+	bound  int64                          // rows the sort has to yield, -1 for all
+
+	rows   []db.Row // sorted rows, when they fit in memory
+	pager  *storage.Pager
+	runs   []sortRun
+	merged *merger
+
+	// how the rows were sorted, for EXPLAIN ANALYZE
+	method string
+	space  int64
+}
+
+// sortRun is a sorted stream of rows written to consecutive pages of the sort's temporary file.
+type sortRun struct {
+	first uint32
+	size  int64 // bytes
+}
+
+func (s *sortOp) open() error {
+	var rows []db.Row
+	size := 0
+	add := func(row db.Row) error {
+		rows = append(rows, row)
+		size += rowSize(row)
+		if size > s.memory && s.temp != nil {
+			if err := s.spill(rows); err != nil {
+				return err
+			}
+			clear(rows)
+			rows, size = rows[:0], 0
+		}
+		return nil
+	}
+	if s.bound >= 0 {
+		kept, done, err := s.top()
+		if err != nil || done {
+			return err
+		}
+		// the rows under the limit outgrew the budget: sort them and the rest of the input after all
+		for _, row := range kept {
+			if err := add(row); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		row, ok, err := s.input.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := add(row); err != nil {
+			return err
+		}
+	}
+	if s.pager == nil {
+		slices.SortStableFunc(rows, s.compare)
+		s.rows, s.method, s.space = rows, "quicksort", int64(size)
+		return nil
+	}
+	if len(rows) > 0 {
+		if err := s.spill(rows); err != nil {
+			return err
+		}
+	}
+	s.method, s.space = "external merge", int64(s.pager.PageCount())*storage.PageSize
+	// leave room for a page of every run being merged
+	width := max(s.memory/storage.PageSize, 2)
+	for len(s.runs) > width {
+		var longer []sortRun
+		for group := range slices.Chunk(s.runs, width) {
+			r, err := s.mergeRuns(group)
+			if err != nil {
+				return err
+			}
+			longer = append(longer, r)
+		}
+		s.runs = longer
+	}
+	m, err := s.merge(s.runs)
+	if err != nil {
+		return err
+	}
+	s.merged = m
+	return nil
+}
+
+func (s *sortOp) next() (db.Row, bool, error) {
+	if s.merged != nil {
+		return s.merged.next()
+	}
+	if len(s.rows) == 0 {
+		return nil, false, nil
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, true, nil
+}
+
+func (s *sortOp) close() error {
+	s.rows, s.runs, s.merged = nil, nil, nil
+	if s.pager == nil {
+		return nil
+	}
+	err := s.pager.Close()
+	s.pager = nil
+	return err
+}
+
+func (s *sortOp) compare(a, b db.Row) int {
+	for _, k := range s.keys {
+		x, y := a[k.col], b[k.col]
+		var c int
+		switch {
+		case x.Null || y.Null:
+			c = cmp.Compare(boolInt(y.Null), boolInt(x.Null))
+			if !k.nullsFirst {
+				c = -c
+			}
+		case k.desc:
+			c = y.Compare(x)
+		default:
+			c = x.Compare(y)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (s *sortOp) describe() (string, []string) {
+	keys := make([]string, len(s.names))
+	for i, k := range s.names {
+		keys[i] = k.Column
+		if k.Desc {
+			keys[i] += " DESC"
+		}
+		// only the order that is not the default for the direction is shown
+		if k.NullsFirst != k.Desc {
+			if k.NullsFirst {
+				keys[i] += " NULLS FIRST"
+			} else {
+				keys[i] += " NULLS LAST"
+			}
+		}
+	}
+	details := []string{"Sort Key: " + strings.Join(keys, ", ")}
+	if s.method != "" {
+		where := "Memory"
+		if s.method == "external merge" {
+			where = "Disk"
+		}
+		details = append(details, fmt.Sprintf("Sort Method: %s  %s: %dkB", s.method, where, (s.space+1023)/1024))
+	}
+	return "Sort", details
+}
+
+/*
+top reads the input keeping only the first bound rows in sort order, in a
+heap whose root is the last of them: a row that sorts before the root
+replaces it, any other row is dropped at once. If the kept rows outgrow the
+memory budget, top stops and returns them in the order they were read, with
+done false; the rows it dropped sort after them, so they would not have been
+yielded anyway.
+*/
+func (s *sortOp) top() (kept []db.Row, done bool, err error) {
+	if s.bound == 0 {
+		s.method = "top-N heapsort"
+		return nil, true, nil
+	}
+	h := &topHeap{s: s}
+	size := 0
+	for seq := 0; ; seq++ {
+		row, ok, err := s.input.Next()
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			break
+		}
+		item := topItem{row, seq}
+		if int64(len(h.items)) < s.bound {
+			heap.Push(h, item)
+			size += rowSize(row)
+		} else if h.before(item, h.items[0]) {
+			size += rowSize(row) - rowSize(h.items[0].row)
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+		if size > s.memory {
+			slices.SortFunc(h.items, func(a, b topItem) int { return cmp.Compare(a.seq, b.seq) })
+			for _, item := range h.items {
+				kept = append(kept, item.row)
+			}
+			return kept, false, nil
+		}
+	}
+	slices.SortFunc(h.items, func(a, b topItem) int {
+		if h.before(a, b) {
+			return -1
+		}
+		return 1
+	})
+	for _, item := range h.items {
+		s.rows = append(s.rows, item.row)
+	}
+	s.method, s.space = "top-N heapsort", int64(size)
+	return nil, true, nil
+}
+
+// topHeap holds the rows a bounded sort keeps, the one that sorts last at the root.
+type topHeap struct {
+	s     *sortOp
+	items []topItem
+}
+
+type topItem struct {
+	row db.Row
+	seq int // position in the input, which breaks ties
+}
+
+// before reports whether a sorts before b.
+func (h *topHeap) before(a, b topItem) bool {
+	if c := h.s.compare(a.row, b.row); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+func (h *topHeap) Len() int           { return len(h.items) }
+func (h *topHeap) Less(i, j int) bool { return h.before(h.items[j], h.items[i]) }
+func (h *topHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topHeap) Push(x any)         { h.items = append(h.items, x.(topItem)) }
+
+func (h *topHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// rowSize estimates the memory a row takes.
+func rowSize(row db.Row) int {
+	n := len(row) * int(unsafe.Sizeof(db.Value{}))
+	for _, v := range row {
+		n += len(v.Str) + len(v.Bytes)
+	}
+	return n
+}
+
+// spill sorts rows and writes them out as a new run, creating the temporary file on the first call.
+func (s *sortOp) spill(rows []db.Row) error {
+	if s.pager == nil {
+		pager, err := s.temp()
+		if err != nil {
+			return err
+		}
+		s.pager = pager
+	}
+	slices.SortStableFunc(rows, s.compare)
+	w := s.newRun()
+	for _, row := range rows {
+		if err := w.write(row); err != nil {
+			return err
+		}
+	}
+	r, err := w.finish()
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, r)
+	return nil
+}
+
+// mergeRuns merges runs into one longer run.
+func (s *sortOp) mergeRuns(runs []sortRun) (sortRun, error) {
+	m, err := s.merge(runs)
+	if err != nil {
+		return sortRun{}, err
+	}
+	w := s.newRun()
+	for {
+		row, ok, err := m.next()
+		if err != nil {
+			return sortRun{}, err
+		}
+		if !ok {
+			return w.finish()
+		}
+		if err := w.write(row); err != nil {
+			return sortRun{}, err
+		}
+	}
+}
+
+/*
+runWriter appends a run to the end of the temporary file. Each row is stored
+as its length (uvarint) and db.SerializeRow encoding, and the rows are packed
+into pages back to back, so a row may continue on the next page. Only one run
+is written at a time, which keeps its pages consecutive.
+*/
+type runWriter struct {
+	s    *sortOp
+	r    sortRun
+	page []byte // the page being filled
+	used bool   // a page has been written
+}
+
+func (s *sortOp) newRun() *runWriter {
+	return &runWriter{s: s, page: make([]byte, 0, storage.UsablePageSize)}
+}
+
+func (w *runWriter) write(row db.Row) error {
+	data := db.SerializeRow(w.s.schema, row)
+	data = append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+	w.r.size += int64(len(data))
+	for len(data) > 0 {
+		n := min(len(data), cap(w.page)-len(w.page))
+		w.page, data = append(w.page, data[:n]...), data[n:]
+		if len(w.page) == cap(w.page) {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *runWriter) flush() error {
+	pageNum, err := w.s.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	if !w.used {
+		w.r.first, w.used = pageNum, true
+	}
+	if err := w.s.pager.FlushPage(pageNum, w.page); err != nil {
+		return err
+	}
+	w.page = w.page[:0]
+	return nil
+}
+
+func (w *runWriter) finish() (sortRun, error) {
+	if len(w.page) > 0 {
+		if err := w.flush(); err != nil {
+			return sortRun{}, err
+		}
+	}
+	return w.r, nil
+}
+
+// runReader reads a run back a page at a time.
+type runReader struct {
+	pager *storage.Pager
+	page  uint32 // next page
+	left  int64  // bytes of the run not read into buf yet
+	buf   []byte
+}
+
+func (r *runReader) fill() error {
+	if r.left == 0 {
+		return io.EOF
+	}
+	page, err := r.pager.GetPage(r.page)
+	if err != nil {
+		return err
+	}
+	n := min(int64(len(page)), r.left)
+	r.buf, r.left = page[:n], r.left-n
+	r.page++
+	return nil
+}
+
+func (r *runReader) ReadByte() (byte, error) {
+	if len(r.buf) == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b, nil
+}
+
+func (r *runReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads the next row of the run; ok is false at its end.
+func (r *runReader) next(schema *catalog.TableSchema) (row db.Row, ok bool, err error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, false, nil
+	}
+	if err == nil {
+		data := make([]byte, n)
+		if _, err = io.ReadFull(r, data); err == nil {
+			row, err = db.DeserializeRow(schema, data)
+		}
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read sort run: %w", err)
+	}
+	return row, true, nil
+}
+
+// merger yields the rows of several runs in sort order, keeping the first row of each run in a heap.
+type merger struct {
+	s     *sortOp
+	runs  []*runReader
+	heads []mergeHead
+}
+
+type mergeHead struct {
+	row db.Row
+	run int // index in runs; an earlier run wins ties
+}
+
+func (s *sortOp) merge(runs []sortRun) (*merger, error) {
+	m := &merger{s: s}
+	for i, r := range runs {
+		m.runs = append(m.runs, &runReader{pager: s.pager, page: r.first, left: r.size})
+		row, ok, err := m.runs[i].next(s.schema)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.heads = append(m.heads, mergeHead{row, i})
+		}
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+func (m *merger) next() (db.Row, bool, error) {
+	if len(m.heads) == 0 {
+		return nil, false, nil
+	}
+	top := m.heads[0]
+	row, ok, err := m.runs[top.run].next(m.s.schema)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		m.heads[0].row = row
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return top.row, true, nil
+}
+
+func (m *merger) Len() int { return len(m.heads) }
+
+func (m *merger) Less(i, j int) bool {
+	if c := m.s.compare(m.heads[i].row, m.heads[j].row); c != 0 {
+		return c < 0
+	}
+	return m.heads[i].run < m.heads[j].run
+}
+
+func (m *merger) Swap(i, j int) { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+func (m *merger) Push(x any)    { m.heads = append(m.heads, x.(mergeHead)) }
+
+func (m *merger) Pop() any {
+	h := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return h
+}
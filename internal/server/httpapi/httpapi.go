@@ -0,0 +1,141 @@
+/*
+Package httpapi serves letsgodb queries as JSON over HTTP.
+
+	POST /query
+	{"db": "mydb", "sql": "SELECT id, name FROM users;"}
+
+answers with the result of the last statement
+
+	{"columns": ["id", "name"], "rows": [[1, "alice"]], "rows_affected": 0}
+
+or, when a statement fails, with a 4xx/5xx status and
+
+	{"error": {"code": "42P01", "message": "table \"users\" does not exist"}}
+
+where code is the PostgreSQL SQLSTATE of the error. Every request runs in a
+fresh engine session: "db" selects the database like USE does, several
+';'-separated statements run in order and stop at the first error, and a
+transaction left open at the end of the request is rolled back.
+*/
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+const maxRequestSize = 16 << 20
+
+// Handler answers POST /query requests by running them on an engine.
+type Handler struct {
+	Engine *engine.Engine
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/query" {
+		writeError(w, http.StatusNotFound, Error{Code: "08P01", Message: "no such endpoint " + r.URL.Path})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, Error{Code: "0A000", Message: "use POST"})
+		return
+	}
+	h.query(w, r)
+}
+
+// Request is the body of POST /query.
+type Request struct {
+	DB  string `json:"db"`
+	SQL string `json:"sql"`
+}
+
+// Response is a successful result. Columns and Rows are empty for statements that return no rows.
+type Response struct {
+	Columns      []string `json:"columns"`
+	Rows         [][]any  `json:"rows"`
+	RowsAffected int      `json:"rows_affected"`
+	Message      string   `json:"message,omitempty"`
+}
+
+// Error is the body of a failed request.
+type Error struct {
+	Code    string `json:"code"` // SQLSTATE
+	Message string `json:"message"`
+}
+
+func (h *Handler) query(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, Error{Code: "08P01", Message: "invalid request body: " + err.Error()})
+		return
+	}
+	stmts := engine.SplitStatements(req.SQL)
+	if len(stmts) == 0 {
+		writeError(w, http.StatusBadRequest, Error{Code: "42601", Message: "empty query"})
+		return
+	}
+
+	session := h.Engine.NewSession()
+	defer session.Close()
+	if req.DB != "" {
+		if err := session.Use(req.DB); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+	}
+	var res *engine.Result
+	for _, text := range stmts {
+		var err error
+		if res, err = session.Exec(text); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+	}
+
+	out := Response{
+		Columns:      res.Columns,
+		Rows:         make([][]any, len(res.Rows)),
+		RowsAffected: res.RowsAffected,
+		Message:      res.Message,
+	}
+	if out.Columns == nil {
+		out.Columns = []string{}
+	}
+	for i, row := range res.Rows {
+		out.Rows[i] = make([]any, len(row))
+		for j, v := range row {
+			out.Rows[i][j] = v.Any()
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// writeEngineError reports an error from the engine: internal errors are the server's fault, the rest the client's.
+func writeEngineError(w http.ResponseWriter, err error) {
+	e := Error{Code: engine.SQLState(err), Message: err.Error()}
+	switch {
+	case e.Code == "XX000":
+		writeError(w, http.StatusInternalServerError, e)
+	case errors.Is(err, engine.ErrNoSuchDatabase):
+		writeError(w, http.StatusNotFound, e)
+	default:
+		writeError(w, http.StatusBadRequest, e)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, e Error) {
+	writeJSON(w, status, struct {
+		Error Error `json:"error"`
+	}{e})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+func TestQuery(t *testing.T) {
+	eng, err := engine.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Close()
+	server := httptest.NewServer(&Handler{Engine: eng})
+	defer server.Close()
+
+	steps := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{`{"sql":"CREATE DATABASE shop;"}`, 200, `"rows_affected":0`},
+		{`{"db":"shop","sql":"CREATE TABLE items (PRIMARY_KEY id INTEGER, name TEXT, price REAL);"}`, 200, `"columns":[]`},
+		{`{"db":"shop","sql":"INSERT INTO items (id, name, price) VALUES (1, 'pen', 1.5); INSERT INTO items (id, name, price) VALUES (2, 'cup', NULL)"}`, 200, `"rows_affected":1`},
+		{`{"db":"shop","sql":"SELECT id, name, price FROM items"}`, 200, `{"columns":["id","name","price"],"rows":[[1,"pen",1.5],[2,"cup",null]],"rows_affected":0}`},
+		{`{"db":"shop","sql":"SELECT * FROM items WHERE id = 3"}`, 200, `"rows":[]`},
+		// a transaction left open is rolled back at the end of the request
+		{`{"db":"shop","sql":"BEGIN; DELETE FROM items;"}`, 200, `"rows_affected":2`},
+		{`{"db":"shop","sql":"SELECT id FROM items"}`, 200, `"rows":[[1],[2]]`},
+		{`{"db":"shop","sql":"SELECT * FROM nope"}`, 400, `{"error":{"code":"42P01","message":"table \"nope\" does not exist"}}`},
+		{`{"db":"shop","sql":"SELEC 1"}`, 400, `"code":"42601"`},
+		{`{"db":"nope","sql":"SELECT * FROM items"}`, 404, `"code":"3D000"`},
+		{`{"sql":`, 400, `"code":"08P01"`},
+	}
+	for _, step := range steps {
+		resp, err := http.Post(server.URL+"/query", "application/json", strings.NewReader(step.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != step.status || !strings.Contains(string(body), step.want) {
+			t.Errorf("%s:\n got  %d %s want %d ...%s...", step.body, resp.StatusCode, body, step.status, step.want)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /query: status %d", resp.StatusCode)
+	}
+}
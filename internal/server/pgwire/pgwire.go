@@ -0,0 +1,429 @@
+/*
+Package pgwire serves letsgodb over the PostgreSQL v3 frontend/backend protocol,
+so psql, pgx and other PostgreSQL clients can connect.
+
+Supported: startup (SSL and GSS encryption requests are declined), trust
+authentication, the simple query protocol (several statements per query are
+allowed) and Terminate. Extended-protocol messages are answered with a
+feature_not_supported error; pgx users should pick the simple protocol:
+
+	postgres://localhost:5432/mydb?default_query_exec_mode=simple_protocol
+
+Every connection gets its own engine session, so each has its own current
+database and transaction. The "database" startup parameter selects the
+database like USE does.
+*/
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+const (
+	protocolVersion3 = 196608 // 3.0
+	sslRequestCode   = 80877103
+	gssRequestCode   = 80877104
+	cancelCode       = 80877102
+
+	maxMessageSize = 64 << 20
+)
+
+// Server accepts PostgreSQL clients and runs their queries on an engine.
+type Server struct {
+	Engine *engine.Engine
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// ListenAndServe listens on addr (e.g. ":5432") and serves until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l, one goroutine each, until Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+			}()
+			if err := s.serveConn(c); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("pgwire: %s: %v", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Close stops accepting, disconnects every client and waits for their sessions to end.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// conn is one client connection.
+type conn struct {
+	c       net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	session *engine.Session
+}
+
+func (s *Server) serveConn(c net.Conn) error {
+	defer c.Close()
+	cn := &conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c), session: s.Engine.NewSession()}
+	defer cn.session.Close()
+
+	params, err := cn.startup()
+	if err != nil || params == nil {
+		return err
+	}
+	if name := params["database"]; name != "" {
+		if err := cn.session.Use(name); err != nil {
+			cn.sendError("FATAL", err)
+			return cn.w.Flush()
+		}
+	}
+	cn.send('R', binary.BigEndian.AppendUint32(nil, 0)) // AuthenticationOk
+	for _, kv := range [][2]string{
+		{"server_version", "14.0 (letsgodb)"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	} {
+		cn.send('S', cstring(cstring(nil, kv[0]), kv[1]))
+	}
+	key := binary.BigEndian.AppendUint32(nil, rand.Uint32())
+	cn.send('K', binary.BigEndian.AppendUint32(key, rand.Uint32())) // BackendKeyData
+	cn.readyForQuery()
+	if err := cn.w.Flush(); err != nil {
+		return err
+	}
+
+	skipToSync := false // after an extended-protocol error, messages up to Sync are ignored
+	for {
+		typ, body, err := cn.readMessage()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'Q':
+			cn.simpleQuery(string(trimNull(body)))
+		case 'X':
+			return nil
+		case 'S': // Sync
+			skipToSync = false
+			cn.readyForQuery()
+		case 'H': // Flush
+		case 'P', 'B', 'D', 'E', 'C', 'F':
+			if !skipToSync {
+				cn.sendErrorCode("ERROR", "0A000", "extended query protocol is not supported; use the simple query protocol")
+				skipToSync = true
+			}
+		default:
+			cn.sendErrorCode("FATAL", "08P01", fmt.Sprintf("unexpected message type %q", typ))
+			cn.w.Flush()
+			return nil
+		}
+		if err := cn.w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// startup reads the startup packet, declining encryption requests. It returns nil params for a cancel request.
+func (cn *conn) startup() (map[string]string, error) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(cn.r, hdr[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint32(hdr[0:4]))
+		code := binary.BigEndian.Uint32(hdr[4:8])
+		if length < 8 || length > 10000 {
+			return nil, fmt.Errorf("invalid startup packet length %d", length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(cn.r, body); err != nil {
+			return nil, err
+		}
+		switch code {
+		case sslRequestCode, gssRequestCode:
+			if _, err := cn.c.Write([]byte{'N'}); err != nil {
+				return nil, err
+			}
+			continue
+		case cancelCode:
+			return nil, nil // queries run to completion; nothing to cancel
+		case protocolVersion3:
+		default:
+			cn.sendErrorCode("FATAL", "0A000", fmt.Sprintf("unsupported frontend protocol %d.%d", code>>16, code&0xffff))
+			cn.w.Flush()
+			return nil, nil
+		}
+		params := make(map[string]string)
+		fields := splitNull(body)
+		for i := 0; i+1 < len(fields); i += 2 {
+			params[fields[i]] = fields[i+1]
+		}
+		return params, nil
+	}
+}
+
+func (cn *conn) readMessage() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(cn.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int(binary.BigEndian.Uint32(hdr[1:5]))
+	if length < 4 || length > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length %d", length)
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(cn.r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
+
+// send writes one backend message.
+func (cn *conn) send(typ byte, body []byte) {
+	cn.w.WriteByte(typ)
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(body)+4))
+	cn.w.Write(length[:])
+	cn.w.Write(body)
+}
+
+func (cn *conn) readyForQuery() {
+	status := byte('I')
+	if cn.session.TransactionAborted() {
+		status = 'E'
+	} else if cn.session.InTransaction() {
+		status = 'T'
+	}
+	cn.send('Z', []byte{status})
+}
+
+// simpleQuery runs every statement of a Query message, stopping at the first error.
+func (cn *conn) simpleQuery(sql string) {
+	defer cn.readyForQuery()
+	stmts := engine.SplitStatements(sql)
+	if len(stmts) == 0 {
+		cn.send('I', nil) // EmptyQueryResponse
+		return
+	}
+	for _, text := range stmts {
+		stmt, err := engine.Parse(text)
+		if err != nil {
+			cn.sendError("ERROR", err)
+			return
+		}
+		if _, ok := stmt.(*par.SelectStatement); ok {
+			if !cn.streamRows(stmt) {
+				return
+			}
+			continue
+		}
+		res, err := cn.session.Execute(stmt)
+		if err != nil {
+			cn.sendError("ERROR", err)
+			return
+		}
+		if res.Columns != nil {
+			cn.sendDescription(res.Columns, res.Types)
+			for _, row := range res.Rows {
+				cn.sendDataRow(row)
+			}
+			cn.send('C', cstring(nil, "SELECT "+strconv.Itoa(len(res.Rows))))
+			continue
+		}
+		cn.send('C', cstring(nil, commandTag(stmt, res)))
+	}
+}
+
+// commandTag is the CommandComplete text clients show after a statement, e.g. "INSERT 0 1".
+func commandTag(stmt par.Statement, res *engine.Result) string {
+	switch stmt.(type) {
+	case *par.InsertStatement:
+		return "INSERT 0 " + strconv.Itoa(res.RowsAffected)
+	case *par.UpdateStatement, *par.DeleteStatement:
+		return par.StatementName(stmt) + " " + strconv.Itoa(res.RowsAffected)
+	case *par.UseDatabaseStatement:
+		return "SET"
+	}
+	return par.StatementName(stmt)
+}
+
+// Type OIDs and sizes from PostgreSQL's pg_type.
+var pgTypes = map[catalog.ColumnType]struct {
+	oid  uint32
+	size int16
+}{
+	catalog.TypeInteger: {20, 8},  // int8
+	catalog.TypeReal:    {701, 8}, // float8
+	catalog.TypeText:    {25, -1}, // text
+	catalog.TypeBoolean: {16, 1},  // bool
+	catalog.TypeBlob:    {17, -1}, // bytea
+}
+
+// streamRows sends a query's rows as they are read, reporting false if it failed.
+func (cn *conn) streamRows(stmt par.Statement) bool {
+	rows, err := cn.session.Query(stmt)
+	if err != nil {
+		cn.sendError("ERROR", err)
+		return false
+	}
+	defer rows.Close()
+	cn.sendDescription(rows.Columns, rows.Types)
+	n := 0
+	for rows.Next() {
+		cn.sendDataRow(rows.Row())
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		cn.sendError("ERROR", err)
+		return false
+	}
+	cn.send('C', cstring(nil, "SELECT "+strconv.Itoa(n)))
+	return true
+}
+
+func (cn *conn) sendDescription(columns []string, types []catalog.ColumnType) {
+	desc := binary.BigEndian.AppendUint16(nil, uint16(len(columns)))
+	for i, name := range columns {
+		t := pgTypes[catalog.TypeText]
+		if i < len(types) {
+			t = pgTypes[types[i]]
+		}
+		desc = cstring(desc, name)
+		desc = binary.BigEndian.AppendUint32(desc, 0) // table OID
+		desc = binary.BigEndian.AppendUint16(desc, 0) // column number
+		desc = binary.BigEndian.AppendUint32(desc, t.oid)
+		desc = binary.BigEndian.AppendUint16(desc, uint16(t.size))
+		desc = binary.BigEndian.AppendUint32(desc, 0xffffffff) // type modifier -1
+		desc = binary.BigEndian.AppendUint16(desc, 0)          // text format
+	}
+	cn.send('T', desc)
+}
+
+func (cn *conn) sendDataRow(row db.Row) {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(row)))
+	for _, v := range row {
+		if v.Null {
+			data = binary.BigEndian.AppendUint32(data, 0xffffffff)
+			continue
+		}
+		text := textValue(v)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(text)))
+		data = append(data, text...)
+	}
+	cn.send('D', data)
+}
+
+// textValue renders a value in PostgreSQL's text format.
+func textValue(v db.Value) string {
+	switch v.Type {
+	case catalog.TypeBoolean:
+		if v.Bool {
+			return "t"
+		}
+		return "f"
+	case catalog.TypeBlob:
+		return `\x` + hex.EncodeToString(v.Bytes)
+	}
+	return v.String()
+}
+
+func (cn *conn) sendError(severity string, err error) {
+	cn.sendErrorCode(severity, engine.SQLState(err), err.Error())
+}
+
+func (cn *conn) sendErrorCode(severity, code, message string) {
+	var body []byte
+	body = cstring(append(body, 'S'), severity)
+	body = cstring(append(body, 'V'), severity)
+	body = cstring(append(body, 'C'), code)
+	body = cstring(append(body, 'M'), message)
+	cn.send('E', append(body, 0))
+}
+
+func cstring(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+func trimNull(b []byte) []byte {
+	if len(b) > 0 && b[len(b)-1] == 0 {
+		return b[:len(b)-1]
+	}
+	return b
+}
+
+func splitNull(b []byte) []string {
+	var out []string
+	for len(b) > 0 {
+		i := 0
+		for i < len(b) && b[i] != 0 {
+			i++
+		}
+		if i == 0 {
+			break
+		}
+		out = append(out, string(b[:i]))
+		if i == len(b) {
+			break
+		}
+		b = b[i+1:]
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+// client speaks just enough of the frontend protocol to drive the server.
+type client struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func (cl *client) startup(params ...string) {
+	// SSLRequest first, the way psql does it
+	ssl := binary.BigEndian.AppendUint32(nil, 8)
+	cl.c.Write(binary.BigEndian.AppendUint32(ssl, sslRequestCode))
+	if b, _ := cl.r.ReadByte(); b != 'N' {
+		cl.t.Fatalf("SSLRequest answered with %q", b)
+	}
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion3)
+	for _, p := range params {
+		body = cstring(body, p)
+	}
+	body = append(body, 0)
+	cl.c.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...))
+}
+
+func (cl *client) query(sql string) {
+	body := cstring(nil, sql)
+	msg := append([]byte{'Q'}, binary.BigEndian.AppendUint32(nil, uint32(len(body)+4))...)
+	cl.c.Write(append(msg, body...))
+}
+
+// until reads messages up to ReadyForQuery and returns them as readable lines.
+func (cl *client) until() []string {
+	var out []string
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(cl.r, hdr[:]); err != nil {
+			cl.t.Fatalf("read: %v", err)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[1:])-4)
+		io.ReadFull(cl.r, body)
+		switch hdr[0] {
+		case 'Z':
+			return append(out, "Z "+string(body))
+		case 'C':
+			out = append(out, "C "+string(trimNull(body)))
+		case 'T':
+			var names []string
+			for b := body[2:]; len(b) > 0; {
+				name, rest, _ := strings.Cut(string(b), "\x00")
+				names = append(names, name)
+				b = []byte(rest)[18:] // table, attnum, type, size, modifier, format
+			}
+			out = append(out, "T "+strings.Join(names, ","))
+		case 'D':
+			var fields []string
+			for b := body[2:]; len(b) > 0; {
+				n := int32(binary.BigEndian.Uint32(b))
+				b = b[4:]
+				if n < 0 {
+					fields = append(fields, "NULL")
+					continue
+				}
+				fields = append(fields, string(b[:n]))
+				b = b[n:]
+			}
+			out = append(out, "D "+strings.Join(fields, ","))
+		case 'E':
+			for _, f := range splitNull(body) {
+				if f[0] == 'C' {
+					out = append(out, "E "+f[1:])
+				}
+			}
+		}
+	}
+}
+
+func TestSimpleQuery(t *testing.T) {
+	eng, err := engine.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eng.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Engine: eng}
+	go server.Serve(l)
+	defer server.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cl := &client{t: t, c: c, r: bufio.NewReader(c)}
+	cl.startup("user", "test")
+	if got := cl.until(); got[len(got)-1] != "Z I" {
+		t.Fatalf("startup: %v", got)
+	}
+
+	steps := []struct{ sql, want string }{
+		{"CREATE DATABASE shop; USE shop;", "C CREATE DATABASE|C SET|Z I"},
+		{"CREATE TABLE items (PRIMARY_KEY id INTEGER, name TEXT, ok BOOLEAN);", "C CREATE TABLE|Z I"},
+		{"BEGIN; INSERT INTO items (id, name, ok) VALUES (1, 'pen', true);", "C BEGIN|C INSERT 0 1|Z T"},
+		{"INSERT INTO items (id, name, ok) VALUES (1, 'dup', NULL);", "E 23505|Z E"},
+		{"ROLLBACK;", "C ROLLBACK|Z I"},
+		{"INSERT INTO items (id, name, ok) VALUES (2, 'cup', NULL);", "C INSERT 0 1|Z I"},
+		{"SELECT * FROM items;", "T id,name,ok|D 2,cup,NULL|C SELECT 1|Z I"},
+		{"SELECT * FROM nope;", "E 42P01|Z I"},
+		{"SELEC 1;", "E 42601|Z I"},
+		{"", "Z I"},
+	}
+	for _, step := range steps {
+		cl.query(step.sql)
+		got := cl.until()
+		if strings.Join(got, "|") != step.want {
+			t.Errorf("%q:\n got  %s\n want %s", step.sql, strings.Join(got, "|"), step.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// File header: the first page of every page file, saying which format the rest is in.
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+Format versions of page files:
+
+	1  pages of PageSize bytes straight from offset 0, no header and no checksums
+	2  a header page, then pages with a checksum and LSN header (see page.go)
+
+Pages are numbered from the first page after the header, so a page number
+means the same page in every format.
+*/
+const FormatVersion = 2
+
+/*
+The header page is a sealed page (see page.go) whose data is:
+
+	[magic "LETSGODB"][format version uint16][page size uint32][created unix nanoseconds int64][creator length uint8][creator]
+*/
+const (
+	fileMagic   = "LETSGODB"
+	headerPages = 1
+	creator     = "letsgodb"
+)
+
+// ErrFileFormat is wrapped by errors about a file this build cannot read as it is.
+var ErrFileFormat = errors.New("unsupported file format")
+
+// FileHeader describes a page file.
+type FileHeader struct {
+	Version   int
+	PageSize  int
+	Created   time.Time // zero for format 1 files
+	CreatedBy string
+}
+
+// pageOffset is where a page starts in its file.
+func pageOffset(pageNum uint32) int64 {
+	return int64(pageNum+headerPages) * PageSize
+}
+
+func (h FileHeader) image() []byte {
+	data := []byte(fileMagic)
+	data = binary.LittleEndian.AppendUint16(data, uint16(h.Version))
+	data = binary.LittleEndian.AppendUint32(data, uint32(h.PageSize))
+	data = binary.LittleEndian.AppendUint64(data, uint64(h.Created.UnixNano()))
+	data = append(data, byte(len(h.CreatedBy)))
+	data = append(data, h.CreatedBy...)
+	image := pageImage(data, 0)
+	seal(image)
+	return image
+}
+
+/*
+readHeader reads the header of an open page file. found is false for a file
+with no header yet: an empty one, or one whose header page was never written.
+A file that does not start with the magic number is a format 1 file.
+*/
+func readHeader(file *os.File) (h FileHeader, found bool, err error) {
+	image := make([]byte, PageSize)
+	n, err := file.ReadAt(image, 0)
+	if err != nil && err != io.EOF {
+		return FileHeader{}, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return FileHeader{}, false, err
+	}
+	data := image[PageHeaderSize:]
+	if !bytes.HasPrefix(data, []byte(fileMagic)) {
+		// the header is synced before any page is written after it
+		if info.Size() <= PageSize && blank(image) {
+			return FileHeader{}, false, nil
+		}
+		return FileHeader{Version: 1, PageSize: PageSize}, true, nil
+	}
+	if n < PageSize || !intact(image) {
+		return FileHeader{}, false, fmt.Errorf("%w: %s: damaged file header", ErrCorruptPage, file.Name())
+	}
+	data = data[len(fileMagic):]
+	h = FileHeader{
+		Version:  int(binary.LittleEndian.Uint16(data[0:2])),
+		PageSize: int(binary.LittleEndian.Uint32(data[2:6])),
+		Created:  time.Unix(0, int64(binary.LittleEndian.Uint64(data[6:14]))),
+	}
+	h.CreatedBy = string(data[15 : 15+int(data[14])])
+	return h, true, nil
+}
+
+// ReadFileHeader reads the header of the page file at path.
+// A missing or empty file has the current format, as it will once a pager opens it.
+func ReadFileHeader(path string) (FileHeader, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileHeader{Version: FormatVersion, PageSize: PageSize}, nil
+	}
+	if err != nil {
+		return FileHeader{}, err
+	}
+	defer file.Close()
+	h, found, err := readHeader(file)
+	if !found && err == nil {
+		h = FileHeader{Version: FormatVersion, PageSize: PageSize}
+	}
+	return h, err
+}
+
+/*
+initFile checks the header of a page file opened for writing, writing one
+first if it has none. Only files of the current format with this build's page
+size are accepted; older ones have to be upgraded by whoever knows what their
+pages hold.
+*/
+func initFile(file *os.File) error {
+	h, found, err := readHeader(file)
+	if err != nil {
+		return err
+	}
+	if !found {
+		h = FileHeader{Version: FormatVersion, PageSize: PageSize, Created: time.Now(), CreatedBy: creator}
+		if _, err := file.WriteAt(h.image(), 0); err != nil {
+			return err
+		}
+		return file.Sync()
+	}
+	switch {
+	case h.Version < FormatVersion:
+		return fmt.Errorf("%w: %s is in format %d and needs upgrading to format %d", ErrFileFormat, file.Name(), h.Version, FormatVersion)
+	case h.Version > FormatVersion:
+		return fmt.Errorf("%w: %s is in format %d, written by a newer letsgodb (this one reads format %d)", ErrFileFormat, file.Name(), h.Version, FormatVersion)
+	case h.PageSize != PageSize:
+		return fmt.Errorf("%w: %s has %d byte pages, this build uses %d", ErrFileFormat, file.Name(), h.PageSize, PageSize)
+	}
+	return nil
+}
+
+// ReadV1Pages calls fn with every page of a format 1 file, in order.
+func ReadV1Pages(path string, fn func(pageNum int, page []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for pageNum := 0; ; pageNum++ {
+		page := make([]byte, PageSize)
+		n, err := file.ReadAt(page, int64(pageNum)*PageSize)
+		if n == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err := fn(pageNum, page); err != nil {
+			return err
+		}
+	}
+}
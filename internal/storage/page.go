@@ -0,0 +1,76 @@
+// Page header: the checksum and log sequence number at the start of every page on disk.
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+/*
+Every page on disk starts with a header:
+
+	[checksum uint32][lsn uint64]
+
+The checksum is the CRC32C of the rest of the page, header included, and is
+checked whenever the page is read from disk, so bit rot or a torn write shows
+up as ErrCorruptPage instead of garbled rows. The LSN is the log sequence
+number of the commit that last wrote the page (0 for pages written outside a
+transaction). A page of zeros is a page that was allocated but never written.
+Pagers hand out and take only the bytes after the header.
+*/
+const (
+	PageHeaderSize = 12
+	UsablePageSize = PageSize - PageHeaderSize
+)
+
+// ErrCorruptPage is wrapped by errors about a page whose contents fail their checksum.
+var ErrCorruptPage = errors.New("corrupt page")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// pageImage builds the on-disk image of a page from the bytes a pager's user sees.
+func pageImage(data []byte, lsn uint64) []byte {
+	image := make([]byte, PageSize)
+	copy(image[PageHeaderSize:], data)
+	binary.LittleEndian.PutUint64(image[4:12], lsn)
+	return image
+}
+
+func pageLSN(image []byte) uint64 {
+	return binary.LittleEndian.Uint64(image[4:12])
+}
+
+// seal sets the checksum of a page image before it is written to disk.
+func seal(image []byte) {
+	binary.LittleEndian.PutUint32(image[0:4], crc32.Checksum(image[4:], castagnoli))
+}
+
+// verify checks a page image read from disk; n is how many bytes the file had for it.
+func verify(image []byte, n int, file string, pageNum int) error {
+	switch {
+	case blank(image):
+		return nil
+	case n < PageSize:
+		return fmt.Errorf("%w: %s page %d: only %d of %d bytes on disk (torn write)", ErrCorruptPage, file, pageNum, n, PageSize)
+	case !intact(image):
+		return fmt.Errorf("%w: %s page %d: checksum mismatch", ErrCorruptPage, file, pageNum)
+	}
+	return nil
+}
+
+// intact reports whether a page image matches its checksum.
+func intact(image []byte) bool {
+	return binary.LittleEndian.Uint32(image[0:4]) == crc32.Checksum(image[4:], castagnoli)
+}
+
+// blank reports whether a page is all zeros: allocated, but never written.
+func blank(image []byte) bool {
+	for _, b := range image {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -3,19 +3,28 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
 )
 
 const PageSize = 4096 // each page is 4kb
-const MaxCachedPages = 1024 // Limit cache to 512 pages (4MB)
+
+// ErrFileFull is returned by AllocatePage when the file has no page numbers left.
+var ErrFileFull = errors.New("file has reached its maximum number of pages")
 
 type Pager struct {
-	file     *os.File          // file the stores data in Disk
-	pages    map[uint32][]byte // in memory cache of page number
-	pageSize int               // 4kb
-	maxPage  int               //number of pages that exists in the disk
-	pageNum  uint32            //to track the pages for allocation
+	file     *os.File    // file the stores data in Disk
+	pool     *BufferPool // cache of the file's pages, shared with the other files of a database
+	pageSize int         // 4kb
+	maxPage  int         //number of pages that exists in the disk
+	pageNum  uint32      //to track the pages for allocation
+	txn      *Txn        // when set, flushed pages go to the transaction instead of the file
+	name     string      // file name relative to the database directory (used in WAL records)
+	temp     bool        // scratch file, removed on Close
 }
 
 /*
@@ -26,136 +35,179 @@ Note:
 	returns the pager struct
 	basically set up the Pager and
 	it works like a constructor as it is called once
+
+	pages are cached in pool, the buffer pool of the file's database, so all
+	its files share one memory budget; a nil pool gives the pager one of its
+	own, for a file used on its own
+
+	a new file gets a header page (see header.go); an existing one must be in
+	the current format
 */
-func NewPager(filename string) *Pager {
+func NewPager(filename string, pool *BufferPool) (*Pager, error) {
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		panic(fmt.Sprintf("failed to open file: %v", err))
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if err := initFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
-	fileInfo, _ := file.Stat()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
 	fileSize := fileInfo.Size()
-	maxPage := int(fileSize / int64(PageSize))
+	maxPage := max(int(fileSize/int64(PageSize))-headerPages, 0)
 
+	if pool == nil {
+		pool = NewBufferPool(DefaultPoolSize)
+	}
 	return &Pager{
 		file:     file,
-		pages:    make(map[uint32][]byte),
+		pool:     pool,
 		pageSize: PageSize,
 		maxPage:  maxPage,
-	}
+	}, nil
 }
 
-// evictPage removes a random page from cache when we hit the limit
-func (p *Pager) evictPage() {
-	if len(p.pages) >= MaxCachedPages {
-		// Remove first page we find (random eviction)
-		for pageNum := range p.pages {
-			delete(p.pages, pageNum)
-			break
-		}
+/*
+NewTempPager creates a pager on a new scratch file in dir, for data that only
+lives as long as the pager, such as the sorted runs of an external sort. It
+caches only a few pages, and closing it removes the file.
+*/
+func NewTempPager(dir string) (*Pager, error) {
+	file, err := os.CreateTemp(dir, "temp-*.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	if err := initFile(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
 	}
+	return &Pager{file: file, pool: NewBufferPool(0), pageSize: PageSize, temp: true}, nil
 }
 
 /*
-ReadPage loads a page from disk into memory.
-If the file is smaller than a full page, the remaining bytes are zeroed out.
-This ensures every page is always PageSize bytes.
+ReadPage loads a page from disk into memory, header included (see page.go),
+and verifies its checksum. A page past the end of the file reads as zeros;
+one the file holds only part of is a torn write and fails with ErrCorruptPage.
 */
 func (p *Pager) ReadPage(pageNum int) ([]byte, error) {
-
-	offset := int64(pageNum) * int64(p.pageSize)
-
-	// Seek to the correct offset in the file
-	_, err := p.file.Seek(offset, 0)
-	if err != nil {
-		return nil, err
-	}
-
-	// Allocate 4KB page buffer
 	buf := make([]byte, p.pageSize)
-
-	//the contents of the file is retrived and it fills the buf wiht the data
-	//starting from the offset set by Seek
-	n, err := p.file.Read(buf)
-	if err != nil && err.Error() != "EOF" {
-		return nil, err
+	n, err := p.file.ReadAt(buf, pageOffset(uint32(pageNum)))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading %s page %d: %w", p.file.Name(), pageNum, err)
 	}
-
-	/*
-		this block handles the case when the bytes read form the file doesn't
-		fill the 4kb buffer. It fills the remaning space with 0 to avoid inconsistancy
-		and partial page readings
-	*/
-	if n < p.pageSize {
-		// Zero out the rest if the file is smaller than the page
-		for i := n; i < p.pageSize; i++ {
-			buf[i] = 0
-		}
+	if err := verify(buf, n, p.file.Name(), pageNum); err != nil {
+		return nil, err
 	}
-
 	return buf, nil
 }
 
 /*
-GetPage returns the page with the given page number.
-If the page is not in the cache, it is loaded from disk and cached.
-This is the main entry point for reading/modifying a page in memory.
+GetPage returns a copy of the page with the given page number (UsablePageSize
+bytes, without the header), which the caller may change and hand back to FlushPage. A page the transaction wrote is
+newer than the one on disk; any other page comes from the buffer pool, which
+loads it from disk on a miss.
 */
-func (p *Pager) GetPage(pageNum uint32) []byte {
-	//if the page is already in cache it returns immediately since no need to
-	// extract from disk
-	if page, ok := p.pages[pageNum]; ok {
-		return page
+func (p *Pager) GetPage(pageNum uint32) ([]byte, error) {
+	page, unpin, err := p.Pin(pageNum)
+	if err != nil {
+		return nil, err
 	}
+	defer unpin()
+	return append([]byte(nil), page...), nil
+}
 
-	// Check if we need to evict a page first
-	p.evictPage()
-
-	//if it is still in disk it class the ReadPage function to do the thing
-	// ReadPage does, to store data in bytes in page variable
-	page, err := p.ReadPage(int(pageNum))
+/*
+Pin returns the page with the given page number without copying it and keeps
+it in the buffer pool until unpin is called. The page must not be changed:
+other sessions may be reading the same bytes. unpin is only set when err is nil.
+*/
+func (p *Pager) Pin(pageNum uint32) (page []byte, unpin func(), err error) {
+	if p.txn != nil {
+		if page, ok := p.txn.page(p.name, pageNum); ok {
+			return page, func() {}, nil
+		}
+	}
+	f, data, err := p.pool.pin(poolKey(p.file.Name(), pageNum), func() ([]byte, error) {
+		return p.ReadPage(int(pageNum))
+	})
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	//The page is stored in the pages map so future requests for the same
-	//page can be served from memory (faster).
-	p.pages[pageNum] = page
-	return page
+	return data[PageHeaderSize:], func() { p.pool.unpin(f) }, nil
 }
 
-// WritePage writes the given data to the specified page number on disk.
-// The data slice must be exactly PageSize bytes.
+// WritePage writes the given data to the specified page number on disk, and to its cached copy.
+// The data slice must be at most UsablePageSize bytes; the rest of the page is zeroed.
 func (p *Pager) WritePage(pageNum int, data []byte) error {
-	if len(data) > PageSize {
+	if len(data) > UsablePageSize {
 		return fmt.Errorf("data exceeds page size")
 	}
-	_, err := p.file.WriteAt(data, int64(pageNum)*int64(PageSize))
-	return err
+	return p.pool.writeThrough(poolKey(p.file.Name(), uint32(pageNum)), pageImage(data, 0), p.file)
 }
 
 /*
-FlushPage writes the given page data to disk at the specified page number.
-This is a wrapper around WritePage for convenience.
+FlushPage stores the given page data at the specified page number. It goes to
+the buffer pool as a dirty page, written to disk when the pool evicts it or
+the pager is closed. When the pager is part of a transaction the page is
+handed to the transaction instead and only reaches the file when the
+transaction commits.
 */
 func (p *Pager) FlushPage(pageNum uint32, data []byte) error {
-	// Remove from cache after flushing to free memory
-	// delete(p.pages, pageNum)
-	return p.WritePage(int(pageNum), data)
+	if len(data) > UsablePageSize {
+		return fmt.Errorf("data exceeds page size")
+	}
+	if p.txn != nil {
+		return p.txn.put(p.name, pageNum, data)
+	}
+	return p.pool.put(poolKey(p.file.Name(), pageNum), pageImage(data, 0), true, p.file)
 }
 
 /*
-AllocatePage allocates a new blank page in memory, assigns it the next available page number,
-and returns that page number. The new page is also cached in memory.
+Join makes the pager read and write through the transaction t: pages t already
+wrote are read from it and every later FlushPage is kept in it.
+The pager's file must live in the database directory of t's log, and from now
+on it caches its pages in that database's pool (see Txn.Pool).
+*/
+func (p *Pager) Join(t *Txn) {
+	p.txn = t
+	p.pool = t.wal.pool
+	p.name = filepath.Base(p.file.Name())
+	p.maxPage = max(p.maxPage, t.pageCount(p.name))
+}
+
+/*
+AllocatePage assigns the next available page number and returns it.
+The page reads as blank until something is flushed to it.
 This should be called whenever a new row or B+Tree node needs to be stored.
 */
-func (p *Pager) AllocatePage() uint32 {
-	// Check if we need to evict a page first
-	p.evictPage()
-	
+func (p *Pager) AllocatePage() (uint32, error) {
+	if p.maxPage >= math.MaxUint32-headerPages {
+		return 0, fmt.Errorf("%s: %w", p.file.Name(), ErrFileFull)
+	}
 	pageNum := uint32(p.maxPage)
 	p.maxPage++ // Increment the page count for the next allocation
-	p.pages[pageNum] = make([]byte, PageSize)
-	return uint32(pageNum)
+	return pageNum, nil
+}
+
+/*
+Generation changes whenever a committing transaction writes one of the file's
+pages in place. A reader that needs several pages to agree with each other
+(the nodes of a B+Tree) compares it before and after reading them.
+Pagers outside a transaction always report 0.
+*/
+func (p *Pager) Generation() uint64 {
+	if p.txn == nil {
+		return 0
+	}
+	p.txn.wal.latch.RLock()
+	defer p.txn.wal.latch.RUnlock()
+	return p.txn.wal.gens[p.name]
 }
 
 /*
@@ -171,3 +223,18 @@ func (p *Pager) File() *os.File {
 func (p *Pager) PageCount() int {
 	return p.maxPage
 }
+
+// Close writes back the pages flushed outside a transaction and releases the underlying file.
+// A transaction keeps its pages until it commits, so a pager in one has nothing to write.
+// A temporary pager drops its pages and removes its file instead.
+func (p *Pager) Close() error {
+	if p.temp {
+		p.pool.Discard(p.file.Name())
+		return errors.Join(p.file.Close(), os.Remove(p.file.Name()))
+	}
+	var err error
+	if p.txn == nil {
+		err = p.pool.flush(p.file.Name())
+	}
+	return errors.Join(err, p.file.Close())
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustPager(t *testing.T, path string) *Pager {
+	t.Helper()
+	pager, err := NewPager(path, nil)
+	if err != nil {
+		t.Fatalf("NewPager: %v", err)
+	}
+	return pager
+}
+
+func mustPage(t *testing.T, p *Pager, pageNum uint32) []byte {
+	t.Helper()
+	page, err := p.GetPage(pageNum)
+	if err != nil {
+		t.Fatalf("GetPage(%d): %v", pageNum, err)
+	}
+	return page
+}
+
+func mustAllocate(t *testing.T, p *Pager) uint32 {
+	t.Helper()
+	pageNum, err := p.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	return pageNum
+}
+
+// Failures to open, read or allocate are returned, not panicked.
+func TestPagerErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewPager(dir, nil); err == nil {
+		t.Errorf("NewPager on a directory succeeded")
+	}
+	if _, err := NewPager(filepath.Join(dir, "missing", "t.db"), nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewPager in a missing directory: got %v, want ErrNotExist", err)
+	}
+
+	path := filepath.Join(dir, "t.db")
+	pager := mustPager(t, path)
+	if err := pager.FlushPage(mustAllocate(t, pager), pageWith("hello")); err != nil {
+		t.Fatal(err)
+	}
+	pager.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pager = mustPager(t, path)
+	defer pager.Close()
+	if _, err := pager.GetPage(0); !errors.Is(err, ErrCorruptPage) {
+		t.Errorf("GetPage of a damaged page: got %v, want ErrCorruptPage", err)
+	}
+	if _, _, err := pager.Pin(0); !errors.Is(err, ErrCorruptPage) {
+		t.Errorf("Pin of a damaged page: got %v, want ErrCorruptPage", err)
+	}
+
+	pager.maxPage = 1<<32 - 1
+	if _, err := pager.AllocatePage(); !errors.Is(err, ErrFileFull) {
+		t.Errorf("AllocatePage past the last page number: got %v, want ErrFileFull", err)
+	}
+}
@@ -0,0 +1,210 @@
+// BufferPool: the page cache shared by every file of a database.
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultPoolSize is the memory budget of a buffer pool when none is configured.
+const DefaultPoolSize = 4 << 20 // 4MB, 1024 pages
+
+// minPoolFrames keeps a tiny budget from thrashing on a single B+Tree descent.
+const minPoolFrames = 16
+
+/*
+BufferPool caches pages of any number of files in a fixed number of frames.
+Frames that nobody has pinned are kept in least recently used order and the
+oldest is replaced when a page has to be loaded into a full pool; a dirty
+victim is written back to its file first. Pinned frames are never replaced:
+if every frame is pinned the pool grows past its budget until one is unpinned.
+
+A frame's bytes are never changed in place. A write installs a new buffer, so
+whoever pinned the old one keeps reading a whole, consistent page.
+*/
+type BufferPool struct {
+	mu     sync.Mutex
+	size   int // frames the budget allows
+	frames map[frameKey]*frame
+	lru    list.List // unpinned frames, least recently used at the front
+}
+
+type frameKey struct {
+	path string // cleaned path of the file
+	page uint32
+}
+
+type frame struct {
+	key   frameKey
+	data  []byte
+	pins  int
+	dirty bool          // data is newer than the file and must be written back before the frame goes
+	file  *os.File      // where a dirty frame is written back
+	elem  *list.Element // position in lru while unpinned
+}
+
+// NewBufferPool returns a pool that caches up to budget bytes of pages.
+func NewBufferPool(budget int) *BufferPool {
+	return &BufferPool{
+		size:   max(budget/PageSize, minPoolFrames),
+		frames: make(map[frameKey]*frame),
+	}
+}
+
+func poolKey(path string, pageNum uint32) frameKey {
+	return frameKey{filepath.Clean(path), pageNum}
+}
+
+/*
+pin returns the frame holding a page, loading it with load on a miss, and
+keeps it in the pool until unpin. load runs under the pool's lock, so a page is
+never read from disk while a commit is writing it through the pool. The page
+image is returned with the frame: a write replaces the frame's data rather
+than changing it, so the image stays as it was for whoever pinned it.
+*/
+func (bp *BufferPool) pin(key frameKey, load func() ([]byte, error)) (*frame, []byte, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	f, ok := bp.frames[key]
+	if !ok {
+		if err := bp.makeRoom(); err != nil {
+			return nil, nil, err
+		}
+		data, err := load()
+		if err != nil {
+			return nil, nil, err
+		}
+		f = &frame{key: key, data: data}
+		bp.frames[key] = f
+	}
+	if f.pins == 0 && f.elem != nil {
+		bp.lru.Remove(f.elem)
+		f.elem = nil
+	}
+	f.pins++
+	return f, f.data, nil
+}
+
+// unpin releases a pin; a frame nobody pins becomes the most recently used candidate for replacement.
+func (bp *BufferPool) unpin(f *frame) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if f.pins--; f.pins == 0 && bp.frames[f.key] == f {
+		f.elem = bp.lru.PushBack(f)
+	}
+}
+
+// makeRoom evicts the least recently used unpinned frame if the pool is full. The caller holds mu.
+func (bp *BufferPool) makeRoom() error {
+	if len(bp.frames) < bp.size {
+		return nil
+	}
+	front := bp.lru.Front()
+	if front == nil {
+		return nil // everything is pinned
+	}
+	victim := front.Value.(*frame)
+	if victim.dirty {
+		if err := writeImage(victim.file, victim.key.page, victim.data); err != nil {
+			return fmt.Errorf("writing back page %d of %s: %w", victim.key.page, victim.key.path, err)
+		}
+	}
+	bp.lru.Remove(front)
+	delete(bp.frames, victim.key)
+	return nil
+}
+
+/*
+put installs a new image of a page. A dirty page is written back to file when
+it is evicted or its file is flushed; a clean one is already on disk and
+replaces the cached copy only if there is one.
+*/
+func (bp *BufferPool) put(key frameKey, data []byte, dirty bool, file *os.File) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.putLocked(key, data, dirty, file)
+}
+
+func (bp *BufferPool) putLocked(key frameKey, data []byte, dirty bool, file *os.File) error {
+	data = append([]byte(nil), data...)
+	f, ok := bp.frames[key]
+	if !ok {
+		if !dirty {
+			return nil
+		}
+		if err := bp.makeRoom(); err != nil {
+			return err
+		}
+		f = &frame{key: key}
+		bp.frames[key] = f
+		f.elem = bp.lru.PushBack(f)
+	}
+	f.data = data
+	f.dirty = f.dirty || dirty
+	if dirty {
+		f.file = file
+	}
+	if f.elem != nil {
+		bp.lru.MoveToBack(f.elem)
+	}
+	return nil
+}
+
+// writeThrough writes a page in place in file and into the cached copy at once, so nobody loads half of it.
+func (bp *BufferPool) writeThrough(key frameKey, data []byte, file *os.File) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if err := writeImage(file, key.page, data); err != nil {
+		return err
+	}
+	return bp.putLocked(key, data, false, file)
+}
+
+// writeImage seals a page image and writes it in place.
+func writeImage(file *os.File, pageNum uint32, image []byte) error {
+	seal(image)
+	_, err := file.WriteAt(image, pageOffset(pageNum))
+	return err
+}
+
+// flush writes back the dirty pages of one file. They stay cached, now clean.
+func (bp *BufferPool) flush(path string) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	path = filepath.Clean(path)
+	for key, f := range bp.frames {
+		if key.path != path || !f.dirty {
+			continue
+		}
+		if err := writeImage(f.file, key.page, f.data); err != nil {
+			return fmt.Errorf("writing back page %d of %s: %w", key.page, path, err)
+		}
+		f.dirty, f.file = false, nil
+	}
+	return nil
+}
+
+// Discard drops every cached page of a file without writing it back, for a file that is being deleted.
+func (bp *BufferPool) Discard(path string) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	path = filepath.Clean(path)
+	for key, f := range bp.frames {
+		if key.path == path {
+			if f.elem != nil {
+				bp.lru.Remove(f.elem)
+			}
+			delete(bp.frames, key)
+		}
+	}
+}
+
+// Cached returns how many pages the pool holds.
+func (bp *BufferPool) Cached() int {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return len(bp.frames)
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Dirty pages pushed out of a small pool are written back, pinned ones stay, and Close writes the rest.
+func TestBufferPool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t.db")
+	pool := NewBufferPool(0) // minPoolFrames pages
+	pager, err := NewPager(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 3 * minPoolFrames
+	for i := 0; i < n; i++ {
+		if err := pager.FlushPage(mustAllocate(t, pager), pageWith(fmt.Sprint("page ", i))); err != nil {
+			t.Fatalf("FlushPage(%d): %v", i, err)
+		}
+	}
+	if cached := pool.Cached(); cached > minPoolFrames {
+		t.Errorf("pool holds %d pages, budget is %d", cached, minPoolFrames)
+	}
+
+	// A second file opened on the pool shares its budget instead of adding its own
+	other, err := NewPager(filepath.Join(t.TempDir(), "u.db"), pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := other.FlushPage(mustAllocate(t, other), pageWith(fmt.Sprint("other ", i))); err != nil {
+			t.Fatalf("FlushPage(%d): %v", i, err)
+		}
+	}
+	if cached := pool.Cached(); cached > minPoolFrames {
+		t.Errorf("pool shared by two files holds %d pages, budget is %d", cached, minPoolFrames)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := os.ReadFile(path)
+	if err != nil || len(disk) < int(pageOffset(1)) || !bytes.HasPrefix(disk[pageOffset(0)+PageHeaderSize:], []byte("page 0")) {
+		t.Fatalf("evicted dirty page was not written back: %d bytes, %v", len(disk), err)
+	}
+
+	pinned, unpin, err := pager.Pin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < n; i++ {
+		if got := mustPage(t, pager, uint32(i)); !bytes.HasPrefix(got, []byte(fmt.Sprint("page ", i))) {
+			t.Fatalf("page %d reads %q...", i, got[:8])
+		}
+	}
+	again, unpinAgain, err := pager.Pin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &again[0] != &pinned[0] {
+		t.Errorf("pinned page was evicted")
+	}
+	unpinAgain()
+	unpin()
+
+	if err := pager.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := mustPager(t, path)
+	defer reopened.Close()
+	if reopened.PageCount() != n {
+		t.Fatalf("reopened file has %d pages, want %d", reopened.PageCount(), n)
+	}
+	for i := 0; i < n; i++ {
+		if got := mustPage(t, reopened, uint32(i)); !bytes.HasPrefix(got, []byte(fmt.Sprint("page ", i))) {
+			t.Fatalf("after Close, page %d reads %q...", i, got[:8])
+		}
+	}
+}
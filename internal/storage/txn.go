@@ -0,0 +1,94 @@
+// Txn: a transaction's private set of page writes.
+// Pagers joined to a transaction keep their flushed pages here instead of
+// writing them to disk (no-steal), so nothing a transaction did is visible in
+// the data files until Commit, and Rollback only has to forget the pages.
+package storage
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+// ErrTxnDone is returned when a transaction is used after Commit or Rollback.
+var ErrTxnDone = errors.New("transaction has already been committed or rolled back")
+
+type Txn struct {
+	wal   *WAL
+	pages map[string]map[uint32][]byte // dirty page images by file name, then page number
+	done  bool
+}
+
+// Begin starts a transaction whose pages are committed through this log.
+func (w *WAL) Begin() *Txn {
+	return &Txn{wal: w, pages: make(map[string]map[uint32][]byte)}
+}
+
+// Pool returns the buffer pool of the transaction's database, for the pagers that join it.
+func (t *Txn) Pool() *BufferPool {
+	return t.wal.pool
+}
+
+// page returns the transaction's version of a page, if it wrote one.
+func (t *Txn) page(name string, pageNum uint32) ([]byte, bool) {
+	data, ok := t.pages[name][pageNum]
+	return data, ok
+}
+
+// put records a page image written by the transaction.
+func (t *Txn) put(name string, pageNum uint32, data []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	file, ok := t.pages[name]
+	if !ok {
+		file = make(map[uint32][]byte)
+		t.pages[name] = file
+	}
+	file[pageNum] = append([]byte(nil), data...)
+	return nil
+}
+
+// pageCount is the number of pages the file has as far as the transaction can tell.
+func (t *Txn) pageCount(name string) int {
+	n := 0
+	for pageNum := range t.pages[name] {
+		n = max(n, int(pageNum)+1)
+	}
+	return n
+}
+
+/*
+Commit makes every page the transaction wrote durable as one unit: the page
+images and a commit record are appended to the log and fsynced together before
+any of them is written in place. A crash before the commit record is on disk
+loses the whole transaction; a crash after it is repaired by recovery.
+*/
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	if len(t.pages) == 0 {
+		return nil
+	}
+	var records []walRecord
+	for _, name := range slices.Sorted(maps.Keys(t.pages)) {
+		file := t.pages[name]
+		for _, pageNum := range slices.Sorted(maps.Keys(file)) {
+			records = append(records, walRecord{name: name, page: pageNum, data: file[pageNum]})
+		}
+	}
+	t.pages = nil
+	return t.wal.commit(records)
+}
+
+// Rollback discards every page the transaction wrote.
+func (t *Txn) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	t.pages = nil
+	return nil
+}
@@ -0,0 +1,290 @@
+// WAL: the write-ahead log that makes transactions atomic and crash safe.
+// Every page a transaction wrote is appended to the log and fsynced before it is
+// written in place, so a torn or lost in-place write can be redone on restart.
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WALFileName is the log file kept in each database directory.
+const WALFileName = "wal.log"
+
+// checkpointSize is how large the log may grow before it is checkpointed.
+const checkpointSize = 4 << 20 // 4MB
+
+/*
+Record format:
+
+	[crc32 uint32][kind uint8][name length uint16][page number uint32][file name][page image (PageSize bytes)]
+
+A transaction is logged as its page records followed by a commit record (kind
+walCommit, no name or image), all appended and fsynced together. The checksum
+covers everything after itself. A record with a bad checksum or a short read
+marks the torn end of the log; page records that are not followed by a commit
+record belong to a transaction that never committed and are ignored.
+
+Each commit takes the next log sequence number and stamps it into the header
+of every page it logs (see page.go). An emptied log starts with a checkpoint
+record whose body is the last LSN handed out, so numbering carries on across
+checkpoints and restarts.
+*/
+const walRecordHeader = 4 + 1 + 2 + 4
+
+const (
+	walPage       = 1
+	walCommit     = 2
+	walCheckpoint = 3
+)
+
+type walRecord struct {
+	name string
+	page uint32
+	data []byte
+}
+
+type WAL struct {
+	mu    sync.Mutex
+	latch sync.RWMutex // held for writing while a commit applies its pages
+	dir   string       // database directory; record file names are relative to it
+	file  *os.File
+	size  int64
+	files map[string]*os.File // data files written in place since the last checkpoint
+	gens  map[string]uint64   // per data file, how many pages have been written in place (under latch)
+	pool  *BufferPool         // pages of the directory's files, written through by commits
+	lsn   uint64              // log sequence number of the last commit
+}
+
+/*
+OpenWAL opens the log of a database directory and replays it before returning,
+so the data files reflect every transaction committed before the last shutdown or crash.
+Pagers joined to its transactions cache pages in pool.
+*/
+func OpenWAL(dir string, pool *BufferPool) (*WAL, error) {
+	file, err := os.OpenFile(filepath.Join(dir, WALFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	w := &WAL{dir: dir, file: file, files: make(map[string]*os.File), gens: make(map[string]uint64), pool: pool}
+	if err := w.recover(); err != nil {
+		w.closeFiles()
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+/*
+recover redoes every committed transaction in the log, syncs the touched files
+and empties the log. A log that does not start with a checkpoint record was
+written by a format 1 build (see header.go), whose pages it replays as they are.
+*/
+func (w *WAL) recover() error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var pending []walRecord
+	first, v1 := true, false
+	header := make([]byte, walRecordHeader)
+	for {
+		if _, err := io.ReadFull(w.file, header); err != nil {
+			break // clean end of log or torn header
+		}
+		sum := binary.LittleEndian.Uint32(header[0:4])
+		kind := header[4]
+		nameLen := int(binary.LittleEndian.Uint16(header[5:7]))
+		pageNum := binary.LittleEndian.Uint32(header[7:11])
+		var body []byte
+		switch kind {
+		case walPage:
+			body = make([]byte, nameLen+PageSize)
+		case walCheckpoint:
+			body = make([]byte, 8)
+		}
+		if _, err := io.ReadFull(w.file, body); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(append(header[4:], body...)) != sum {
+			break
+		}
+		if first {
+			first, v1 = false, kind != walCheckpoint
+		}
+		switch kind {
+		case walCheckpoint:
+			w.lsn = max(w.lsn, binary.LittleEndian.Uint64(body))
+			continue
+		case walCommit:
+			apply := w.apply
+			if v1 {
+				apply = w.applyV1
+			}
+			if err := apply(pending); err != nil {
+				return fmt.Errorf("wal recovery: %w", err)
+			}
+			for _, r := range pending {
+				w.lsn = max(w.lsn, pageLSN(r.data))
+			}
+			pending = pending[:0]
+			continue
+		}
+		pending = append(pending, walRecord{name: string(body[:nameLen]), page: pageNum, data: body[nameLen:]})
+	}
+	return w.checkpoint()
+}
+
+/*
+commit logs the pages of one transaction followed by a commit record, fsyncs
+the log and then writes the pages in place. records hold what the pagers' users
+see of each page; commit turns them into sealed images stamped with the new LSN.
+*/
+func (w *WAL) commit(records []walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size >= checkpointSize {
+		if err := w.checkpoint(); err != nil {
+			return err
+		}
+	}
+	lsn := w.lsn + 1
+	images := make([]walRecord, len(records))
+	var buf []byte
+	for i, r := range records {
+		if len(r.data) > UsablePageSize {
+			return fmt.Errorf("wal: page is %d bytes, at most %d fit", len(r.data), UsablePageSize)
+		}
+		image := pageImage(r.data, lsn)
+		seal(image)
+		images[i] = walRecord{name: r.name, page: r.page, data: image}
+		buf = appendRecord(buf, walPage, r.name, r.page, image)
+	}
+	buf = appendRecord(buf, walCommit, "", 0, nil)
+
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return fmt.Errorf("wal append: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("wal sync: %w", err)
+	}
+	w.size += int64(len(buf))
+	w.lsn = lsn
+	// The transaction is durable now; a failure below is repaired by recovery.
+	w.latch.Lock()
+	defer w.latch.Unlock()
+	return w.apply(images)
+}
+
+func appendRecord(buf []byte, kind byte, name string, pageNum uint32, data []byte) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, walRecordHeader)...)
+	rec := buf[start:]
+	rec[4] = kind
+	binary.LittleEndian.PutUint16(rec[5:7], uint16(len(name)))
+	binary.LittleEndian.PutUint32(rec[7:11], pageNum)
+	buf = append(buf, name...)
+	buf = append(buf, data...)
+	binary.LittleEndian.PutUint32(buf[start:start+4], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+// apply writes logged pages in place in their data files.
+func (w *WAL) apply(records []walRecord) error {
+	for _, r := range records {
+		f, err := w.open(r.name, true)
+		if err != nil {
+			return err
+		}
+		if err := w.pool.writeThrough(poolKey(filepath.Join(w.dir, r.name), r.page), r.data, f); err != nil {
+			return err
+		}
+		w.gens[r.name]++
+	}
+	return nil
+}
+
+// applyV1 writes pages logged by a format 1 build in place, in format 1 files.
+func (w *WAL) applyV1(records []walRecord) error {
+	for _, r := range records {
+		f, err := w.open(r.name, false)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(r.data, int64(r.page)*PageSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns the data file a record is written to, giving a new file its header if init is set.
+func (w *WAL) open(name string, init bool) (*os.File, error) {
+	if f, ok := w.files[name]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if init {
+		if err := initFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	w.files[name] = f
+	return f, nil
+}
+
+// Checkpoint makes every logged page durable in its data file and empties the log.
+func (w *WAL) Checkpoint() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.checkpoint()
+}
+
+func (w *WAL) checkpoint() error {
+	// Pages are written in place right after their transaction is logged,
+	// so syncing the files written since the last checkpoint is enough.
+	for name, f := range w.files {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("checkpoint: sync %s: %w", name, err)
+		}
+	}
+	w.closeFiles()
+	return w.truncate()
+}
+
+func (w *WAL) closeFiles() {
+	for name, f := range w.files {
+		f.Close()
+		delete(w.files, name)
+	}
+}
+
+// truncate empties the log down to a checkpoint record carrying the current LSN.
+func (w *WAL) truncate() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	var lsn [8]byte
+	binary.LittleEndian.PutUint64(lsn[:], w.lsn)
+	buf := appendRecord(nil, walCheckpoint, "", 0, lsn[:])
+	if _, err := w.file.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	w.size = int64(len(buf))
+	return w.file.Sync()
+}
+
+// Close checkpoints and closes the log.
+func (w *WAL) Close() error {
+	return errors.Join(w.Checkpoint(), w.file.Close())
+}
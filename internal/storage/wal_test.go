@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func pageWith(s string) []byte {
+	page := make([]byte, UsablePageSize)
+	copy(page, s)
+	return page
+}
+
+// A committed page is restored on the next open, even if the in-place write was lost;
+// pages of a transaction without a commit record are not.
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "t.db")
+
+	wal, err := OpenWAL(dir, NewBufferPool(DefaultPoolSize))
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	txn := wal.Begin()
+	pager := mustPager(t, path)
+	pager.Join(txn)
+	committed := pageWith("hello, wal")
+	if err := pager.FlushPage(mustAllocate(t, pager), committed); err != nil {
+		t.Fatalf("FlushPage: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	pager.Close()
+
+	// Simulate a crash: the in-place write never reached the disk, the log did.
+	if err := os.WriteFile(path, make([]byte, PageSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A transaction that was being logged when the crash hit: a page record with no commit record.
+	f, err := os.OpenFile(filepath.Join(dir, WALFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(appendRecord(nil, walPage, "t.db", 0, pageImage(pageWith("uncommitted"), 99)))
+	f.Write([]byte{1, 2, 3, 4, 5, 6, 7}) // torn tail
+	f.Close()
+
+	wal2, err := OpenWAL(dir, NewBufferPool(DefaultPoolSize))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer wal2.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := data[pageOffset(0):]
+	if !bytes.Equal(page[PageHeaderSize:PageSize], committed) {
+		t.Fatalf("page not recovered: %q", page[PageHeaderSize:PageHeaderSize+16])
+	}
+	if info, _ := os.Stat(filepath.Join(dir, WALFileName)); info.Size() != wal2.size {
+		t.Errorf("log not truncated after recovery: %d bytes", info.Size())
+	}
+	if wal2.lsn != 1 {
+		t.Errorf("LSN after recovery is %d, want 1", wal2.lsn)
+	}
+}
+
+// Committed pages carry their commit's LSN, numbering survives a restart, and a
+// flipped bit in a data file is reported as a corrupt page.
+func TestPageChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "t.db")
+	commit := func(wal *WAL, s string) {
+		txn := wal.Begin()
+		pager := mustPager(t, path)
+		defer pager.Close()
+		pager.Join(txn)
+		if pager.PageCount() == 0 {
+			mustAllocate(t, pager)
+		}
+		if err := pager.FlushPage(0, pageWith(s)); err != nil {
+			t.Fatalf("FlushPage: %v", err)
+		}
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+	onDisk := func() []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data[pageOffset(0):]
+	}
+
+	wal, err := OpenWAL(dir, NewBufferPool(DefaultPoolSize))
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	commit(wal, "one")
+	commit(wal, "two")
+	wal.Close()
+	if lsn := pageLSN(onDisk()); lsn != 2 {
+		t.Fatalf("page LSN is %d after two commits, want 2", lsn)
+	}
+	wal, err = OpenWAL(dir, NewBufferPool(DefaultPoolSize))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	commit(wal, "three")
+	wal.Close()
+	if lsn := pageLSN(onDisk()); lsn != 3 {
+		t.Fatalf("page LSN is %d after a restart and a third commit, want 3", lsn)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[pageOffset(0)+PageHeaderSize+100] ^= 0x10
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pager := mustPager(t, path)
+	defer pager.Close()
+	_, err = pager.ReadPage(0)
+	if !errors.Is(err, ErrCorruptPage) || !strings.Contains(err.Error(), path+" page 0") {
+		t.Fatalf("ReadPage of a damaged page: got %v, want ErrCorruptPage naming %s page 0", err, path)
+	}
+}
+
+// Rolled back pages never reach the file, and a later pager in the same transaction sees earlier writes.
+func TestTxnRollback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "t.db")
+	wal, err := OpenWAL(dir, NewBufferPool(DefaultPoolSize))
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	defer wal.Close()
+
+	txn := wal.Begin()
+	p1 := mustPager(t, path)
+	p1.Join(txn)
+	p1.FlushPage(mustAllocate(t, p1), pageWith("first"))
+	p1.Close()
+
+	p2 := mustPager(t, path)
+	p2.Join(txn)
+	if p2.PageCount() != 1 || !bytes.HasPrefix(mustPage(t, p2, 0), []byte("first")) {
+		t.Fatalf("second pager does not see the transaction's page")
+	}
+	p2.Close()
+
+	if err := txn.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != pageOffset(0) {
+		t.Fatalf("rolled back page reached the file: %d bytes", info.Size())
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Errorf("Commit after Rollback: got %v, want ErrTxnDone", err)
+	}
+}
+
+// A log left by a format 1 build is replayed into its format 1 files as they are, for the upgrade to pick up.
+func TestWALRecoveryFormat1(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "t.db")
+	if err := os.WriteFile(path, make([]byte, 2*PageSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := make([]byte, PageSize)
+	copy(old, "format 1 page")
+	log := appendRecord(nil, walPage, "t.db", 1, old)
+	log = appendRecord(log, walCommit, "", 0, nil)
+	if err := os.WriteFile(filepath.Join(dir, WALFileName), log, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err := OpenWAL(dir, NewBufferPool(DefaultPoolSize))
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	wal.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2*PageSize || !bytes.Equal(data[PageSize:], old) {
+		t.Fatalf("format 1 page not replayed in place: %d bytes, %q", len(data), data[PageSize:PageSize+16])
+	}
+}
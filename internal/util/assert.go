@@ -1,5 +1,7 @@
 package util
+
 func Assert(condition bool, message string) {
 	if !condition {
 		panic(message)
 	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 
 	par "github.com/razzat008/letsgodb/internal/Parser"
 	repl "github.com/razzat008/letsgodb/internal/REPl"
 	tok "github.com/razzat008/letsgodb/internal/Tokenizer"
-	catalog "github.com/razzat008/letsgodb/internal/catalog"
-	"github.com/razzat008/letsgodb/internal/db"
-	"github.com/razzat008/letsgodb/internal/storage"
+	"github.com/razzat008/letsgodb/internal/engine"
+	"github.com/razzat008/letsgodb/internal/server/httpapi"
+	"github.com/razzat008/letsgodb/internal/server/pgwire"
 )
 
 // to print help message
@@ -32,225 +36,161 @@ func printHelpall() {
 	println("  -> `CREATE DATABASE dbname;`")
 	println("  -> `USE dbname;`")
 	println("  -> `DROP DATABASE dbname;`")
-	println("  -> `CREATE TABLE tablename ( PRIMARY_KEY column1 , column2 );`")
+	println("  -> `CREATE TABLE tablename ( PRIMARY_KEY column1 INTEGER , column2 TEXT );`")
+	println("     column types: INTEGER, REAL, TEXT (default), BOOLEAN, BLOB")
 	println("  -> `DROP TABLE tablename`");
+	println("  -> `CREATE [UNIQUE] INDEX indexname ON tablename (column1, column2);`")
+	println("  -> `DROP INDEX indexname;`")
 	println("  -> `INSERT INTO tablename (column1, column2) VALUES (value1, value2);`")
+	println("  -> `SELECT column1, column2 FROM tablename WHERE column2 IS NOT NULL;`")
+	println("  -> `SELECT * FROM tablename ORDER BY column1 DESC, column2 ASC NULLS FIRST LIMIT 10 OFFSET 20;`")
+	println("  -> `EXPLAIN [ANALYZE] SELECT ...;` shows how a query is run (ANALYZE runs it and adds actual rows and times)")
+	println("  -> `UPDATE tablename SET column2 = value2 WHERE column1 = value1;`")
+	println("     an unquoted name is a column: `SET column2 = column3` copies the row's column3; quote text: `SET column2 = 'text'`")
+	println("  -> `DELETE FROM tablename WHERE column1 = value1;`")
+	println("  -> `BEGIN;` ... `COMMIT;` or `ROLLBACK;` to apply a batch of statements all at once or not at all")
 	println("  -> `SHOW DATABASES;`")
 	println("  -> `LIST TABLE; `")
 }
 
-// ExecuteStatement handles parsed statements and interacts with the catalog and row storage.
-func ExecuteStatement(stmt par.Statement, currentDB *string, cat **catalog.Catalog) error {
-	switch s := stmt.(type) {
-	case *par.CreateDatabaseStatement:
-		// CREATE DATABASE dbname;
-		dbDir := filepath.Join("data", s.DatabaseName)
-		err := os.MkdirAll(dbDir, 0755)
+// ExecuteStatement runs a parsed statement in the session and prints its result.
+func ExecuteStatement(stmt par.Statement, session *engine.Session) error {
+	if _, ok := stmt.(*par.SelectStatement); ok {
+		// rows are printed as the plan yields them
+		rows, err := session.Query(stmt)
 		if err != nil {
-			return fmt.Errorf("failed to create database directory: %w", err)
+			return err
 		}
-		// Create an empty catalog.db if not exists
-		catalogPath := filepath.Join(dbDir, "catalog.db")
-		if _, err := os.Stat(catalogPath); os.IsNotExist(err) {
-			f, ferr := os.Create(catalogPath)
-			if ferr != nil {
-				return fmt.Errorf("failed to create catalog.db: %w", ferr)
-			}
-			f.Close()
-		}
-		fmt.Printf("Database '%s' created.\n", s.DatabaseName)
-	case *par.UseDatabaseStatement:
-		// USE dbname;
-		dbDir := filepath.Join("data", s.DatabaseName)
-		catalogPath := filepath.Join(dbDir, "catalog.db")
-		if _, err := os.Stat(catalogPath); os.IsNotExist(err) {
-			return fmt.Errorf("database '%s' does not exist. Use CREATE DATABASE first.", s.DatabaseName)
-		}
-		newCat, err := catalog.NewCatalog(catalogPath)
-		if err != nil {
-			return fmt.Errorf("failed to load catalog for database '%s': %w", s.DatabaseName, err)
+		defer rows.Close()
+		fmt.Println(rows.Columns)
+		for rows.Next() {
+			fmt.Println(rows.Row().Strings())
 		}
-		*cat = newCat
-		*currentDB = s.DatabaseName
-		fmt.Printf("Switched to database '%s'.\n", s.DatabaseName)
-	case *par.CreateTableStatement:
-		// CREATE TABLE
-		if *currentDB == "" {
-			return fmt.Errorf("no database selected. Use CREATE DATABASE and USE first.")
-		}
-		err := (*cat).AddTable(s.TableName, s.Columns)
-		if err != nil {
-			return fmt.Errorf("CREATE TABLE failed: %w", err)
+		return rows.Err()
+	}
+	res, err := session.Execute(stmt)
+	if err != nil {
+		return err
+	}
+	switch stmt.(type) {
+	case *par.ExplainStatement:
+		for _, row := range res.Rows {
+			fmt.Println(row[0])
 		}
-		fmt.Println("Table created:", s.TableName)
 	case *par.ListTablesStatement:
-		if *currentDB == "" {
-			return fmt.Errorf("no database selected. Use CREATE DATABASE and USE first.")
-		}
-		tables := (*cat).ListTables()
-		if tables == nil {
+		if len(res.Rows) == 0 {
 			fmt.Println("Tables:Empty Database")
 		} else {
 			fmt.Println("Tables:")
-			for _, t := range tables {
-				fmt.Println(" -", t.Name, " : ", t.Columns)
-			}
-		}
-	case *par.DropStatement:
-		// DROP TABLE
-		if s.Table != "" {
-			if *currentDB == "" {
-				return fmt.Errorf("no database selected. Use CREATE DATABASE and USE first.")
-			}
-			// Remove table from catalog
-			err := (*cat).DropTable(s.Table)
-			if err != nil {
-				return fmt.Errorf("DROP TABLE failed: %w", err)
-			}
-			// Delete the table's data file
-			tablePath := filepath.Join("data", *currentDB, s.Table+".db")
-			if err := os.Remove(tablePath); err != nil && !os.IsNotExist(err) {
-				return fmt.Errorf("failed to delete table file: %w", err)
-			}
-			fmt.Printf("Table '%s' dropped.\n", s.Table)
-			return nil
-		}
-
-		// DROP DATABASE
-		if s.Database != "" {
-			if *currentDB == s.Database {
-				return fmt.Errorf("cannot drop the currently selected database ('%s'). Switch to another database first.", s.Database)
-			}
-			dbDir := filepath.Join("data", s.Database)
-			if _, err := os.Stat(dbDir); os.IsNotExist(err) {
-				return fmt.Errorf("database '%s' does not exist", s.Database)
-			}
-			// Remove the entire database directory and its contents
-			if err := os.RemoveAll(dbDir); err != nil {
-				return fmt.Errorf("failed to drop database '%s': %w", s.Database, err)
-			}
-			fmt.Printf("Database '%s' dropped.\n", s.Database)
-			return nil
-		}
-
-		return fmt.Errorf("DROP: no table or database specified")
-	case *par.SelectStatement:
-		// SELECT
-		if *currentDB == "" {
-			return fmt.Errorf("no database selected. Use CREATE DATABASE and USE first.")
-		}
-		schema := (*cat).GetTable(s.Table)
-		if schema == nil {
-			return fmt.Errorf("table %q does not exist", s.Table)
-		}
-		// Validate columns (skip if SELECT *)
-		if !(len(s.Columns) == 1 && s.Columns[0] == "*") {
-			if !db.ColumnsExist(schema.Columns, s.Columns) {
-				return fmt.Errorf("one or more selected columns do not exist in table %q", s.Table)
-			}
-		}
-		tablePath := filepath.Join("data", *currentDB, s.Table+".db")
-		pager := storage.NewPager(tablePath)
-		rows := db.ReadAllRows(pager)
-		// Print header
-		fmt.Println(schema.Columns)
-		for _, row := range rows {
-			if s.Where != nil && !db.EvalWhere(s.Where, schema.Columns, row) { // Skip rows that don't match the WHERE condition
-				continue
-			}
-			// SELECT *: print all columns
-			if len(s.Columns) == 1 && s.Columns[0] == "*" {
-				fmt.Println(row)
-			} else {
-				// Print only requested columns
-				var selected []string
-				for _, col := range s.Columns {
-					for i, schemaCol := range schema.Columns {
-						if col == schemaCol && i < len(row) {
-							selected = append(selected, row[i])
-						}
-					}
-				}
-				fmt.Println(selected)
-			}
-		}
-	case *par.InsertStatement:
-		// INSERT
-		if *currentDB == "" {
-			return fmt.Errorf("no database selected. Use CREATE DATABASE and USE first.")
-		}
-		schema := (*cat).GetTable(s.Table)
-		if schema == nil {
-			return fmt.Errorf("table %q does not exist", s.Table)
-		}
-		// Validate columns
-		if !db.ColumnsMatch(schema.Columns, s.Columns) {
-			return fmt.Errorf("column mismatch: expected %v, got %v", schema.Columns, s.Columns)
-		}
-		// Flatten [][]string to []string for storage
-		var flatValues []string
-		for _, v := range s.Values {
-			flatValues = append(flatValues, v...)
-		}
-		// primary key Implemenation
-		pkIndex := -1
-		for i, col := range schema.Columns {
-			if col == schema.PrimaryKey {
-				pkIndex = i
-				break
+			for _, t := range res.Rows {
+				fmt.Println(" -", t[0], " : ", "["+t[1].String()+"]")
 			}
 		}
-		tablePath := filepath.Join("data", *currentDB, s.Table+".db")
-		pager := storage.NewPager(tablePath)
-		if pkIndex != -1 {
-			rows := db.ReadAllRows(pager)
-			newPK := flatValues[pkIndex]
-			for _, row := range rows {
-				if row[pkIndex] == newPK {
-					return fmt.Errorf("duplicate primary key value '%s' for column '%s'", newPK, schema.PrimaryKey)
-				}
-			}
-			// Insert only if no duplicate
-			_, err := db.InsertRow(pager, flatValues)
-			if err != nil {
-				return fmt.Errorf("failed to insert row: %w", err)
-			}
-			fmt.Println("Row inserted!")
-		}
 	case *par.ShowDatabasesStatement:
-		entries, err := os.ReadDir("data")
-		if err != nil {
-			return fmt.Errorf("failed to list databases: %w", err)
-		}
-		if len(entries) == 0 {
+		if len(res.Rows) == 0 {
 			fmt.Println("Databases: No database found.\n Write CREATE DATABASE <database_name> to create one.")
 		} else {
 			fmt.Println("Databases:")
-			for _, entry := range entries {
-				if entry.IsDir() {
-					fmt.Println(" -", entry.Name())
-				}
+			for _, d := range res.Rows {
+				fmt.Println(" -", d[0])
 			}
 		}
 	default:
-		return fmt.Errorf("unsupported statement type")
+		fmt.Println(res.Message)
 	}
 	return nil
 }
 
+// serve runs `letsgodb serve`: a PostgreSQL wire-protocol server until interrupted.
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":5432", "address to listen on")
+	dataDir := flags.String("data", "data", "directory holding the databases")
+	cacheMB := flags.Int("cache", 4, "page cache per open database, in MB")
+	sortMB := flags.Int("sortmem", 16, "memory a sort may use before spilling to disk, in MB")
+	flags.Parse(args)
+
+	eng, err := engine.New(*dataDir)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	eng.PoolSize = *cacheMB << 20
+	eng.SortMemory = *sortMB << 20
+	server := &pgwire.Server{Engine: eng}
+	// On Ctrl-C, disconnect the clients (rolling back their open transactions) and checkpoint
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		server.Close()
+	}()
+	fmt.Printf("letsgodb listening on %s (PostgreSQL protocol)\n", *addr)
+	if err := server.ListenAndServe(*addr); err != nil {
+		fmt.Println("Error:", err)
+	}
+	server.Close()
+	eng.Close()
+}
+
+// serveHTTP runs `letsgodb http`: POST /query answers with JSON until interrupted.
+func serveHTTP(args []string) {
+	flags := flag.NewFlagSet("http", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	dataDir := flags.String("data", "data", "directory holding the databases")
+	cacheMB := flags.Int("cache", 4, "page cache per open database, in MB")
+	sortMB := flags.Int("sortmem", 16, "memory a sort may use before spilling to disk, in MB")
+	flags.Parse(args)
+
+	eng, err := engine.New(*dataDir)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	eng.PoolSize = *cacheMB << 20
+	eng.SortMemory = *sortMB << 20
+	server := &http.Server{Addr: *addr, Handler: &httpapi.Handler{Engine: eng}}
+	// On Ctrl-C, let running requests finish before the databases are closed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		<-interrupt
+		server.Shutdown(context.Background())
+		close(stopped)
+	}()
+	fmt.Printf("letsgodb listening on %s (HTTP, POST /query)\n", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fmt.Println("Error:", err)
+	} else {
+		<-stopped
+	}
+	eng.Close()
+}
+
 // main entry point of the program
 func main() {
-	// Track the current database and catalog
-	var currentDB string
-	var cat *catalog.Catalog
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "http" {
+		serveHTTP(os.Args[2:])
+		return
+	}
 
-	// Ensure data directory exists
-	_ = os.MkdirAll("data", 0755)
+	// Databases live under ./data; the session tracks the current one
+	eng, err := engine.New("data")
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	session := eng.NewSession()
 
 	lineBuffer := repl.InitLineBuffer()
 	printHelp()
 	for {
-		repl.PrintDB(currentDB)
+		repl.PrintDB(session.CurrentDatabase())
 		lineBuffer.UserInput()
 		input := string(lineBuffer.Buffer)
 		if input == "help;" {
@@ -262,6 +202,8 @@ func main() {
 			lineBuffer.Reset()
 			continue
 		} else if input == "\\e;" {
+			session.Close()
+			eng.Close()
 			println("Exiting letsgodb...")
 			println("Bye!!")
 			os.Exit(0)
@@ -276,7 +218,7 @@ func main() {
 			lineBuffer.Reset()
 			continue
 		}
-		err := ExecuteStatement(stmt, &currentDB, &cat)
+		err := ExecuteStatement(stmt, session)
 		if err != nil {
 			fmt.Println("Error:", err)
 		}
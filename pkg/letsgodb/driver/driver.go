@@ -0,0 +1,206 @@
+/*
+Package driver registers letsgodb with database/sql under the name "letsgodb".
+
+	import (
+		"database/sql"
+		_ "github.com/razzat008/letsgodb/pkg/letsgodb/driver"
+	)
+
+	db, err := sql.Open("letsgodb", "file:./data/mydb")
+
+The data source name is the database directory, optionally prefixed with
+"file:"; the database is created if it does not exist. Statements take no
+placeholder arguments: values are written into the SQL text.
+*/
+package driver
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+
+	par "github.com/razzat008/letsgodb/internal/Parser"
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+func init() {
+	sql.Register("letsgodb", &Driver{})
+}
+
+// Driver opens connections to letsgodb databases.
+type Driver struct{}
+
+// Open opens a connection to the database named by dsn ("file:./data/mydb" or "./data/mydb").
+func (d *Driver) Open(dsn string) (sqldriver.Conn, error) {
+	dir := strings.TrimPrefix(dsn, "file:")
+	dir, _, _ = strings.Cut(dir, "?")
+	if dir == "" {
+		return nil, errors.New("letsgodb: empty data source name")
+	}
+	session, err := engine.Connect(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{session: session}, nil
+}
+
+// conn is one session on the database. database/sql never uses a conn from two goroutines at once.
+type conn struct {
+	session *engine.Session
+}
+
+var errArgs = errors.New("letsgodb: statements do not take placeholder arguments")
+
+func (c *conn) Prepare(query string) (sqldriver.Stmt, error) {
+	stmt, err := engine.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmtHandle{conn: c, stmt: stmt}, nil
+}
+
+func (c *conn) Close() error {
+	return c.session.Close()
+}
+
+func (c *conn) Begin() (sqldriver.Tx, error) {
+	if _, err := c.session.Execute(&par.BeginStatement{}); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts sqldriver.TxOptions) (sqldriver.Tx, error) {
+	if opts.ReadOnly {
+		return nil, errors.New("letsgodb: read-only transactions are not supported")
+	}
+	if opts.Isolation != sqldriver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("letsgodb: only the default isolation level is supported")
+	}
+	return c.Begin()
+}
+
+// ExecContext and QueryContext skip the separate prepare step database/sql would otherwise take.
+func (c *conn) ExecContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Result, error) {
+	if len(args) > 0 {
+		return nil, errArgs
+	}
+	s, err := c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Exec(nil)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	if len(args) > 0 {
+		return nil, errArgs
+	}
+	s, err := c.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(nil)
+}
+
+// stmtHandle is a parsed statement; executing it again reuses the AST.
+type stmtHandle struct {
+	conn *conn
+	stmt par.Statement
+}
+
+func (s *stmtHandle) Close() error  { return nil }
+func (s *stmtHandle) NumInput() int { return 0 }
+
+func (s *stmtHandle) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
+	res, err := s.conn.session.Execute(s.stmt)
+	if err != nil {
+		return nil, err
+	}
+	return result(res.RowsAffected), nil
+}
+
+func (s *stmtHandle) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
+	r, err := s.conn.session.Query(s.stmt)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r}, nil
+}
+
+type result int
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("letsgodb: LastInsertId is not supported")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.session.Execute(&par.CommitStatement{})
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.session.Execute(&par.RollbackStatement{})
+	return err
+}
+
+// rows streams a statement's result and reports column metadata from the catalog types.
+type rows struct {
+	*engine.Rows
+}
+
+func (r *rows) Columns() []string {
+	return r.Rows.Columns
+}
+
+func (r *rows) Next(dest []sqldriver.Value) error {
+	if !r.Rows.Next() {
+		if err := r.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range r.Row() {
+		dest[i] = v.Any()
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the declared column type, e.g. "INTEGER".
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return string(r.Types[index])
+}
+
+// ColumnTypeScanType returns the Go type values of the column are returned as.
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.Types[index] {
+	case catalog.TypeInteger:
+		return reflect.TypeFor[int64]()
+	case catalog.TypeReal:
+		return reflect.TypeFor[float64]()
+	case catalog.TypeBoolean:
+		return reflect.TypeFor[bool]()
+	case catalog.TypeBlob:
+		return reflect.TypeFor[[]byte]()
+	default:
+		return reflect.TypeFor[string]()
+	}
+}
+
+// ColumnTypeNullable reports that any column may hold NULL.
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return true, true
+}
@@ -0,0 +1,125 @@
+package driver
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDatabaseSQL(t *testing.T) {
+	db, err := sql.Open("letsgodb", "file:"+filepath.Join(t.TempDir(), "mydb"))
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE users (PRIMARY_KEY id INTEGER, name TEXT, active BOOLEAN)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+
+	// a failed transaction leaves nothing behind
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	tx.Exec("INSERT INTO users (id, name, active) VALUES (1, 'alice', true)")
+	if _, err := tx.Exec("INSERT INTO users (id, name, active) VALUES (1, 'again', true)"); err == nil {
+		t.Fatalf("expected duplicate key error")
+	}
+	tx.Rollback()
+
+	tx, _ = db.Begin()
+	for _, q := range []string{
+		"INSERT INTO users (id, name, active) VALUES (1, 'alice', true)",
+		"INSERT INTO users (id, name, active) VALUES (2, 'bob', NULL)",
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	res, err := db.Exec("UPDATE users SET active = false WHERE active IS NULL")
+	if err != nil {
+		t.Fatalf("UPDATE: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Errorf("RowsAffected = %d, want 1", n)
+	}
+
+	rows, err := db.Query("SELECT id, name, active FROM users")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+	types, _ := rows.ColumnTypes()
+	if types[0].DatabaseTypeName() != "INTEGER" || types[2].DatabaseTypeName() != "BOOLEAN" {
+		t.Errorf("column types = %s, %s", types[0].DatabaseTypeName(), types[2].DatabaseTypeName())
+	}
+	count := 0
+	for rows.Next() {
+		var id int
+		var name string
+		var active sql.NullBool
+		if err := rows.Scan(&id, &name, &active); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		count++
+		if id == 2 && (name != "bob" || !active.Valid || active.Bool) {
+			t.Errorf("row 2 = %d %s %v", id, name, active)
+		}
+	}
+	if count != 2 {
+		t.Errorf("got %d rows, want 2", count)
+	}
+}
+
+func TestConcurrentConns(t *testing.T) {
+	db, err := sql.Open("letsgodb", "file:"+filepath.Join(t.TempDir(), "mydb"))
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(8)
+	if _, err := db.Exec("CREATE TABLE events (PRIMARY_KEY id INTEGER, kind TEXT)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if _, err := db.Exec(fmt.Sprintf("INSERT INTO events (id, kind) VALUES (%d, 'click')", g*10+i)); err != nil {
+					t.Errorf("INSERT: %v", err)
+					return
+				}
+				rows, err := db.Query("SELECT id FROM events WHERE kind = 'click'")
+				if err != nil {
+					t.Errorf("SELECT: %v", err)
+					return
+				}
+				rows.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	rows, err := db.Query("SELECT id FROM events")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	for rows.Next() {
+		n++
+	}
+	rows.Close()
+	if n != 80 {
+		t.Errorf("got %d rows, want 80", n)
+	}
+}
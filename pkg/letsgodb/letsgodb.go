@@ -0,0 +1,248 @@
+/*
+Package letsgodb embeds the letsgodb engine in a Go program.
+
+	db, err := letsgodb.Open("./data/mydb")
+	if err != nil { ... }
+	defer db.Close()
+
+	db.Exec("CREATE TABLE users (PRIMARY_KEY id INTEGER, name TEXT);")
+	n, err := db.Exec("INSERT INTO users (id, name) VALUES (1, 'alice');")
+
+	rows, err := db.Query("SELECT * FROM users WHERE id = 1;")
+	for rows.Next() {
+		var id int64
+		var name string
+		rows.Scan(&id, &name)
+	}
+
+Statements are the same ones the REPL accepts; the trailing semicolon is optional.
+*/
+package letsgodb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/razzat008/letsgodb/internal/db"
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+// DB is an open database. It is safe for use by multiple goroutines; statements run one at a time.
+type DB struct {
+	mu      sync.Mutex
+	session *engine.Session
+}
+
+/*
+Open opens the database stored in dir, creating it if it does not exist.
+Its parent directory plays the role of the REPL's data directory, so
+USE and CREATE DATABASE reach the databases next to it.
+*/
+func Open(dir string) (*DB, error) {
+	session, err := engine.Connect(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{session: session}, nil
+}
+
+// Exec runs a statement that returns no rows and reports how many rows it inserted, updated or deleted.
+func (d *DB) Exec(sql string) (int64, error) {
+	res, err := d.exec(sql)
+	if err != nil {
+		return 0, err
+	}
+	return int64(res.RowsAffected), nil
+}
+
+/*
+Query runs a statement that returns rows, such as SELECT. The rows of a
+SELECT are read from the database as Next asks for them; until they have all
+been read or Close is called, the query holds its table locks, and another
+statement on the DB reads what is left of them into memory first.
+*/
+func (d *DB) Query(sql string) (*Rows, error) {
+	stmt, err := engine.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session == nil {
+		return nil, ErrClosed
+	}
+	rows, err := d.session.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{d: d, rows: rows}, nil
+}
+
+func (d *DB) exec(sql string) (*engine.Result, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session == nil {
+		return nil, ErrClosed
+	}
+	return d.session.Exec(sql)
+}
+
+// ErrClosed is returned when a closed DB is used.
+var ErrClosed = errors.New("letsgodb: database is closed")
+
+// Close rolls back an unfinished transaction and closes the database.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session == nil {
+		return nil
+	}
+	err := d.session.Close()
+	d.session = nil
+	return err
+}
+
+// Rows iterates over the result of a query.
+type Rows struct {
+	d    *DB
+	rows *engine.Rows
+	row  db.Row // nil before the first row and after the last
+}
+
+// Columns returns the names of the result columns.
+func (r *Rows) Columns() []string {
+	return r.rows.Columns
+}
+
+// Next advances to the next row, returning false when there are no more.
+func (r *Rows) Next() bool {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.row = nil
+	if r.rows.Next() {
+		r.row = r.rows.Row()
+	}
+	return r.row != nil
+}
+
+// Values returns the current row as int64, float64, bool, string, []byte or nil (NULL).
+func (r *Rows) Values() []any {
+	if r.row == nil {
+		return nil
+	}
+	out := make([]any, len(r.row))
+	for i, v := range r.row {
+		out[i] = v.Any()
+	}
+	return out
+}
+
+/*
+Scan copies the columns of the current row into dest, which must hold one
+pointer per column. A pointer to the column's own Go type (see Values) or to
+any receives the value; a NULL leaves the zero value unless dest is *any.
+Numbers may also go into another integer or float type, but only when they fit:
+a value out of range, a negative one for an unsigned type or a REAL with a
+fraction for an integer type is an error.
+*/
+func (r *Rows) Scan(dest ...any) error {
+	values := r.Values()
+	if values == nil {
+		return errors.New("letsgodb: Scan called without a current row")
+	}
+	if len(dest) != len(values) {
+		return fmt.Errorf("letsgodb: expected %d destination arguments in Scan, got %d", len(values), len(dest))
+	}
+	for i, v := range values {
+		if p, ok := dest[i].(*any); ok {
+			*p = v
+			continue
+		}
+		target := reflect.ValueOf(dest[i])
+		if target.Kind() != reflect.Pointer || target.IsNil() {
+			return fmt.Errorf("letsgodb: Scan destination %d is not a non-nil pointer", i)
+		}
+		elem := target.Elem()
+		if v == nil {
+			elem.SetZero()
+			continue
+		}
+		src := reflect.ValueOf(v)
+		if !src.Type().AssignableTo(elem.Type()) {
+			// numbers may be scanned into another numeric type, e.g. an INTEGER into an int
+			if !numeric(src.Kind()) || !numeric(elem.Kind()) {
+				return fmt.Errorf("letsgodb: cannot scan %T into %s (column %q)", v, elem.Type(), r.rows.Columns[i])
+			}
+			var ok bool
+			if src, ok = convertNumber(src, elem.Type()); !ok {
+				return fmt.Errorf("letsgodb: %v does not fit in %s (column %q)", v, elem.Type(), r.rows.Columns[i])
+			}
+		}
+		elem.Set(src)
+	}
+	return nil
+}
+
+func numeric(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uint64 || k == reflect.Float32 || k == reflect.Float64
+}
+
+// convertNumber converts src to the numeric type t; ok is false if the value would change on the way.
+func convertNumber(src reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	out := reflect.New(t).Elem()
+	switch {
+	case out.CanInt():
+		n, ok := integer(src)
+		if !ok || out.OverflowInt(n) {
+			return out, false
+		}
+		out.SetInt(n)
+	case out.CanUint():
+		n, ok := integer(src)
+		if !ok || n < 0 || out.OverflowUint(uint64(n)) {
+			return out, false
+		}
+		out.SetUint(uint64(n))
+	default:
+		f := src.Convert(t).Float()
+		if src.CanFloat() && out.OverflowFloat(src.Float()) {
+			return out, false
+		}
+		out.SetFloat(f)
+	}
+	return out, true
+}
+
+// integer returns a number's value as an int64, unless it is a float with a fraction or out of range.
+func integer(v reflect.Value) (int64, bool) {
+	switch {
+	case v.CanInt():
+		return v.Int(), true
+	case v.CanUint():
+		return int64(v.Uint()), v.Uint() <= math.MaxInt64
+	}
+	f := v.Float()
+	// float64(math.MaxInt64) rounds up to 2^63, which is already out of range
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// Err returns the error met while iterating, if any.
+func (r *Rows) Err() error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return r.rows.Err()
+}
+
+// Close releases the rows, ending the query if it has rows left.
+func (r *Rows) Close() error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.row = nil
+	return r.rows.Close()
+}
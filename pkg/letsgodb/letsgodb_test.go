@@ -0,0 +1,280 @@
+package letsgodb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/razzat008/letsgodb/internal/catalog"
+	"github.com/razzat008/letsgodb/internal/engine"
+)
+
+func TestOpenExecQuery(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mydb")
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (PRIMARY_KEY id INTEGER, name TEXT, score REAL)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	for _, q := range []string{
+		"INSERT INTO users (id, name, score) VALUES (1, 'alice', 9.5);",
+		"INSERT INTO users (id, name, score) VALUES (2, 'bob', NULL);",
+		"INSERT INTO users (id, name, score) VALUES (3, 'carol', 7);",
+	} {
+		if n, err := db.Exec(q); err != nil || n != 1 {
+			t.Fatalf("%s: %d, %v", q, n, err)
+		}
+	}
+	if n, err := db.Exec("UPDATE users SET score = 8 WHERE id >= 2"); err != nil || n != 2 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	if _, err := db.Exec("SELEC * FROM users"); err == nil {
+		t.Errorf("expected a syntax error")
+	}
+	db.Close()
+
+	// reopen and read back
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT name, id, score FROM users WHERE score > 7.5")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+	if cols := rows.Columns(); len(cols) != 3 || cols[0] != "name" || cols[1] != "id" {
+		t.Fatalf("Columns() = %v", cols)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		var id int
+		var score float64
+		if err := rows.Scan(&name, &id, &score); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		names = append(names, name)
+	}
+	if len(names) != 3 || names[0] != "alice" || names[2] != "carol" {
+		t.Errorf("rows = %v", names)
+	}
+
+	// a statement run while a query is open reads the rest of its rows first, as they were
+	rows, err = db.Query("SELECT id, score FROM users")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if !rows.Next() {
+		t.Fatalf("no rows: %v", rows.Err())
+	}
+	if n, err := db.Exec("UPDATE users SET score = 1"); err != nil || n != 3 {
+		t.Fatalf("UPDATE during a query: %d, %v", n, err)
+	}
+	n := 1
+	for ; rows.Next(); n++ {
+		if score := rows.Values()[1]; score != 8.0 {
+			t.Errorf("open query sees score %v, want 8", score)
+		}
+	}
+	if err := rows.Close(); err != nil || n != 3 {
+		t.Errorf("open query read %d rows, %v", n, err)
+	}
+}
+
+// string literals keep their spaces, commas and semicolons, however long they are
+func TestQuotedLiterals(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "mydb"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE docs (PRIMARY_KEY id INTEGER, body TEXT)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	body := strings.Repeat("hello world, a,b ( = ); ", 400)
+	if n, err := db.Exec("INSERT INTO docs (id, body) VALUES (1, '" + body + "')"); err != nil || n != 1 {
+		t.Fatalf("INSERT of a %d byte value: %d, %v", len(body), n, err)
+	}
+	if n, err := db.Exec("UPDATE docs SET body = 'hello world' WHERE id = 1"); err != nil || n != 1 {
+		t.Fatalf("UPDATE: %d, %v", n, err)
+	}
+	if n, err := db.Exec("INSERT INTO docs (id, body) VALUES (2, '" + body + "')"); err != nil || n != 1 {
+		t.Fatalf("INSERT: %d, %v", n, err)
+	}
+	if n, err := db.Exec("INSERT INTO docs (id, body) VALUES (3, 'O''Brien')"); err != nil || n != 1 {
+		t.Fatalf("INSERT of a quote: %d, %v", n, err)
+	}
+	quoted, err := db.Query("SELECT body FROM docs WHERE body = 'O''Brien'")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var name string
+	if !quoted.Next() || quoted.Scan(&name) != nil || name != "O'Brien" {
+		t.Errorf("'O''Brien' reads back as %q, %v", name, quoted.Err())
+	}
+	quoted.Close()
+	rows, err := db.Query("SELECT body FROM docs WHERE body != 'a,b' AND id < 3")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		got = append(got, s)
+	}
+	if len(got) != 2 || got[0] != "hello world" || got[1] != body {
+		t.Errorf("read back %d rows, first %.20q", len(got), got)
+	}
+}
+
+// numbers go into any integer or float type they fit in, and are refused otherwise
+func TestScanNumbers(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "mydb"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE nums (PRIMARY_KEY id INTEGER, n INTEGER, x REAL)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	for _, q := range []string{
+		"INSERT INTO nums (id, n, x) VALUES (1, 200, 3.0)",
+		"INSERT INTO nums (id, n, x) VALUES (2, -1, 2.5)",
+		"INSERT INTO nums (id, n, x) VALUES (3, 300, 1e300)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	scan := func(id int, dest ...any) error {
+		t.Helper()
+		rows, err := db.Query(fmt.Sprintf("SELECT n, x FROM nums WHERE id = %d", id))
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatalf("row %d missing: %v", id, rows.Err())
+		}
+		return rows.Scan(dest...)
+	}
+
+	var u8 uint8
+	var i16 int16
+	var f32 float32
+	if err := scan(1, &u8, &i16); err != nil || u8 != 200 || i16 != 3 {
+		t.Errorf("row 1 into uint8, int16: %d, %d, %v", u8, i16, err)
+	}
+	if err := scan(1, &i16, &f32); err != nil || i16 != 200 || f32 != 3 {
+		t.Errorf("row 1 into int16, float32: %d, %v, %v", i16, f32, err)
+	}
+
+	var i int
+	var u uint
+	var ptr uintptr
+	var f float64
+	for _, c := range []struct {
+		id   int
+		dest []any
+	}{
+		{3, []any{&u8, &f}},  // 300 overflows a uint8
+		{2, []any{&u, &f}},   // -1 into a uint
+		{2, []any{&i, &i}},   // 2.5 loses its fraction
+		{3, []any{&i, &f32}}, // 1e300 overflows a float32
+		{3, []any{&i, &i}},   // 1e300 overflows an int
+		{1, []any{&ptr, &f}}, // a uintptr is not a number
+	} {
+		if err := scan(c.id, c.dest...); err == nil {
+			t.Errorf("row %d into %T, %T: no error", c.id, c.dest[0], c.dest[1])
+		}
+	}
+}
+
+// table and index names become file names, so they may not lead out of the database directory
+func TestInvalidNames(t *testing.T) {
+	root := t.TempDir()
+	db, err := Open(filepath.Join(root, "mydb"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (PRIMARY_KEY id INTEGER)"); err != nil {
+		t.Fatalf("CREATE TABLE: %v", err)
+	}
+	for _, q := range []string{
+		"CREATE TABLE ../x (PRIMARY_KEY id INTEGER)",
+		"CREATE TABLE .. (PRIMARY_KEY id INTEGER)",
+		`CREATE TABLE a\b (PRIMARY_KEY id INTEGER)`,
+		"CREATE INDEX ../ix ON t (id)",
+		"CREATE INDEX ix ON ../t (id)",
+		"DROP INDEX ../ix",
+		"DROP TABLE ../mydb/t",
+	} {
+		if _, err := db.Exec(q); !errors.Is(err, engine.ErrInvalidName) {
+			t.Errorf("%s: %v, want an invalid name error", q, err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(root, "*")); len(files) != 1 {
+		t.Errorf("files created outside the database directory: %v", files)
+	}
+	if _, err := db.Exec("INSERT INTO t (id) VALUES (1)"); err != nil {
+		t.Errorf("table t damaged: %v", err)
+	}
+}
+
+// an unquoted SET value is always a column and a quoted one always text, whatever columns the table has
+func TestUpdateColumnReferences(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "mydb"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	for _, q := range []string{
+		"CREATE TABLE t (PRIMARY_KEY id INTEGER, status TEXT, active TEXT)",
+		"CREATE TABLE u (PRIMARY_KEY id INTEGER, status TEXT)",
+		"INSERT INTO t (id, status, active) VALUES (1, 'new', 'yes')",
+		"INSERT INTO u (id, status) VALUES (1, 'new')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	status := func(table string) string {
+		t.Helper()
+		rows, err := db.Query("SELECT status FROM " + table)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		defer rows.Close()
+		var s string
+		if !rows.Next() || rows.Scan(&s) != nil {
+			t.Fatalf("no row in %s: %v", table, rows.Err())
+		}
+		return s
+	}
+
+	for _, table := range []string{"t", "u"} {
+		if _, err := db.Exec("UPDATE " + table + " SET status = 'active'"); err != nil || status(table) != "active" {
+			t.Errorf("%s: SET status = 'active' gave %q, %v", table, status(table), err)
+		}
+	}
+	if _, err := db.Exec("UPDATE t SET status = active"); err != nil || status("t") != "yes" {
+		t.Errorf("SET status = active gave %q, %v; want the active column", status("t"), err)
+	}
+	if _, err := db.Exec("UPDATE u SET status = active"); !errors.Is(err, catalog.ErrNoSuchColumn) {
+		t.Errorf("SET status = active without an active column: %v, want no such column", err)
+	}
+	if _, err := db.Exec("UPDATE u SET id = -2, status = NULL"); err != nil {
+		t.Errorf("SET with a negative number and NULL: %v", err)
+	}
+}